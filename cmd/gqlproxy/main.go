@@ -10,8 +10,11 @@ import (
 	"syscall"
 
 	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/healthcheck"
 	"github.com/abdullah2993/graphql-proxy/pkgs/logging"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metricsexport"
 	"github.com/abdullah2993/graphql-proxy/pkgs/proxy"
+	"github.com/abdullah2993/graphql-proxy/pkgs/tracing"
 )
 
 var (
@@ -28,12 +31,44 @@ func main() {
 		os.Exit(1)
 	}
 
-	logger := logging.NewLogger(cfg.Logging.Level, cfg.Logging.Format, cfg.Logging.Output)
+	redactor, err := logging.NewRedactor(cfg.Redaction)
+	if err != nil {
+		slog.Error("failed to create redactor", "error", err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.NewLogger(cfg.Logging, redactor)
+	if err != nil {
+		slog.Error("failed to create logger", "error", err)
+		os.Exit(1)
+	}
 	slog.SetDefault(logger)
 
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	logger.Info("starting GraphQL proxy server", "address", *addr)
 
-	proxy := proxy.NewProxy(cfg, logger)
+	proxy, err := proxy.NewProxy(cfg, logger, redactor)
+	if err != nil {
+		logger.Error("failed to create proxy", "error", err)
+		os.Exit(1)
+	}
+
+	stopHealthCheck := healthcheck.Start(cfg.Health, proxy.LoadBalancer(), proxy.Metrics(), logger)
+	defer stopHealthCheck()
+
+	stopExport, err := metricsexport.Start(context.Background(), cfg.Export, proxy.Metrics())
+	if err != nil {
+		logger.Error("failed to start metrics export", "error", err)
+		os.Exit(1)
+	}
+	defer stopExport(context.Background())
+
 	server := &http.Server{
 		Addr:    *addr,
 		Handler: http.HandlerFunc(proxy.Handler),
@@ -41,9 +76,25 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", http.HandlerFunc(proxy.MetricsHandler))
+	mux.Handle("/metrics/prometheus", http.HandlerFunc(proxy.PrometheusHandler))
+	mux.Handle("/admin/usage", proxy.AdminHandler(proxy.UsageHandler))
+	mux.Handle("/admin/upstreams", proxy.AdminHandler(proxy.UpstreamsHandler))
 	mux.Handle("/v1/graphql", http.HandlerFunc(proxy.Handler))
 	server.Handler = mux
 
+	// Reopen log files moved away by logrotate
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGUSR1)
+		for range sigChan {
+			if err := logging.Reopen(); err != nil {
+				logger.Error("failed to reopen log files", "error", err)
+				continue
+			}
+			logger.Info("reopened log files")
+		}
+	}()
+
 	// Graceful shutdown
 	go func() {
 		sigChan := make(chan os.Signal, 1)
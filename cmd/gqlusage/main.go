@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/usage"
+)
+
+var (
+	addr      = flag.String("addr", "http://localhost:8080", "address of the GraphQL proxy")
+	field     = flag.String("field", "", "schema coordinate to export, e.g. User or User.legacyId")
+	client    = flag.String("client", "", "only export usage of this client")
+	operation = flag.String("operation", "", "only export usage of this operation")
+	format    = flag.String("format", "csv", "output format (csv, json)")
+	output    = flag.String("output", "", "output file (default stdout)")
+	token     = flag.String("token", os.Getenv("GQLPROXY_ADMIN_TOKEN"), "admin token of the GraphQL proxy (default $GQLPROXY_ADMIN_TOKEN)")
+)
+
+type usageResponse struct {
+	Total  int64              `json:"total"`
+	Fields []usage.FieldUsage `json:"fields"`
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		slog.Error("failed to export usage", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	q := url.Values{}
+	q.Set("field", *field)
+	q.Set("client", *client)
+	q.Set("operation", *operation)
+
+	req, err := http.NewRequest(http.MethodGet, *addr+"/admin/usage?"+q.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("fetching usage: %w", err)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching usage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("fetching usage: %s: %s", resp.Status, body)
+	}
+
+	var stats usageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return fmt.Errorf("decoding usage: %w", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"type", "field", "client", "operation", "count"})
+		for _, f := range stats.Fields {
+			cw.Write([]string{f.Type, f.Field, f.Client, f.Operation, strconv.FormatInt(f.Count, 10)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported format: %s", *format)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/usage" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("field") != "User.legacyId" || q.Get("client") != "ios" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"total":3,"fields":[{"type":"User","field":"legacyId","client":"ios","operation":"Q","count":3}]}`))
+	}))
+	defer s.Close()
+
+	*addr, *field, *client, *token = s.URL, "User.legacyId", "ios", "secret"
+	dir := t.TempDir()
+
+	*format, *output = "csv", filepath.Join(dir, "usage.csv")
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(*output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "type,field,client,operation,count\nUser,legacyId,ios,Q,3\n"; string(out) != want {
+		t.Errorf("csv:\ngot  %q\nwant %q", out, want)
+	}
+
+	*format, *output = "json", filepath.Join(dir, "usage.json")
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	out, err = os.ReadFile(*output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats usageResponse
+	if err := json.Unmarshal(out, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 3 || len(stats.Fields) != 1 || stats.Fields[0].Count != 3 {
+		t.Errorf("json: %s", out)
+	}
+
+	*format = "xml"
+	if err := run(); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+
+	*token = "wrong"
+	if err := run(); err == nil {
+		t.Error("expected an error for a rejected token")
+	}
+}
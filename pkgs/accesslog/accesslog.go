@@ -0,0 +1,179 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/logging"
+)
+
+// Outcomes used to sample entries.
+const (
+	OutcomeSuccess     = "success"
+	OutcomeClientError = "client_error"
+	OutcomeServerError = "server_error"
+)
+
+// Entry describes a proxied request.
+type Entry struct {
+	Time            time.Time
+	RequestID       string
+	RemoteAddr      string
+	Client          string
+	UserAgent       string
+	Referer         string
+	Method          string
+	Path            string
+	Protocol        string
+	Status          int
+	BytesIn         int64
+	BytesOut        int64
+	Duration        time.Duration
+	Upstream        string
+	UpstreamLatency time.Duration
+	Retries         int
+	OperationType   string
+	OperationName   string
+	OperationHash   string
+	Error           string
+	TraceID         string
+	GraphQLErrors   int
+	Partial         bool
+	ErrorCodes      []string
+	ErrorClass      string
+}
+
+// Outcome classifies the entry by its status and error. Responses with GraphQL errors, and
+// requests that failed after their status was written, are server errors unless the
+// status is a 4xx.
+func (e Entry) Outcome() string {
+	switch {
+	case e.Status >= 500 || ((e.Error != "" || e.GraphQLErrors > 0) && e.Status < 400):
+		return OutcomeServerError
+	case e.Status >= 400:
+		return OutcomeClientError
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// Logger writes access log entries to their own sink. A nil Logger discards every entry.
+type Logger struct {
+	logger   *slog.Logger
+	format   string
+	fields   map[string]bool
+	sampling map[string]float64
+	slow     time.Duration
+}
+
+// New creates the access log described by cfg. Entries are redacted by redactor, which may
+// be nil.
+func New(cfg config.AccessLogConfig, redactor *logging.Redactor) (*Logger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	w, err := logging.OpenOutput(cfg.Output, cfg.Rotation)
+	if err != nil {
+		return nil, fmt.Errorf("opening access log output: %w", err)
+	}
+
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(w, nil)
+	} else {
+		handler = newCLFHandler(w, cfg.Format == "combined")
+	}
+
+	l := &Logger{
+		logger: slog.New(redactor.Handler(handler)),
+		format: cfg.Format,
+		sampling: map[string]float64{
+			OutcomeSuccess:     *cfg.Sampling.Success,
+			OutcomeClientError: *cfg.Sampling.ClientError,
+			OutcomeServerError: *cfg.Sampling.ServerError,
+		},
+		slow: cfg.SlowThreshold,
+	}
+	if len(cfg.Fields) > 0 {
+		l.fields = make(map[string]bool, len(cfg.Fields))
+		for _, f := range cfg.Fields {
+			l.fields[f] = true
+		}
+	}
+
+	return l, nil
+}
+
+// Log writes the entry if it is sampled. Slow requests are always written.
+func (l *Logger) Log(ctx context.Context, e Entry) {
+	if l == nil {
+		return
+	}
+
+	outcome := e.Outcome()
+	slow := l.slow > 0 && e.Duration >= l.slow
+	if !slow && rand.Float64() >= l.sampling[outcome] {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 24)
+	add := func(a slog.Attr) {
+		if l.include(a.Key) {
+			attrs = append(attrs, a)
+		}
+	}
+
+	add(slog.String("request_id", e.RequestID))
+	add(slog.String("remote_addr", e.RemoteAddr))
+	add(slog.String("client", e.Client))
+	add(slog.String("user_agent", e.UserAgent))
+	add(slog.String("referer", e.Referer))
+	add(slog.String("method", e.Method))
+	add(slog.String("path", e.Path))
+	add(slog.String("protocol", e.Protocol))
+	add(slog.Int("status", e.Status))
+	add(slog.Int64("bytes_in", e.BytesIn))
+	add(slog.Int64("bytes_out", e.BytesOut))
+	add(slog.Float64("duration_ms", milliseconds(e.Duration)))
+	add(slog.String("upstream", e.Upstream))
+	add(slog.Float64("upstream_latency_ms", milliseconds(e.UpstreamLatency)))
+	add(slog.Int("retries", e.Retries))
+	add(slog.String("operation_type", e.OperationType))
+	add(slog.String("operation_name", e.OperationName))
+	add(slog.String("operation_hash", e.OperationHash))
+	add(slog.String("outcome", outcome))
+	add(slog.String("error", e.Error))
+	add(slog.String("error_class", e.ErrorClass))
+	add(slog.Int("graphql_errors", e.GraphQLErrors))
+	add(slog.Bool("partial", e.Partial))
+	add(slog.String("error_codes", strings.Join(e.ErrorCodes, ",")))
+	add(slog.Bool("slow", slow))
+	add(slog.String("trace_id", e.TraceID))
+
+	r := slog.NewRecord(e.Time, slog.LevelInfo, "access", 0)
+	r.AddAttrs(attrs...)
+	l.logger.Handler().Handle(ctx, r)
+}
+
+// include reports whether a field is written. The common and combined formats always
+// need their own fields, other fields are written when selected. Without a selection the
+// JSON format writes every field.
+func (l *Logger) include(key string) bool {
+	if l.format != "json" && clfFields[key] {
+		return true
+	}
+	if l.fields == nil {
+		return l.format == "json"
+	}
+	return l.fields[key]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
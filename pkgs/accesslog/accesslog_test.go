@@ -0,0 +1,192 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/logging"
+)
+
+// newTestLogger returns a logger writing to a buffer in format, sampling every entry.
+func newTestLogger(format string, fields ...string) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	var handler slog.Handler = slog.NewJSONHandler(&buf, nil)
+	if format != "json" {
+		handler = newCLFHandler(&buf, format == "combined")
+	}
+	l := &Logger{
+		logger:   slog.New(handler),
+		format:   format,
+		sampling: map[string]float64{OutcomeSuccess: 1, OutcomeClientError: 1, OutcomeServerError: 1},
+	}
+	if len(fields) > 0 {
+		l.fields = make(map[string]bool)
+		for _, f := range fields {
+			l.fields[f] = true
+		}
+	}
+	return l, &buf
+}
+
+func testEntry() Entry {
+	return Entry{
+		Time:          time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		RequestID:     "r1",
+		RemoteAddr:    "192.0.2.1:1234",
+		UserAgent:     "curl/8",
+		Referer:       "https://example.com/",
+		Method:        "POST",
+		Path:          "/v1/graphql",
+		Protocol:      "HTTP/1.1",
+		Status:        200,
+		BytesIn:       42,
+		BytesOut:      512,
+		Duration:      1500 * time.Microsecond,
+		OperationName: "Q",
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		entry Entry
+		want  string
+	}{
+		{"Success", Entry{Status: 200}, OutcomeSuccess},
+		{"Client error", Entry{Status: 429, Error: "rate limited"}, OutcomeClientError},
+		{"Server error", Entry{Status: 502}, OutcomeServerError},
+		{"GraphQL errors", Entry{Status: 200, GraphQLErrors: 1}, OutcomeServerError},
+		{"Failed after the response started", Entry{Status: 200, Error: "connection reset"}, OutcomeServerError},
+		{"Client error with GraphQL errors", Entry{Status: 400, GraphQLErrors: 1}, OutcomeClientError},
+		{"Server error with GraphQL errors", Entry{Status: 502, GraphQLErrors: 1}, OutcomeServerError},
+	}
+	for _, tC := range testCases {
+		if got := tC.entry.Outcome(); got != tC.want {
+			t.Errorf("%s: Outcome() = %s, want %s", tC.desc, got, tC.want)
+		}
+	}
+}
+
+func TestLogFormats(t *testing.T) {
+	testCases := []struct {
+		format string
+		fields []string
+		want   string
+	}{
+		{
+			format: "common",
+			want:   `192.0.2.1:1234 - - [01/Mar/2024:12:30:00 +0000] "POST /v1/graphql HTTP/1.1" 200 512` + "\n",
+		},
+		{
+			format: "combined",
+			fields: []string{"request_id", "operation_name"},
+			want:   `192.0.2.1:1234 - - [01/Mar/2024:12:30:00 +0000] "POST /v1/graphql HTTP/1.1" 200 512 "https://example.com/" "curl/8" request_id="r1" operation_name="Q"` + "\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.format, func(t *testing.T) {
+			l, buf := newTestLogger(tC.format, tC.fields...)
+			l.Log(context.Background(), testEntry())
+			if buf.String() != tC.want {
+				t.Errorf("got  %s\nwant %s", buf.String(), tC.want)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		l, buf := newTestLogger("json", "request_id", "bytes_in", "duration_ms", "outcome")
+		l.Log(context.Background(), testEntry())
+		var got map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got["request_id"] != "r1" || got["bytes_in"] != float64(42) || got["duration_ms"] != 1.5 || got["outcome"] != OutcomeSuccess {
+			t.Errorf("got %v", got)
+		}
+		if _, ok := got["user_agent"]; ok {
+			t.Errorf("unselected field written: %v", got)
+		}
+	})
+}
+
+func TestCLFHandlerGroups(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newCLFHandler(&buf, false)).With("status", 200).WithGroup("upstream").With("url", "http://a")
+	logger.Info("", "remote_addr", "192.0.2.1", slog.Group("retry", "count", 1), slog.Group("", "inline", true))
+
+	// Fields in a group are not fields of the format.
+	want := `" 200 - upstream.url="http://a" upstream.remote_addr="192.0.2.1" upstream.retry.count="1" upstream.inline="true"` + "\n"
+	if got := buf.String(); !strings.HasPrefix(got, "- - - [") || !strings.HasSuffix(got, want) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestLogSampling(t *testing.T) {
+	l, buf := newTestLogger("json", "status", "slow")
+	l.sampling[OutcomeSuccess] = 0
+	l.slow = time.Second
+
+	e := testEntry()
+	l.Log(context.Background(), e)
+	if buf.Len() != 0 {
+		t.Fatalf("unsampled entry written: %s", buf.String())
+	}
+
+	e.Status = 502
+	l.Log(context.Background(), e)
+	if !strings.Contains(buf.String(), `"status":502`) {
+		t.Fatalf("sampled entry not written: %s", buf.String())
+	}
+
+	buf.Reset()
+	e.Status, e.Duration = 200, 2*time.Second
+	l.Log(context.Background(), e)
+	if !strings.Contains(buf.String(), `"slow":true`) {
+		t.Fatalf("slow entry not written: %s", buf.String())
+	}
+
+	var nilLogger *Logger
+	nilLogger.Log(context.Background(), e)
+}
+
+func TestNew(t *testing.T) {
+	if l, err := New(config.AccessLogConfig{}, nil); l != nil || err != nil {
+		t.Fatalf("disabled access log: %v, %v", l, err)
+	}
+
+	one := 1.0
+	output := filepath.Join(t.TempDir(), "access.log")
+	redactor, err := logging.NewRedactor(config.RedactionConfig{Arguments: []string{"password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(config.AccessLogConfig{
+		Enabled:  true,
+		Output:   output,
+		Format:   "json",
+		Sampling: config.AccessLogSampling{Success: &one, ClientError: &one, ServerError: &one},
+	}, redactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := testEntry()
+	e.Status, e.Error = 400, `input:1: Unexpected String "hunter2"`
+	l.Log(context.Background(), e)
+
+	out, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "hunter2") || !strings.Contains(string(out), `"outcome":"client_error"`) {
+		t.Errorf("got %s", out)
+	}
+}
@@ -0,0 +1,133 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// clfFields are the fields making up the Common and Combined Log Formats.
+var clfFields = map[string]bool{
+	"remote_addr": true,
+	"method":      true,
+	"path":        true,
+	"protocol":    true,
+	"status":      true,
+	"bytes_out":   true,
+	"referer":     true,
+	"user_agent":  true,
+}
+
+// clfHandler writes records in the Common Log Format, or the Combined Log Format when
+// combined is set. Fields that are not part of the format are appended as key="value",
+// with the keys of groups prefixed by the group name and a dot.
+type clfHandler struct {
+	mu       *sync.Mutex
+	w        io.Writer
+	combined bool
+	attrs    []slog.Attr
+	group    string
+}
+
+func newCLFHandler(w io.Writer, combined bool) *clfHandler {
+	return &clfHandler{mu: &sync.Mutex{}, w: w, combined: combined}
+}
+
+func (h *clfHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *clfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = h.attrs[:len(h.attrs):len(h.attrs)]
+	for _, a := range attrs {
+		flatten(h.group, a, func(a slog.Attr) { h2.attrs = append(h2.attrs, a) })
+	}
+	return &h2
+}
+
+func (h *clfHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group = h.group + name + "."
+	return &h2
+}
+
+// flatten calls fn with every attribute of a, with the keys of groups prefixed.
+func flatten(prefix string, a slog.Attr, fn func(slog.Attr)) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		a.Key = prefix + a.Key
+		fn(a)
+		return
+	}
+	if a.Key != "" {
+		prefix += a.Key + "."
+	}
+	for _, ga := range a.Value.Group() {
+		flatten(prefix, ga, fn)
+	}
+}
+
+func (h *clfHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string, 8)
+	var extra []slog.Attr
+
+	collect := func(a slog.Attr) {
+		if clfFields[a.Key] {
+			fields[a.Key] = a.Value.String()
+		} else {
+			extra = append(extra, a)
+		}
+	}
+	for _, a := range h.attrs {
+		collect(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		flatten(h.group, a, collect)
+		return true
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(dash(fields["remote_addr"]))
+	buf.WriteString(" - - [")
+	buf.WriteString(r.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	buf.WriteString(`] "`)
+	buf.WriteString(fields["method"] + " " + fields["path"] + " " + fields["protocol"])
+	buf.WriteString(`" `)
+	buf.WriteString(dash(fields["status"]))
+	buf.WriteByte(' ')
+	if b := fields["bytes_out"]; b != "" && b != "0" {
+		buf.WriteString(b)
+	} else {
+		buf.WriteByte('-')
+	}
+	if h.combined {
+		buf.WriteString(" " + strconv.Quote(fields["referer"]))
+		buf.WriteString(" " + strconv.Quote(fields["user_agent"]))
+	}
+	for _, a := range extra {
+		buf.WriteString(" " + a.Key + "=" + strconv.Quote(a.Value.String()))
+	}
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func dash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -21,12 +22,42 @@ type UpstreamServer struct {
 	Capabilities   []Capability `yaml:"capabilities"`
 	Weight         int          `yaml:"weight"`
 	OperationNames []string     `yaml:"operation_names,omitempty"`
+	// MaxConcurrent caps the requests in flight to the upstream, unlimited when zero.
+	MaxConcurrent int                 `yaml:"max_concurrent,omitempty"`
+	Queue         UpstreamQueueConfig `yaml:"queue,omitempty"`
+}
+
+// UpstreamQueueConfig holds requests for an upstream at MaxConcurrent that no other
+// upstream can take. Requests are rejected right away when Size is zero.
+type UpstreamQueueConfig struct {
+	Size    int           `yaml:"size"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type LogRotationConfig struct {
+	MaxSize    int           `yaml:"max_size"`
+	Interval   time.Duration `yaml:"interval"`
+	MaxBackups int           `yaml:"max_backups"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	Compress   bool          `yaml:"compress"`
+}
+
+type LogSinkConfig struct {
+	Type     string            `yaml:"type"`
+	Level    string            `yaml:"level"`
+	Format   string            `yaml:"format"`
+	Path     string            `yaml:"path,omitempty"`
+	Address  string            `yaml:"address,omitempty"`
+	Tag      string            `yaml:"tag,omitempty"`
+	Rotation LogRotationConfig `yaml:"rotation"`
 }
 
 type LogConfig struct {
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
-	Output string `yaml:"output"`
+	Level    string            `yaml:"level"`
+	Format   string            `yaml:"format"`
+	Output   string            `yaml:"output"`
+	Rotation LogRotationConfig `yaml:"rotation"`
+	Sinks    []LogSinkConfig   `yaml:"sinks,omitempty"`
 }
 
 type ServerConfig struct {
@@ -39,10 +70,267 @@ type ServerConfig struct {
 	ResponseTimeout  time.Duration `yaml:"response_timeout"`
 }
 
+type UsageConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Schema       string        `yaml:"schema"`
+	ClientHeader string        `yaml:"client_header"`
+	Window       time.Duration `yaml:"window"`
+	Resolution   time.Duration `yaml:"resolution"`
+}
+
+type AdminConfig struct {
+	// Token is the bearer token the admin endpoints require. Without one they only answer
+	// clients on the loopback interface.
+	Token string `yaml:"token"`
+}
+
+type TracingConfig struct {
+	Enabled     bool     `yaml:"enabled"`
+	Endpoint    string   `yaml:"endpoint"`
+	Insecure    bool     `yaml:"insecure"`
+	ServiceName string   `yaml:"service_name"`
+	SampleRatio *float64 `yaml:"sample_ratio"`
+}
+
+type RetryConfig struct {
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	MaxElapsedTime  time.Duration `yaml:"max_elapsed_time"`
+}
+
+type MetricsExportConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Protocol          string        `yaml:"protocol"`
+	Endpoint          string        `yaml:"endpoint"`
+	Insecure          bool          `yaml:"insecure"`
+	Interval          time.Duration `yaml:"interval"`
+	ServiceName       string        `yaml:"service_name"`
+	ServiceVersion    string        `yaml:"service_version"`
+	ServiceInstanceID string        `yaml:"service_instance_id"`
+	Retry             RetryConfig   `yaml:"retry"`
+}
+
+type RequestIDConfig struct {
+	Header          string   `yaml:"header"`
+	TrustIncoming   bool     `yaml:"trust_incoming"`
+	TrustedNetworks []string `yaml:"trusted_networks,omitempty"`
+}
+
+type AccessLogSampling struct {
+	Success     *float64 `yaml:"success"`
+	ClientError *float64 `yaml:"client_error"`
+	ServerError *float64 `yaml:"server_error"`
+}
+
+type AccessLogConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	Output        string            `yaml:"output"`
+	Format        string            `yaml:"format"`
+	Fields        []string          `yaml:"fields,omitempty"`
+	ClientHeader  string            `yaml:"client_header"`
+	Sampling      AccessLogSampling `yaml:"sampling"`
+	SlowThreshold time.Duration     `yaml:"slow_threshold"`
+	Rotation      LogRotationConfig `yaml:"rotation"`
+}
+
+type RedactionConfig struct {
+	Headers   []string `yaml:"headers,omitempty"`
+	Variables []string `yaml:"variables,omitempty"`
+	Arguments []string `yaml:"arguments,omitempty"`
+}
+
+type HealthCheckConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	Path               string        `yaml:"path,omitempty"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+}
+
+type OutlierDetectionConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	ConsecutiveFailures int           `yaml:"consecutive_failures"`
+	ErrorRatio          float64       `yaml:"error_ratio"`
+	MinRequests         int           `yaml:"min_requests"`
+	Interval            time.Duration `yaml:"interval"`
+	BaseEjectionTime    time.Duration `yaml:"base_ejection_time"`
+	MaxEjectionTime     time.Duration `yaml:"max_ejection_time"`
+	MaxEjectionPercent  int           `yaml:"max_ejection_percent"`
+	// ErrorCodes lists the GraphQL error codes, such as INTERNAL_SERVER_ERROR, that count
+	// as failures of the upstream that answered with them. Other GraphQL errors are
+	// usually caused by the client and never do.
+	ErrorCodes []string `yaml:"error_codes,omitempty"`
+}
+
+type CircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenDuration     time.Duration `yaml:"open_duration"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+	SuccessThreshold int           `yaml:"success_threshold"`
+}
+
+type UpstreamRetryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxAttempts counts the first attempt.
+	MaxAttempts int  `yaml:"max_attempts"`
+	Mutations   bool `yaml:"mutations"`
+	// RetryOn lists the error classes that are retried.
+	RetryOn             []string      `yaml:"retry_on"`
+	BaseBackoff         time.Duration `yaml:"base_backoff"`
+	MaxBackoff          time.Duration `yaml:"max_backoff"`
+	BudgetRatio         float64       `yaml:"budget_ratio"`
+	MinRetriesPerSecond int           `yaml:"min_retries_per_second"`
+}
+
+type HedgingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Operations lists the names of the queries that are hedged, every query when empty.
+	Operations []string `yaml:"operations,omitempty"`
+	// Delay is fixed, when zero the observed Percentile latency of the operation is used.
+	Delay         time.Duration `yaml:"delay"`
+	Percentile    float64       `yaml:"percentile"`
+	MinDelay      time.Duration `yaml:"min_delay"`
+	MinSamples    int           `yaml:"min_samples"`
+	BudgetPercent float64       `yaml:"budget_percent"`
+}
+
+// Load balancing strategies.
+const (
+	StrategyRandom           = "random"
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastOutstanding = "least_outstanding"
+	StrategyPowerOfTwo       = "p2c"
+	StrategyPeakEWMA         = "peak_ewma"
+	StrategyConsistentHash   = "consistent_hash"
+)
+
+type LoadBalancingConfig struct {
+	Strategy string `yaml:"strategy"`
+	// Operations overrides Strategy for operation types.
+	Operations map[Capability]string `yaml:"operations,omitempty"`
+	// EWMADecay is the time over which the peak EWMA strategy forgets a latency.
+	EWMADecay time.Duration `yaml:"ewma_decay"`
+	// HashKey is where the consistent hash strategy takes the key of a request from,
+	// such as "header:X-User-ID", "jwt:sub" or "variable:userId".
+	HashKey   string          `yaml:"hash_key,omitempty"`
+	SlowStart SlowStartConfig `yaml:"slow_start"`
+}
+
+// SlowStartConfig ramps up the weight of upstreams that return from failing health checks
+// or from ejection. It is disabled when Window is zero.
+type SlowStartConfig struct {
+	Window time.Duration `yaml:"window"`
+	// Aggression shapes the ramp: 1 is linear, higher values ramp up faster at first.
+	Aggression       float64 `yaml:"aggression"`
+	MinWeightPercent float64 `yaml:"min_weight_percent"`
+}
+
+// Adaptive concurrency limit algorithms.
+const (
+	AdaptiveGradient = "gradient"
+	AdaptiveVegas    = "vegas"
+	AdaptiveAIMD     = "aimd"
+)
+
+type AdaptiveConcurrencyConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Algorithm    string `yaml:"algorithm"`
+	InitialLimit int    `yaml:"initial_limit"`
+	MinLimit     int    `yaml:"min_limit"`
+	MaxLimit     int    `yaml:"max_limit"`
+	// Tolerance is how much the latency of the gradient algorithm may grow over its long
+	// term average before the limit is lowered, Smoothing how fast the limit moves.
+	Tolerance float64 `yaml:"tolerance"`
+	Smoothing float64 `yaml:"smoothing"`
+	// The AIMD algorithm lowers the limit by BackoffRatio for requests that fail or take
+	// longer than LatencyThreshold.
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	BackoffRatio     float64       `yaml:"backoff_ratio"`
+}
+
+// Rate limiting algorithms.
+const (
+	RateLimitTokenBucket   = "token_bucket"
+	RateLimitSlidingWindow = "sliding_window"
+)
+
+type RateLimitConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Rules   []RateLimitRule `yaml:"rules"`
+	// JWTSecret verifies the bearer tokens "jwt:" keys are taken from, which are
+	// required to be signed with it using HMAC.
+	JWTSecret string `yaml:"jwt_secret"`
+}
+
+// RateLimitRule allows Limit requests per Period for every key, taken from the request
+// as described by Key, such as "ip", "header:X-API-Key" or "jwt:sub".
+type RateLimitRule struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// Operations lists the operation names the rule applies to, every operation when
+	// empty. With PerOperation every operation of a key has a limit of its own.
+	Operations   []string      `yaml:"operations,omitempty"`
+	PerOperation bool          `yaml:"per_operation"`
+	Algorithm    string        `yaml:"algorithm"`
+	Limit        int           `yaml:"limit"`
+	Period       time.Duration `yaml:"period"`
+	// Burst is the size of the token bucket.
+	Burst int `yaml:"burst"`
+	// PlainKeys reports keys as they are in metrics and logs. Keys other than addresses
+	// may be secrets, like API keys, and are reported as hashes otherwise.
+	PlainKeys bool `yaml:"plain_keys"`
+}
+
+// CostLimitConfig gives every key a budget of Budget cost points per Period. Every query
+// takes its cost, estimated from the parsed document, from the budget of its key.
+type CostLimitConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Key     string        `yaml:"key"`
+	Budget  int           `yaml:"budget"`
+	Period  time.Duration `yaml:"period"`
+	// Burst is the most a key can save up, and so the most a single query may cost.
+	Burst     int    `yaml:"burst"`
+	PlainKeys bool   `yaml:"plain_keys"`
+	JWTSecret string `yaml:"jwt_secret"`
+	// ObjectCost is the cost of a field that selects an object, ScalarCost that of any
+	// other field. Mutations cost MutationCost more for every field of the mutation type.
+	ObjectCost   int `yaml:"object_cost"`
+	ScalarCost   int `yaml:"scalar_cost"`
+	MutationCost int `yaml:"mutation_cost"`
+	// ListArguments are the arguments that give the number of items a field returns, the
+	// selections of which are counted as many times.
+	ListArguments []string `yaml:"list_arguments"`
+	// Schema is the SDL file of the upstream schema, which tells which fields return
+	// lists. Lists without one of the ListArguments count DefaultListSize items.
+	Schema          string `yaml:"schema"`
+	DefaultListSize int    `yaml:"default_list_size"`
+	// MaxDepth and MaxSelections bound the queries that are analyzed at all.
+	MaxDepth      int `yaml:"max_depth"`
+	MaxSelections int `yaml:"max_selections"`
+}
+
 type Config struct {
-	Upstreams []UpstreamServer `yaml:"upstreams"`
-	Logging   LogConfig        `yaml:"logging"`
-	Server    ServerConfig     `yaml:"server"`
+	Upstreams      []UpstreamServer          `yaml:"upstreams"`
+	Logging        LogConfig                 `yaml:"logging"`
+	AccessLog      AccessLogConfig           `yaml:"access_log"`
+	Redaction      RedactionConfig           `yaml:"redaction"`
+	Server         ServerConfig              `yaml:"server"`
+	Usage          UsageConfig               `yaml:"usage"`
+	Admin          AdminConfig               `yaml:"admin"`
+	Tracing        TracingConfig             `yaml:"tracing"`
+	Export         MetricsExportConfig       `yaml:"metrics_export"`
+	RequestID      RequestIDConfig           `yaml:"request_id"`
+	Health         HealthCheckConfig         `yaml:"health_check"`
+	Outlier        OutlierDetectionConfig    `yaml:"outlier_detection"`
+	CircuitBreaker CircuitBreakerConfig      `yaml:"circuit_breaker"`
+	Retry          UpstreamRetryConfig       `yaml:"retry"`
+	Hedging        HedgingConfig             `yaml:"hedging"`
+	LoadBalancing  LoadBalancingConfig       `yaml:"load_balancing"`
+	Adaptive       AdaptiveConcurrencyConfig `yaml:"adaptive_concurrency"`
+	RateLimit      RateLimitConfig           `yaml:"rate_limit"`
+	CostLimit      CostLimitConfig           `yaml:"cost_limit"`
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -91,6 +379,439 @@ func validateConfig(config *Config) error {
 		config.Server.ResponseTimeout = 30 * time.Second
 	}
 
+	if err := validateLogging(&config.Logging); err != nil {
+		return err
+	}
+
+	if config.AccessLog.Enabled {
+		if config.AccessLog.Output == "" {
+			config.AccessLog.Output = "stdout"
+		}
+		switch config.AccessLog.Format {
+		case "":
+			config.AccessLog.Format = "json"
+		case "json", "common", "combined":
+		default:
+			return fmt.Errorf("unsupported access log format: %s", config.AccessLog.Format)
+		}
+		if config.AccessLog.ClientHeader == "" {
+			config.AccessLog.ClientHeader = "apollographql-client-name"
+		}
+		for _, rate := range []**float64{
+			&config.AccessLog.Sampling.Success,
+			&config.AccessLog.Sampling.ClientError,
+			&config.AccessLog.Sampling.ServerError,
+		} {
+			if *rate == nil {
+				one := 1.0
+				*rate = &one
+			}
+			if **rate < 0 || **rate > 1 {
+				return fmt.Errorf("access log sampling rate must be between 0 and 1: %v", **rate)
+			}
+		}
+	}
+
+	if config.AccessLog.Rotation.MaxSize < 0 || config.AccessLog.Rotation.MaxBackups < 0 {
+		return fmt.Errorf("access log rotation limits must not be negative")
+	}
+
+	if len(config.Redaction.Headers) == 0 {
+		config.Redaction.Headers = []string{"Authorization", "Cookie"}
+	}
+
+	if config.RequestID.Header == "" {
+		config.RequestID.Header = "X-Request-ID"
+	}
+
+	if config.Usage.Enabled {
+		if config.Usage.Schema == "" {
+			return fmt.Errorf("usage tracking requires a schema")
+		}
+		if config.Usage.ClientHeader == "" {
+			config.Usage.ClientHeader = "apollographql-client-name"
+		}
+		if config.Usage.Window == 0 {
+			config.Usage.Window = 24 * time.Hour
+		}
+		if config.Usage.Resolution == 0 {
+			config.Usage.Resolution = time.Hour
+		}
+		if config.Usage.Resolution > config.Usage.Window {
+			return fmt.Errorf("usage resolution %s is larger than window %s", config.Usage.Resolution, config.Usage.Window)
+		}
+	}
+
+	if config.Tracing.Enabled {
+		if config.Tracing.Endpoint == "" {
+			config.Tracing.Endpoint = "localhost:4318"
+		}
+		if config.Tracing.ServiceName == "" {
+			config.Tracing.ServiceName = "graphql-proxy"
+		}
+		if config.Tracing.SampleRatio == nil {
+			one := 1.0
+			config.Tracing.SampleRatio = &one
+		}
+		if *config.Tracing.SampleRatio < 0 || *config.Tracing.SampleRatio > 1 {
+			return fmt.Errorf("tracing sample ratio must be between 0 and 1: %v", *config.Tracing.SampleRatio)
+		}
+	}
+
+	if config.Export.Enabled {
+		switch config.Export.Protocol {
+		case "", "http":
+			config.Export.Protocol = "http"
+			if config.Export.Endpoint == "" {
+				config.Export.Endpoint = "localhost:4318"
+			}
+		case "grpc":
+			if config.Export.Endpoint == "" {
+				config.Export.Endpoint = "localhost:4317"
+			}
+		default:
+			return fmt.Errorf("unsupported metrics export protocol: %s", config.Export.Protocol)
+		}
+		if config.Export.Interval == 0 {
+			config.Export.Interval = time.Minute
+		}
+		if config.Export.ServiceName == "" {
+			config.Export.ServiceName = "graphql-proxy"
+		}
+		if config.Export.ServiceInstanceID == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("resolving metrics export instance id: %w", err)
+			}
+			config.Export.ServiceInstanceID = hostname
+		}
+		if config.Export.Retry.InitialInterval == 0 {
+			config.Export.Retry.InitialInterval = 5 * time.Second
+		}
+		if config.Export.Retry.MaxInterval == 0 {
+			config.Export.Retry.MaxInterval = 30 * time.Second
+		}
+		if config.Export.Retry.MaxElapsedTime == 0 {
+			config.Export.Retry.MaxElapsedTime = time.Minute
+		}
+	}
+
+	if config.Health.Enabled {
+		if config.Health.Interval == 0 {
+			config.Health.Interval = 10 * time.Second
+		}
+		if config.Health.Timeout == 0 {
+			config.Health.Timeout = 2 * time.Second
+		}
+		if config.Health.HealthyThreshold == 0 {
+			config.Health.HealthyThreshold = 2
+		}
+		if config.Health.UnhealthyThreshold == 0 {
+			config.Health.UnhealthyThreshold = 3
+		}
+		if config.Health.HealthyThreshold < 0 || config.Health.UnhealthyThreshold < 0 {
+			return fmt.Errorf("health check thresholds must be positive")
+		}
+		if config.Health.Path != "" && !strings.HasPrefix(config.Health.Path, "/") {
+			return fmt.Errorf("health check path must start with /: %s", config.Health.Path)
+		}
+	}
+
+	if config.Outlier.Enabled {
+		if config.Outlier.ConsecutiveFailures == 0 {
+			config.Outlier.ConsecutiveFailures = 5
+		}
+		if config.Outlier.ErrorRatio == 0 {
+			config.Outlier.ErrorRatio = 0.5
+		}
+		if config.Outlier.ErrorRatio < 0 || config.Outlier.ErrorRatio > 1 {
+			return fmt.Errorf("outlier detection error ratio must be between 0 and 1: %v", config.Outlier.ErrorRatio)
+		}
+		if config.Outlier.MinRequests == 0 {
+			config.Outlier.MinRequests = 20
+		}
+		if config.Outlier.Interval == 0 {
+			config.Outlier.Interval = 10 * time.Second
+		}
+		if config.Outlier.BaseEjectionTime == 0 {
+			config.Outlier.BaseEjectionTime = 30 * time.Second
+		}
+		if config.Outlier.MaxEjectionTime == 0 {
+			config.Outlier.MaxEjectionTime = 5 * time.Minute
+		}
+		if config.Outlier.MaxEjectionTime < config.Outlier.BaseEjectionTime {
+			return fmt.Errorf("outlier detection max ejection time %s is shorter than base ejection time %s", config.Outlier.MaxEjectionTime, config.Outlier.BaseEjectionTime)
+		}
+		if config.Outlier.MaxEjectionPercent == 0 {
+			config.Outlier.MaxEjectionPercent = 50
+		}
+		if config.Outlier.MaxEjectionPercent < 0 || config.Outlier.MaxEjectionPercent > 100 {
+			return fmt.Errorf("outlier detection max ejection percent must be between 0 and 100: %d", config.Outlier.MaxEjectionPercent)
+		}
+	}
+
+	if config.CircuitBreaker.Enabled {
+		if config.CircuitBreaker.FailureThreshold == 0 {
+			config.CircuitBreaker.FailureThreshold = 5
+		}
+		if config.CircuitBreaker.OpenDuration == 0 {
+			config.CircuitBreaker.OpenDuration = 30 * time.Second
+		}
+		if config.CircuitBreaker.HalfOpenRequests == 0 {
+			config.CircuitBreaker.HalfOpenRequests = 1
+		}
+		if config.CircuitBreaker.SuccessThreshold == 0 {
+			config.CircuitBreaker.SuccessThreshold = config.CircuitBreaker.HalfOpenRequests
+		}
+		if config.CircuitBreaker.FailureThreshold < 0 || config.CircuitBreaker.HalfOpenRequests < 0 || config.CircuitBreaker.SuccessThreshold < 0 {
+			return fmt.Errorf("circuit breaker thresholds must be positive")
+		}
+	}
+
+	if config.Retry.Enabled {
+		if config.Retry.MaxAttempts == 0 {
+			config.Retry.MaxAttempts = 3
+		}
+		if config.Retry.MaxAttempts < 1 {
+			return fmt.Errorf("retry max attempts must be positive: %d", config.Retry.MaxAttempts)
+		}
+		if len(config.Retry.RetryOn) == 0 {
+			config.Retry.RetryOn = []string{"upstream_connect_error"}
+		}
+		for _, class := range config.Retry.RetryOn {
+			switch class {
+			case "upstream_connect_error", "timeout", "upstream_5xx":
+			default:
+				return fmt.Errorf("unsupported retry condition: %s", class)
+			}
+		}
+		if config.Retry.BaseBackoff == 0 {
+			config.Retry.BaseBackoff = 25 * time.Millisecond
+		}
+		if config.Retry.MaxBackoff == 0 {
+			config.Retry.MaxBackoff = 250 * time.Millisecond
+		}
+		if config.Retry.MaxBackoff < config.Retry.BaseBackoff {
+			return fmt.Errorf("retry max backoff %s is shorter than base backoff %s", config.Retry.MaxBackoff, config.Retry.BaseBackoff)
+		}
+		if config.Retry.BudgetRatio == 0 {
+			config.Retry.BudgetRatio = 0.2
+		}
+		if config.Retry.BudgetRatio < 0 || config.Retry.BudgetRatio > 1 {
+			return fmt.Errorf("retry budget ratio must be between 0 and 1: %v", config.Retry.BudgetRatio)
+		}
+		if config.Retry.MinRetriesPerSecond == 0 {
+			config.Retry.MinRetriesPerSecond = 10
+		}
+		if config.Retry.MinRetriesPerSecond < 0 {
+			return fmt.Errorf("retry min retries per second must not be negative: %d", config.Retry.MinRetriesPerSecond)
+		}
+	}
+
+	if config.Hedging.Enabled {
+		if config.Hedging.Delay < 0 {
+			return fmt.Errorf("hedging delay must not be negative: %s", config.Hedging.Delay)
+		}
+		if config.Hedging.Percentile == 0 {
+			config.Hedging.Percentile = 0.95
+		}
+		if config.Hedging.Percentile < 0 || config.Hedging.Percentile > 1 {
+			return fmt.Errorf("hedging percentile must be between 0 and 1: %v", config.Hedging.Percentile)
+		}
+		if config.Hedging.MinDelay == 0 {
+			config.Hedging.MinDelay = 10 * time.Millisecond
+		}
+		if config.Hedging.MinSamples == 0 {
+			config.Hedging.MinSamples = 100
+		}
+		if config.Hedging.BudgetPercent == 0 {
+			config.Hedging.BudgetPercent = 10
+		}
+		if config.Hedging.BudgetPercent < 0 || config.Hedging.BudgetPercent > 100 {
+			return fmt.Errorf("hedging budget percent must be between 0 and 100: %v", config.Hedging.BudgetPercent)
+		}
+	}
+
+	if config.LoadBalancing.Strategy == "" {
+		config.LoadBalancing.Strategy = StrategyRandom
+	}
+	if err := validateStrategy(config.LoadBalancing.Strategy); err != nil {
+		return err
+	}
+	for op, strategy := range config.LoadBalancing.Operations {
+		switch op {
+		case CapabilityQuery, CapabilityMutation, CapabilitySubscription:
+		default:
+			return fmt.Errorf("unsupported operation type for load balancing: %s", op)
+		}
+		if err := validateStrategy(strategy); err != nil {
+			return err
+		}
+	}
+	if config.LoadBalancing.HashKey == "" && config.LoadBalancing.uses(StrategyConsistentHash) {
+		return fmt.Errorf("load balancing strategy %s requires a hash key", StrategyConsistentHash)
+	}
+	if config.LoadBalancing.EWMADecay == 0 {
+		config.LoadBalancing.EWMADecay = 10 * time.Second
+	}
+	if slowStart := &config.LoadBalancing.SlowStart; slowStart.Window != 0 {
+		if slowStart.Window < 0 {
+			return fmt.Errorf("slow start window must not be negative: %s", slowStart.Window)
+		}
+		if slowStart.Aggression == 0 {
+			slowStart.Aggression = 1
+		}
+		if slowStart.Aggression < 0 {
+			return fmt.Errorf("slow start aggression must be positive: %v", slowStart.Aggression)
+		}
+		if slowStart.MinWeightPercent == 0 {
+			slowStart.MinWeightPercent = 10
+		}
+		if slowStart.MinWeightPercent < 0 || slowStart.MinWeightPercent > 100 {
+			return fmt.Errorf("slow start min weight percent must be between 0 and 100: %v", slowStart.MinWeightPercent)
+		}
+	}
+
+	if adaptive := &config.Adaptive; adaptive.Enabled {
+		switch adaptive.Algorithm {
+		case "":
+			adaptive.Algorithm = AdaptiveGradient
+		case AdaptiveGradient, AdaptiveVegas, AdaptiveAIMD:
+		default:
+			return fmt.Errorf("unsupported adaptive concurrency algorithm: %s", adaptive.Algorithm)
+		}
+		if adaptive.MinLimit == 0 {
+			adaptive.MinLimit = 1
+		}
+		if adaptive.MaxLimit == 0 {
+			adaptive.MaxLimit = 1000
+		}
+		if adaptive.InitialLimit == 0 {
+			adaptive.InitialLimit = min(20, adaptive.MaxLimit)
+		}
+		if adaptive.MinLimit < 1 || adaptive.MaxLimit < adaptive.MinLimit || adaptive.InitialLimit < adaptive.MinLimit || adaptive.InitialLimit > adaptive.MaxLimit {
+			return fmt.Errorf("adaptive concurrency limits must satisfy 1 <= min_limit <= initial_limit <= max_limit")
+		}
+		if adaptive.Tolerance == 0 {
+			adaptive.Tolerance = 1.5
+		}
+		if adaptive.Tolerance < 1 {
+			return fmt.Errorf("adaptive concurrency tolerance must be at least 1: %v", adaptive.Tolerance)
+		}
+		if adaptive.Smoothing == 0 {
+			adaptive.Smoothing = 0.2
+		}
+		if adaptive.Smoothing < 0 || adaptive.Smoothing > 1 {
+			return fmt.Errorf("adaptive concurrency smoothing must be between 0 and 1: %v", adaptive.Smoothing)
+		}
+		if adaptive.LatencyThreshold == 0 {
+			adaptive.LatencyThreshold = time.Second
+		}
+		if adaptive.LatencyThreshold < 0 {
+			return fmt.Errorf("adaptive concurrency latency threshold must not be negative: %s", adaptive.LatencyThreshold)
+		}
+		if adaptive.BackoffRatio == 0 {
+			adaptive.BackoffRatio = 0.9
+		}
+		if adaptive.BackoffRatio <= 0 || adaptive.BackoffRatio >= 1 {
+			return fmt.Errorf("adaptive concurrency backoff ratio must be between 0 and 1: %v", adaptive.BackoffRatio)
+		}
+	}
+
+	if config.RateLimit.Enabled {
+		if len(config.RateLimit.Rules) == 0 {
+			return fmt.Errorf("rate limiting is enabled without rules")
+		}
+		names := make(map[string]bool)
+		for i := range config.RateLimit.Rules {
+			rule := &config.RateLimit.Rules[i]
+			if rule.Name == "" {
+				rule.Name = rule.Key
+			}
+			if names[rule.Name] {
+				return fmt.Errorf("rate limit rule #%d: duplicate name %q", i+1, rule.Name)
+			}
+			names[rule.Name] = true
+			if rule.Key == "" {
+				return fmt.Errorf("rate limit rule #%d has no key", i+1)
+			}
+			if strings.HasPrefix(rule.Key, "jwt:") && config.RateLimit.JWTSecret == "" {
+				return fmt.Errorf("rate limit rule #%d: jwt keys require a jwt_secret to verify tokens", i+1)
+			}
+			switch rule.Algorithm {
+			case "":
+				rule.Algorithm = RateLimitTokenBucket
+			case RateLimitTokenBucket, RateLimitSlidingWindow:
+			default:
+				return fmt.Errorf("rate limit rule #%d: unsupported algorithm: %s", i+1, rule.Algorithm)
+			}
+			if rule.Limit < 1 {
+				return fmt.Errorf("rate limit rule #%d has invalid limit: %d", i+1, rule.Limit)
+			}
+			if rule.Period == 0 {
+				rule.Period = time.Minute
+			}
+			if rule.Period < 0 {
+				return fmt.Errorf("rate limit rule #%d has invalid period: %s", i+1, rule.Period)
+			}
+			if rule.Burst == 0 {
+				rule.Burst = rule.Limit
+			}
+			if rule.Burst < 0 {
+				return fmt.Errorf("rate limit rule #%d has invalid burst: %d", i+1, rule.Burst)
+			}
+		}
+	}
+
+	if config.CostLimit.Enabled {
+		cost := &config.CostLimit
+		if cost.Key == "" {
+			cost.Key = "ip"
+		}
+		if strings.HasPrefix(cost.Key, "jwt:") && cost.JWTSecret == "" {
+			return fmt.Errorf("cost limit: jwt keys require a jwt_secret to verify tokens")
+		}
+		if cost.Budget < 1 {
+			return fmt.Errorf("cost limit has invalid budget: %d", cost.Budget)
+		}
+		if cost.Period == 0 {
+			cost.Period = time.Minute
+		}
+		if cost.Period < 0 {
+			return fmt.Errorf("cost limit has invalid period: %s", cost.Period)
+		}
+		if cost.Burst == 0 {
+			cost.Burst = cost.Budget
+		}
+		if cost.Burst < 0 {
+			return fmt.Errorf("cost limit has invalid burst: %d", cost.Burst)
+		}
+		if cost.ObjectCost == 0 {
+			cost.ObjectCost = 1
+		}
+		if cost.MutationCost == 0 {
+			cost.MutationCost = 10
+		}
+		if cost.ObjectCost < 0 || cost.ScalarCost < 0 || cost.MutationCost < 0 {
+			return fmt.Errorf("cost limit has negative field costs")
+		}
+		if len(cost.ListArguments) == 0 {
+			cost.ListArguments = []string{"first", "last", "limit"}
+		}
+		if cost.DefaultListSize == 0 {
+			cost.DefaultListSize = 100
+		}
+		if cost.MaxDepth == 0 {
+			cost.MaxDepth = 20
+		}
+		if cost.MaxSelections == 0 {
+			cost.MaxSelections = 5000
+		}
+		if cost.DefaultListSize < 0 || cost.MaxDepth < 0 || cost.MaxSelections < 0 {
+			return fmt.Errorf("cost limit has negative list size, depth or selections")
+		}
+	}
+
 	for i, upstream := range config.Upstreams {
 		if upstream.URL == "" {
 			return fmt.Errorf("upstream #%d has empty URL", i+1)
@@ -98,6 +819,18 @@ func validateConfig(config *Config) error {
 		if upstream.Weight < 1 {
 			return fmt.Errorf("upstream #%d has invalid weight: %d", i+1, upstream.Weight)
 		}
+		if upstream.MaxConcurrent < 0 {
+			return fmt.Errorf("upstream #%d has invalid max concurrent: %d", i+1, upstream.MaxConcurrent)
+		}
+		if upstream.Queue.Size < 0 || upstream.Queue.Timeout < 0 {
+			return fmt.Errorf("upstream #%d has invalid queue size or timeout", i+1)
+		}
+		if upstream.Queue.Size > 0 && upstream.MaxConcurrent == 0 && !config.Adaptive.Enabled {
+			return fmt.Errorf("upstream #%d has a queue but no concurrency limit", i+1)
+		}
+		if upstream.Queue.Size > 0 && upstream.Queue.Timeout == 0 {
+			config.Upstreams[i].Queue.Timeout = time.Second
+		}
 		if len(upstream.Capabilities) == 0 {
 			return fmt.Errorf("upstream #%d has no capabilities", i+1)
 		}
@@ -105,3 +838,71 @@ func validateConfig(config *Config) error {
 
 	return nil
 }
+
+// uses reports whether strategy is used for any operation type.
+func (lb LoadBalancingConfig) uses(strategy string) bool {
+	if lb.Strategy == strategy {
+		return true
+	}
+	for _, s := range lb.Operations {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+func validateStrategy(strategy string) error {
+	switch strategy {
+	case StrategyRandom, StrategyRoundRobin, StrategyLeastOutstanding, StrategyPowerOfTwo, StrategyPeakEWMA, StrategyConsistentHash:
+		return nil
+	default:
+		return fmt.Errorf("unsupported load balancing strategy: %s", strategy)
+	}
+}
+
+// validateLogging turns the level, format and output settings into a sink when no sinks
+// are configured and fills in the defaults of every sink.
+func validateLogging(logging *LogConfig) error {
+	if len(logging.Sinks) == 0 {
+		sink := LogSinkConfig{Level: logging.Level, Format: logging.Format, Rotation: logging.Rotation}
+		switch strings.ToLower(logging.Output) {
+		case "", "stdout":
+			sink.Type = "stdout"
+		case "stderr":
+			sink.Type = "stderr"
+		default:
+			sink.Type = "file"
+			sink.Path = logging.Output
+		}
+		logging.Sinks = []LogSinkConfig{sink}
+	}
+
+	for i := range logging.Sinks {
+		sink := &logging.Sinks[i]
+		if sink.Level == "" {
+			sink.Level = logging.Level
+		}
+		if sink.Format == "" {
+			sink.Format = logging.Format
+		}
+		switch sink.Type {
+		case "stdout", "stderr":
+		case "file":
+			if sink.Path == "" {
+				return fmt.Errorf("log sink #%d has no path", i+1)
+			}
+			if sink.Rotation.MaxSize < 0 || sink.Rotation.MaxBackups < 0 {
+				return fmt.Errorf("log sink #%d has negative rotation limits", i+1)
+			}
+		case "syslog":
+			if sink.Tag == "" {
+				sink.Tag = "graphql-proxy"
+			}
+		default:
+			return fmt.Errorf("log sink #%d has unsupported type: %s", i+1, sink.Type)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package graphql
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// CostModel estimates what an operation costs the upstream to resolve.
+type CostModel struct {
+	// ObjectCost is the cost of a field that selects an object, ScalarCost that of any
+	// other field.
+	ObjectCost int
+	ScalarCost int
+	// MutationCost is added for every field of the mutation type.
+	MutationCost int
+	// ListArguments are the arguments that give the number of items a field returns,
+	// like first or limit. The selections of a field are counted once for every item.
+	ListArguments []string
+	// Schema, when set, tells which fields return lists. Lists without one of the
+	// ListArguments are taken to return DefaultListSize items. Without a schema only
+	// fields with a list argument count as lists.
+	Schema          *ast.Schema
+	DefaultListSize int
+	// MaxDepth and MaxSelections limit how deeply fields may be nested and how many
+	// selections, counting those of every fragment once, an operation may have. Zero
+	// means no limit.
+	MaxDepth      int
+	MaxSelections int
+}
+
+// Cost returns the estimated cost of op. Fields nested in lists are counted once for every
+// item, taking the largest list argument of the field, given literally or as a variable,
+// as the number of items. The cost saturates at math.MaxInt64. An error is returned for
+// operations nested deeper than MaxDepth or with more than MaxSelections selections.
+func (m CostModel) Cost(doc *ast.QueryDocument, op *ast.OperationDefinition, variables map[string]interface{}) (int64, error) {
+	c := &costWalker{
+		model:     m,
+		doc:       doc,
+		op:        op,
+		variables: variables,
+		fragments: make(map[fragmentKey]*fragmentCost),
+	}
+	var parent *ast.Definition
+	if m.Schema != nil {
+		switch op.Operation {
+		case ast.Mutation:
+			parent = m.Schema.Mutation
+		case ast.Subscription:
+			parent = m.Schema.Subscription
+		default:
+			parent = m.Schema.Query
+		}
+	}
+	cost, _ := c.selectionSet(op.SelectionSet, parent, 1, op.Operation == ast.Mutation)
+	if c.err != nil {
+		return 0, c.err
+	}
+	if cost >= math.MaxInt64 {
+		return math.MaxInt64, nil
+	}
+	return int64(cost), nil
+}
+
+type costWalker struct {
+	model     CostModel
+	doc       *ast.QueryDocument
+	op        *ast.OperationDefinition
+	variables map[string]interface{}
+	// fragments holds the cost of every fragment walked, so that fragments spread many
+	// times are walked once.
+	fragments  map[fragmentKey]*fragmentCost
+	selections int
+	err        error
+}
+
+// fragmentKey identifies a fragment walked at the root of a mutation or elsewhere, where it
+// costs differently.
+type fragmentKey struct {
+	name string
+	root bool
+}
+
+// fragmentCost is the cost of a fragment and how deeply its fields are nested. Spreads
+// that cycle back to a fragment while it is walked cost nothing.
+type fragmentCost struct {
+	cost   float64
+	height int
+}
+
+// selectionSet returns the cost of ss once and how deeply its fields are nested. ss
+// selects from parent, which is nil without a schema, at depth. root is set for the
+// selections of a mutation type.
+func (c *costWalker) selectionSet(ss ast.SelectionSet, parent *ast.Definition, depth int, root bool) (float64, int) {
+	var cost float64
+	height := 0
+	for _, sel := range ss {
+		if c.err != nil {
+			return 0, 0
+		}
+		c.selections++
+		if limit := c.model.MaxSelections; limit > 0 && c.selections > limit {
+			c.err = fmt.Errorf("query has more than %d selections", limit)
+			return 0, 0
+		}
+		switch s := sel.(type) {
+		case *ast.Field:
+			if limit := c.model.MaxDepth; limit > 0 && depth > limit {
+				c.err = fmt.Errorf("query is nested deeper than %d levels", limit)
+				return 0, 0
+			}
+			if root {
+				cost += float64(c.model.MutationCost)
+			}
+			if len(s.SelectionSet) == 0 {
+				cost += float64(c.model.ScalarCost)
+				height = max(height, 1)
+				continue
+			}
+			var def *ast.FieldDefinition
+			var child *ast.Definition
+			if parent != nil {
+				if def = parent.Fields.ForName(s.Name); def != nil {
+					child = c.model.Schema.Types[def.Type.Name()]
+				}
+			}
+			sub, h := c.selectionSet(s.SelectionSet, child, depth+1, false)
+			cost += float64(c.model.ObjectCost)
+			// Empty lists cost nothing, even when their items would cost too much to count.
+			if n := c.listSize(s.Arguments, def); n > 0 {
+				cost += n * sub
+			}
+			height = max(height, h+1)
+		case *ast.InlineFragment:
+			sub, h := c.selectionSet(s.SelectionSet, c.typeCondition(s.TypeCondition, parent), depth, root)
+			cost += sub
+			height = max(height, h)
+		case *ast.FragmentSpread:
+			f := c.fragment(s.Name, parent, depth, root)
+			if limit := c.model.MaxDepth; limit > 0 && depth+f.height-1 > limit {
+				c.err = fmt.Errorf("query is nested deeper than %d levels", limit)
+				return 0, 0
+			}
+			cost += f.cost
+			height = max(height, f.height)
+		}
+	}
+	return cost, height
+}
+
+// fragment returns the cost of the fragment name, walking it the first time it is spread.
+func (c *costWalker) fragment(name string, parent *ast.Definition, depth int, root bool) *fragmentCost {
+	key := fragmentKey{name: name, root: root}
+	if f, ok := c.fragments[key]; ok {
+		return f
+	}
+	f := &fragmentCost{}
+	c.fragments[key] = f
+	if def := c.doc.Fragments.ForName(name); def != nil {
+		f.cost, f.height = c.selectionSet(def.SelectionSet, c.typeCondition(def.TypeCondition, parent), depth, root)
+	}
+	return f
+}
+
+// typeCondition returns the type a fragment selects from, parent when it has no type
+// condition or there is no schema.
+func (c *costWalker) typeCondition(name string, parent *ast.Definition) *ast.Definition {
+	if name == "" || c.model.Schema == nil {
+		return parent
+	}
+	return c.model.Schema.Types[name]
+}
+
+// listSize returns the number of items a field with args returns: the largest of its
+// list arguments, DefaultListSize for fields def types as a list without one, and 1
+// otherwise.
+func (c *costWalker) listSize(args ast.ArgumentList, def *ast.FieldDefinition) float64 {
+	size := -1.0
+	for _, name := range c.model.ListArguments {
+		arg := args.ForName(name)
+		if arg == nil {
+			continue
+		}
+		if n, ok := c.number(arg.Value); ok {
+			size = math.Max(size, math.Max(n, 0))
+		}
+	}
+	switch {
+	case size >= 0:
+		return size
+	case def != nil && def.Type.Elem != nil:
+		return float64(c.model.DefaultListSize)
+	default:
+		return 1
+	}
+}
+
+// number returns the value of v if it is a number, taking the default of variables the
+// request left out.
+func (c *costWalker) number(v *ast.Value) (float64, bool) {
+	if v.Kind == ast.Variable {
+		if _, ok := c.variables[v.Raw]; !ok {
+			def := c.op.VariableDefinitions.ForName(v.Raw)
+			if def == nil || def.DefaultValue == nil {
+				return 0, false
+			}
+			v = def.DefaultValue
+		}
+	}
+	value, err := v.Value(c.variables)
+	if err != nil {
+		return 0, false
+	}
+	switch n := value.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,144 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestCost(t *testing.T) {
+	model := CostModel{ObjectCost: 1, MutationCost: 10, ListArguments: []string{"first", "last"}}
+
+	testCases := []struct {
+		desc string
+		req  *Request
+		cost int64
+	}{
+		{
+			desc: "Scalars are free",
+			req:  &Request{Query: "{ hello version }"},
+			cost: 0,
+		},
+		{
+			desc: "Objects cost one",
+			req:  &Request{Query: "{ viewer { name repository { name } } }"},
+			cost: 2,
+		},
+		{
+			desc: "Lists multiply their selections",
+			req:  &Request{Query: "{ users(first: 100) { name posts(last: 10) { title author { name } } } }"},
+			cost: 1 + 100*(1+10*1),
+		},
+		{
+			desc: "List sizes from variables and defaults",
+			req: &Request{
+				Query:     "query Q($n: Int, $m: Int = 5) { a(first: $n) { b { id } } c(first: $m) { d { id } } }",
+				Variables: map[string]interface{}{"n": float64(20)},
+			},
+			cost: 1 + 20 + 1 + 5,
+		},
+		{
+			desc: "Fragments are counted where they are spread",
+			req:  &Request{Query: "{ users(first: 10) { ...U ... on User { friend { id } } } } fragment U on User { org { id } ...U }"},
+			cost: 1 + 10*2,
+		},
+		{
+			desc: "Mutation fields cost extra",
+			req:  &Request{Query: "mutation { like(id: 1) { post { id } } unlike(id: 2) }"},
+			cost: 10 + 2 + 10,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			doc, op, err := tC.req.ParseOperation()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cost, err := model.Cost(doc, op, tC.req.Variables)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cost != tC.cost {
+				t.Errorf("expected cost %d, got %d", tC.cost, cost)
+			}
+		})
+	}
+}
+
+func TestCostWithSchema(t *testing.T) {
+	schema, err := gqlparser.LoadSchema(&ast.Source{Input: `
+type Query { users(limit: Int): [User!]! user(id: ID!): User }
+type User { id: ID! posts: [Post!]! org: Org }
+type Post { id: ID! }
+type Org { id: ID! }
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := CostModel{ObjectCost: 1, ListArguments: []string{"limit"}, Schema: schema, DefaultListSize: 100}
+
+	testCases := []struct {
+		desc  string
+		query string
+		cost  int64
+	}{
+		{"Lists without a limit have the default size", "{ users { id } }", 1 + 100*0},
+		{"Lists without a limit multiply their selections", "{ users { org { id } } }", 1 + 100*1},
+		{"Limits take the place of the default size", "{ users(limit: 5) { posts { id } } }", 1 + 5*(1+100*0)},
+		{"Objects count once", "{ user(id: 1) { org { id } posts { id } } }", 1 + 1 + 1},
+		{"Fragments have the type of their condition", "{ user(id: 1) { ...F } } fragment F on User { posts { org: id } }", 1 + 1},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			doc, op, err := (&Request{Query: tC.query}).ParseOperation()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cost, err := model.Cost(doc, op, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cost != tC.cost {
+				t.Errorf("expected cost %d, got %d", tC.cost, cost)
+			}
+		})
+	}
+}
+
+func TestCostLimits(t *testing.T) {
+	// Every fragment spreads the next one twice, so walking every spread would take 2^40
+	// steps.
+	var b strings.Builder
+	b.WriteString("{ a { ...F0 } }")
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, " fragment F%d on T { x(first: 2) { ...F%d ...F%d } }", i, i+1, i+1)
+	}
+	b.WriteString(" fragment F40 on T { id }")
+	model := CostModel{ObjectCost: 1, ListArguments: []string{"first"}, MaxDepth: 100, MaxSelections: 1000}
+
+	doc, op, err := (&Request{Query: b.String()}).ParseOperation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cost, err := model.Cost(doc, op, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cost < 1<<40 {
+		t.Errorf("expected a cost of at least 2^40, got %d", cost)
+	}
+
+	model.MaxDepth = 10
+	if _, err := model.Cost(doc, op, nil); err == nil {
+		t.Error("expected an error for a query nested deeper than MaxDepth")
+	}
+	model.MaxDepth, model.MaxSelections = 100, 50
+	if _, err := model.Cost(doc, op, nil); err == nil {
+		t.Error("expected an error for a query with more than MaxSelections selections")
+	}
+}
@@ -0,0 +1,134 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// UnknownErrorCode is reported for errors without an extensions.code.
+const UnknownErrorCode = "UNKNOWN"
+
+// ErrorSummary describes the errors of a GraphQL response.
+type ErrorSummary struct {
+	// Codes holds the extensions.code of every error, in order.
+	Codes []string
+	// HasData is set when the response has non-null data.
+	HasData bool
+}
+
+// Count returns the number of errors.
+func (s ErrorSummary) Count() int {
+	return len(s.Codes)
+}
+
+// Partial reports whether the response has errors along with data.
+func (s ErrorSummary) Partial() bool {
+	return len(s.Codes) > 0 && s.HasData
+}
+
+// Failed reports whether the response has errors and no data.
+func (s ErrorSummary) Failed() bool {
+	return len(s.Codes) > 0 && !s.HasData
+}
+
+// ScanErrors reads a GraphQL response from r and summarizes its errors. The response is
+// tokenized as it is read, data is skipped without being decoded.
+func ScanErrors(r io.Reader) (ErrorSummary, error) {
+	var s ErrorSummary
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return s, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return s, err
+		}
+		switch tok {
+		case "data":
+			tok, err := dec.Token()
+			if err != nil {
+				return s, err
+			}
+			if tok != nil {
+				s.HasData = true
+			}
+			if err := skip(dec, tok); err != nil {
+				return s, err
+			}
+		case "errors":
+			tok, err := dec.Token()
+			if err != nil {
+				return s, err
+			}
+			if tok == nil {
+				continue
+			}
+			if tok != json.Delim('[') {
+				return s, fmt.Errorf("errors is not a list")
+			}
+			for dec.More() {
+				var e struct {
+					Extensions struct {
+						Code interface{} `json:"code"`
+					} `json:"extensions"`
+				}
+				if err := dec.Decode(&e); err != nil {
+					return s, err
+				}
+				code, ok := e.Extensions.Code.(string)
+				if !ok || code == "" {
+					code = UnknownErrorCode
+				}
+				s.Codes = append(s.Codes, code)
+			}
+			if _, err := dec.Token(); err != nil {
+				return s, err
+			}
+		default:
+			tok, err := dec.Token()
+			if err != nil {
+				return s, err
+			}
+			if err := skip(dec, tok); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return errors.New("response is not a JSON object")
+	}
+	return nil
+}
+
+// skip consumes the rest of the value starting with tok.
+func skip(dec *json.Decoder, tok json.Token) error {
+	delim, ok := tok.(json.Delim)
+	if !ok || delim == '}' || delim == ']' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ExtensionWriter copies an encoded GraphQL response to the underlying writer as it is
+// written, merging extensions into its top level extensions. The extensions are appended
+// after the members of the response extensions without removing members of the same name;
+// JSON decoders keep the last of duplicate keys. Bodies that are not JSON objects are copied
+// unchanged.
+type ExtensionWriter struct {
+	w io.Writer
+	// object is the encoded extensions, members the same without the braces.
+	object  []byte
+	members []byte
+	out     []byte
+
+	started     bool
+	passthrough bool
+	depth       int
+	inString    bool
+	escaped     bool
+	// empty is set while the object closed at depth 1, the response, or at depth 2, the
+	// response extensions, has no members.
+	empty bool
+	// expectKey is set where the response has the key of a member next, key holds the key
+	// read so far.
+	expectKey bool
+	inKey     bool
+	key       []byte
+	// extensions is set from the key extensions until its value starts, inExtensions while
+	// it is the object at depth 2 and null counts the bytes of a null value dropped.
+	extensions   bool
+	inExtensions bool
+	null         int
+	merged       bool
+}
+
+// NewExtensionWriter returns a writer that copies a GraphQL response to w with ext merged
+// into its extensions.
+func NewExtensionWriter(w io.Writer, ext map[string]interface{}) (*ExtensionWriter, error) {
+	object, err := json.Marshal(ext)
+	if err != nil {
+		return nil, fmt.Errorf("encoding extensions: %w", err)
+	}
+	return &ExtensionWriter{
+		w:       w,
+		object:  object,
+		members: object[1 : len(object)-1],
+		// Without extensions to add the response is copied unchanged.
+		passthrough: len(ext) == 0,
+	}, nil
+}
+
+// Write copies p to the underlying writer, adding the extensions where the response
+// extensions or the response end.
+func (e *ExtensionWriter) Write(p []byte) (int, error) {
+	if e.passthrough {
+		return e.w.Write(p)
+	}
+	e.out = e.out[:0]
+	for i, c := range p {
+		if e.passthrough {
+			e.out = append(e.out, p[i:]...)
+			break
+		}
+		e.scan(c)
+	}
+	if _, err := e.w.Write(e.out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// scan appends c to the output along with the extensions it is the place of.
+func (e *ExtensionWriter) scan(c byte) {
+	if e.inString {
+		e.out = append(e.out, c)
+		switch {
+		case e.escaped:
+			e.escaped = false
+		case c == '\\':
+			e.escaped = true
+		case c == '"':
+			e.inString = false
+			if e.inKey {
+				e.inKey = false
+				e.extensions = string(e.key) == "extensions"
+			}
+		default:
+			if e.inKey && len(e.key) <= len("extensions") {
+				e.key = append(e.key, c)
+			}
+		}
+		return
+	}
+	if isSpace(c) {
+		e.out = append(e.out, c)
+		return
+	}
+	if !e.started {
+		e.started = true
+		if c != '{' {
+			e.passthrough = true
+			e.out = append(e.out, c)
+			return
+		}
+		e.depth = 1
+		e.empty = true
+		e.expectKey = true
+		e.out = append(e.out, c)
+		return
+	}
+	if e.null > 0 {
+		// The null value of the extensions is dropped and replaced by the extensions.
+		e.null++
+		if e.null == len("null") {
+			e.null = 0
+			e.out = append(e.out, e.object...)
+		}
+		return
+	}
+	if e.depth == 0 {
+		e.out = append(e.out, c)
+		return
+	}
+	if c != '}' && c != ']' && (e.depth == 1 || e.inExtensions && e.depth == 2) {
+		e.empty = false
+	}
+	if e.extensions && c != ':' {
+		// The value of the extensions member starts.
+		e.extensions = false
+		switch c {
+		case '{':
+			e.inExtensions = true
+			e.empty = true
+		case 'n':
+			e.null = 1
+			e.merged = true
+			return
+		default:
+			// Extensions that are not objects are invalid and left alone.
+			e.merged = true
+		}
+	}
+	switch c {
+	case '"':
+		e.inString = true
+		if e.depth == 1 && e.expectKey {
+			e.expectKey = false
+			e.inKey = true
+			e.key = e.key[:0]
+		}
+	case '{', '[':
+		e.depth++
+	case '}', ']':
+		e.depth--
+		switch {
+		case e.depth == 1 && e.inExtensions:
+			e.inExtensions = false
+			e.merged = true
+			e.appendMembers()
+		case e.depth == 0 && !e.merged:
+			if !e.empty {
+				e.out = append(e.out, ',')
+			}
+			e.out = append(e.out, `"extensions":`...)
+			e.out = append(e.out, e.object...)
+		}
+	case ',':
+		if e.depth == 1 {
+			e.expectKey = true
+		}
+	}
+	e.out = append(e.out, c)
+}
+
+// appendMembers appends the extensions to the members of the response extensions. Members
+// of the same name are kept, so the extensions win with decoders that keep the last key.
+func (e *ExtensionWriter) appendMembers() {
+	if len(e.members) == 0 {
+		return
+	}
+	if !e.empty {
+		e.out = append(e.out, ',')
+	}
+	e.out = append(e.out, e.members...)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
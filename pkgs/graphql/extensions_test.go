@@ -0,0 +1,73 @@
+package graphql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExtensionWriter(t *testing.T) {
+	ext := map[string]interface{}{"request_id": "r1"}
+
+	testCases := []struct {
+		desc string
+		body string
+		want string
+	}{
+		{
+			desc: "Extensions are added",
+			body: `{"data":{"a":"}"}}`,
+			want: `{"data":{"a":"}"},"extensions":{"request_id":"r1"}}`,
+		},
+		{
+			desc: "Extensions are merged",
+			body: `{"extensions":{"cost":{"x":[1]}, "request_id":"up"}, "data":null}`,
+			want: `{"extensions":{"cost":{"x":[1]}, "request_id":"up","request_id":"r1"}, "data":null}`,
+		},
+		{
+			desc: "Empty extensions",
+			body: `{"data":{},"extensions":{ }}`,
+			want: `{"data":{},"extensions":{ "request_id":"r1"}}`,
+		},
+		{
+			desc: "Null extensions are replaced",
+			body: `{"extensions": null,"data":{"extensions":1}}`,
+			want: `{"extensions": {"request_id":"r1"},"data":{"extensions":1}}`,
+		},
+		{
+			desc: "Keys are told apart from values",
+			body: `{"data":"extensions","x\"extensions":{}}` + "\n",
+			want: `{"data":"extensions","x\"extensions":{},"extensions":{"request_id":"r1"}}` + "\n",
+		},
+		{
+			desc: "Empty responses",
+			body: `{}`,
+			want: `{"extensions":{"request_id":"r1"}}`,
+		},
+		{
+			desc: "Other bodies are copied unchanged",
+			body: `["extensions",{}]`,
+			want: `["extensions",{}]`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var b bytes.Buffer
+			w, err := NewExtensionWriter(&b, ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The body is written in small pieces, as it is streamed.
+			body := []byte(tC.body)
+			for len(body) > 0 {
+				n := min(3, len(body))
+				if _, err := w.Write(body[:n]); err != nil {
+					t.Fatal(err)
+				}
+				body = body[n:]
+			}
+			if b.String() != tC.want {
+				t.Errorf("got %s, want %s", b.String(), tC.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 
+	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/parser"
 )
@@ -34,37 +36,55 @@ type Operation = ast.Operation
 // if OperationName is not specified and there is only one operation in query it returns the name of that operation
 // if the query has multiple operations with same name it will return the first one
 func (r Request) Parse() (Operation, string, error) {
-	doc, err := parser.ParseQuery(&ast.Source{Input: r.Query})
+	_, op, err := r.ParseOperation()
 	if err != nil {
 		return "", "", err
 	}
+	return op.Operation, op.Name, nil
+}
+
+// ParseOperation parses the query of a GraphQL request and returns the parsed document
+// together with the operation that will be executed. The operation is selected using the
+// same rules as Parse.
+func (r Request) ParseOperation() (*ast.QueryDocument, *ast.OperationDefinition, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: r.Query})
+	if err != nil {
+		return nil, nil, err
+	}
 
 	if len(doc.Operations) == 0 {
-		return "", "", errors.New("no operations found")
+		return nil, nil, errors.New("no operations found")
 
 	}
 
 	if len(doc.Operations) == 1 && r.OperationName == "" {
-		return doc.Operations[0].Operation, doc.Operations[0].Name, nil
+		return doc, doc.Operations[0], nil
 	}
 
 	if len(doc.Operations) > 1 && r.OperationName == "" {
-		return "", "", errors.New("query contains multiple operations and no operation name was specified")
+		return nil, nil, errors.New("query contains multiple operations and no operation name was specified")
 	}
 
 	for _, op := range doc.Operations {
 		if op.Name == r.OperationName {
-			return op.Operation, op.Name, nil
+			return doc, op, nil
 		}
 	}
 
-	return "", "", fmt.Errorf("no operation found with name %s", r.OperationName)
+	return nil, nil, fmt.Errorf("no operation found with name %s", r.OperationName)
 }
 
 // Response represents a GraphQL response.
 type Response struct {
-	Data   map[string]interface{} `json:"data,omitempty"`
-	Errors []interface{}          `json:"errors,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Errors     []interface{}          `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Error represents a GraphQL error.
+type Error struct {
+	Message    string                 `json:"message"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
 }
 
 // ParseGraphQLRequest parses a GraphQL request from an http.Request.
@@ -112,3 +132,18 @@ func ParseGraphQLRequest(r *http.Request) (*Request, error) {
 	}
 	return req, nil
 }
+
+// LoadSchema loads a GraphQL schema from a file containing SDL.
+func LoadSchema(filename string) (*ast.Schema, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema file: %w", err)
+	}
+
+	schema, gqlErr := gqlparser.LoadSchema(&ast.Source{Name: filename, Input: string(data)})
+	if gqlErr != nil {
+		return nil, fmt.Errorf("parsing schema file: %w", gqlErr)
+	}
+
+	return schema, nil
+}
@@ -1,6 +1,8 @@
 package graphql
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -109,3 +111,50 @@ func TestRequestParse1(t *testing.T) {
 		})
 	}
 }
+
+func TestScanErrors(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		body    string
+		codes   []string
+		partial bool
+		failed  bool
+	}{
+		{
+			desc: "No errors",
+			body: `{"data":{"user":{"id":1,"friends":[{"id":2}]}}}`,
+		},
+		{
+			desc:    "Partial failure",
+			body:    `{"data":{"user":null},"errors":[{"message":"x","path":["user"],"extensions":{"code":"FORBIDDEN"}}]}`,
+			codes:   []string{"FORBIDDEN"},
+			partial: true,
+		},
+		{
+			desc:   "Total failure",
+			body:   `{"errors":[{"message":"a","extensions":{"code":"UNAVAILABLE"}},{"message":"b"}],"data":null}`,
+			codes:  []string{"UNAVAILABLE", UnknownErrorCode},
+			failed: true,
+		},
+		{
+			desc:   "Errors before data and other members",
+			body:   `{"extensions":{"cost":[1,2]},"errors":[{"message":"a","extensions":{"code":5}}]}`,
+			codes:  []string{UnknownErrorCode},
+			failed: true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			s, err := ScanErrors(strings.NewReader(tC.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s.Codes, tC.codes) {
+				t.Errorf("got codes %v, want %v", s.Codes, tC.codes)
+			}
+			if s.Partial() != tC.partial || s.Failed() != tC.failed {
+				t.Errorf("got partial %v failed %v, want %v %v", s.Partial(), s.Failed(), tC.partial, tC.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package graphql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Signature returns the normalized signature of an operation in the format used by
+// Apollo usage reporting. Two operations that only differ in whitespace, literal
+// argument values, aliases, field ordering or unused fragments have the same signature.
+//
+// The signature is built by:
+//   - dropping fragments that are not reachable from the operation
+//   - replacing numbers with 0, strings with "", lists with [] and objects with {}
+//   - removing aliases
+//   - sorting definitions, selections, arguments, directives and variables
+//   - printing the result with the least amount of whitespace
+func Signature(doc *ast.QueryDocument, op *ast.OperationDefinition) string {
+	p := &signaturePrinter{}
+
+	fragments := usedFragments(doc, op)
+	sort.SliceStable(fragments, func(i, j int) bool {
+		return fragments[i].Name < fragments[j].Name
+	})
+	for _, f := range fragments {
+		p.fragmentDefinition(f)
+	}
+	p.operationDefinition(op)
+
+	return p.b.String()
+}
+
+// SignatureHash returns the hex encoded SHA-256 hash of a signature returned by Signature.
+// The hash keys the metrics of every signature, which hedging takes its delay from, and
+// the fields the usage tracker caches for valid operations. Since the signature leaves out
+// literals and unused fragments, nothing that depends on them may be cached under it.
+func SignatureHash(signature string) string {
+	sum := sha256.Sum256([]byte(signature))
+	return hex.EncodeToString(sum[:])
+}
+
+// OperationDocument returns a document holding only op and the fragments it spreads,
+// directly or through other fragments, such as to validate op on its own.
+func OperationDocument(doc *ast.QueryDocument, op *ast.OperationDefinition) *ast.QueryDocument {
+	return &ast.QueryDocument{
+		Operations: ast.OperationList{op},
+		Fragments:  usedFragments(doc, op),
+		Position:   doc.Position,
+	}
+}
+
+// usedFragments returns the fragments that are transitively referenced by op.
+func usedFragments(doc *ast.QueryDocument, op *ast.OperationDefinition) []*ast.FragmentDefinition {
+	seen := make(map[string]bool)
+	var used []*ast.FragmentDefinition
+
+	var walk func(ss ast.SelectionSet)
+	walk = func(ss ast.SelectionSet) {
+		for _, sel := range ss {
+			switch s := sel.(type) {
+			case *ast.Field:
+				walk(s.SelectionSet)
+			case *ast.InlineFragment:
+				walk(s.SelectionSet)
+			case *ast.FragmentSpread:
+				if seen[s.Name] {
+					continue
+				}
+				seen[s.Name] = true
+				if f := doc.Fragments.ForName(s.Name); f != nil {
+					used = append(used, f)
+					walk(f.SelectionSet)
+				}
+			}
+		}
+	}
+	walk(op.SelectionSet)
+
+	return used
+}
+
+// signaturePrinter prints a document the way graphql-js does and then strips every space
+// that is not between two name characters.
+type signaturePrinter struct {
+	b strings.Builder
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *signaturePrinter) write(tokens ...string) {
+	for _, t := range tokens {
+		if t == "" {
+			continue
+		}
+		if n := p.b.Len(); n > 0 && isNameChar(p.b.String()[n-1]) && isNameChar(t[0]) {
+			p.b.WriteByte(' ')
+		}
+		p.b.WriteString(t)
+	}
+}
+
+func (p *signaturePrinter) operationDefinition(op *ast.OperationDefinition) {
+	// graphql-js omits the keyword for an anonymous query without variables or directives.
+	if op.Operation != ast.Query || op.Name != "" || len(op.VariableDefinitions) > 0 || len(op.Directives) > 0 {
+		p.write(string(op.Operation), op.Name)
+		p.variableDefinitions(op.VariableDefinitions)
+		p.directives(op.Directives)
+	}
+	p.selectionSet(op.SelectionSet)
+}
+
+func (p *signaturePrinter) fragmentDefinition(f *ast.FragmentDefinition) {
+	p.write("fragment", f.Name)
+	p.variableDefinitions(f.VariableDefinition)
+	p.write("on", f.TypeCondition)
+	p.directives(f.Directives)
+	p.selectionSet(f.SelectionSet)
+}
+
+func (p *signaturePrinter) variableDefinitions(defs ast.VariableDefinitionList) {
+	if len(defs) == 0 {
+		return
+	}
+
+	sorted := make(ast.VariableDefinitionList, len(defs))
+	copy(sorted, defs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Variable < sorted[j].Variable
+	})
+
+	p.write("(")
+	for i, def := range sorted {
+		if i > 0 {
+			p.write(",")
+		}
+		p.write("$", def.Variable, ":", def.Type.String())
+		if def.DefaultValue != nil {
+			p.write("=")
+			p.value(def.DefaultValue)
+		}
+		p.directives(def.Directives)
+	}
+	p.write(")")
+}
+
+func (p *signaturePrinter) selectionSet(ss ast.SelectionSet) {
+	if len(ss) == 0 {
+		return
+	}
+
+	sorted := make(ast.SelectionSet, len(ss))
+	copy(sorted, ss)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ki, ni := selectionKey(sorted[i])
+		kj, nj := selectionKey(sorted[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return ni < nj
+	})
+
+	p.write("{")
+	for _, sel := range sorted {
+		switch s := sel.(type) {
+		case *ast.Field:
+			p.write(s.Name)
+			p.arguments(s.Arguments)
+			p.directives(s.Directives)
+			p.selectionSet(s.SelectionSet)
+		case *ast.FragmentSpread:
+			p.write("...", s.Name)
+			p.directives(s.Directives)
+		case *ast.InlineFragment:
+			p.write("...")
+			if s.TypeCondition != "" {
+				p.write("on", s.TypeCondition)
+			}
+			p.directives(s.Directives)
+			p.selectionSet(s.SelectionSet)
+		}
+	}
+	p.write("}")
+}
+
+// selectionKey orders selections by kind and then by name, matching the ordering of the
+// graphql-js AST kinds Field, FragmentSpread and InlineFragment.
+func selectionKey(sel ast.Selection) (int, string) {
+	switch s := sel.(type) {
+	case *ast.Field:
+		return 0, s.Name
+	case *ast.FragmentSpread:
+		return 1, s.Name
+	default:
+		return 2, ""
+	}
+}
+
+func (p *signaturePrinter) arguments(args ast.ArgumentList) {
+	if len(args) == 0 {
+		return
+	}
+
+	sorted := make(ast.ArgumentList, len(args))
+	copy(sorted, args)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	p.write("(")
+	for i, arg := range sorted {
+		if i > 0 {
+			p.write(",")
+		}
+		p.write(arg.Name, ":")
+		p.value(arg.Value)
+	}
+	p.write(")")
+}
+
+func (p *signaturePrinter) directives(dirs ast.DirectiveList) {
+	sorted := make(ast.DirectiveList, len(dirs))
+	copy(sorted, dirs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for _, d := range sorted {
+		p.write("@", d.Name)
+		p.arguments(d.Arguments)
+	}
+}
+
+// value prints a value with its literal hidden. Variables, booleans, enums and null are
+// kept as they are part of the shape of the operation.
+func (p *signaturePrinter) value(v *ast.Value) {
+	switch v.Kind {
+	case ast.Variable:
+		p.write("$", v.Raw)
+	case ast.IntValue, ast.FloatValue:
+		p.write("0")
+	case ast.StringValue, ast.BlockValue:
+		p.write(`""`)
+	case ast.ListValue:
+		p.write("[]")
+	case ast.ObjectValue:
+		p.write("{}")
+	case ast.NullValue:
+		p.write("null")
+	default:
+		p.write(v.Raw)
+	}
+}
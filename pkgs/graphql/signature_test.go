@@ -0,0 +1,91 @@
+package graphql
+
+import (
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		req       *Request
+		signature string
+	}{
+		{
+			desc: "Anonymous query",
+			req: &Request{
+				Query: "{ hello }",
+			},
+			signature: "{hello}",
+		},
+		{
+			desc: "Fields are sorted and aliases removed",
+			req: &Request{
+				Query: "query yo { b: user { name id } a: hello }",
+			},
+			signature: "query yo{hello user{id name}}",
+		},
+		{
+			desc: "Literals are hidden",
+			req: &Request{
+				Query: `query yo { user(id: 10, name: "abc", ratio: 1.5, tags: ["a"], filter: {x: 1}, active: true, role: ADMIN, parent: null) { id } }`,
+			},
+			signature: `query yo{user(active:true,filter:{},id:0,name:"",parent:null,ratio:0,role:ADMIN,tags:[]){id}}`,
+		},
+		{
+			desc: "Variables and directives are sorted",
+			req: &Request{
+				Query: "query yo($b: String = \"x\", $a: [Int!]!) @z @y(if: $a) { user(id: $a) @skip(if: false) { id } }",
+			},
+			signature: `query yo($a:[Int!]!,$b:String="")@y(if:$a)@z{user(id:$a)@skip(if:false){id}}`,
+		},
+		{
+			desc: "Unused fragments are dropped",
+			req: &Request{
+				Query: "query yo { user { ...B ... on User { id } } } fragment B on User { name ...A } fragment A on User { id } fragment C on User { id }",
+			},
+			signature: "fragment A on User{id}fragment B on User{name...A}query yo{user{...B...on User{id}}}",
+		},
+		{
+			desc: "Selected operation only",
+			req: &Request{
+				Query:         "query yo1 { a } mutation yo2 { b(x: 1) }",
+				OperationName: "yo2",
+			},
+			signature: "mutation yo2{b(x:0)}",
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			doc, op, err := tC.req.ParseOperation()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			signature := Signature(doc, op)
+			if signature != tC.signature {
+				t.Errorf("expected %v, got %v", tC.signature, signature)
+			}
+		})
+	}
+}
+
+func TestSignatureHashStable(t *testing.T) {
+	a := &Request{Query: "query yo { user(id: 1) { name id } }"}
+	b := &Request{Query: "query   yo{user(id:   42){ id\n name }}"}
+
+	docA, opA, err := a.ParseOperation()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	docB, opB, err := b.ParseOperation()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hashA := SignatureHash(Signature(docA, opA))
+	hashB := SignatureHash(Signature(docB, opB))
+	if hashA != hashB {
+		t.Errorf("expected equal hashes, got %v and %v", hashA, hashB)
+	}
+}
@@ -0,0 +1,148 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/graphql"
+	"github.com/abdullah2993/graphql-proxy/pkgs/loadbalancer"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+// typenameQuery is sent to upstreams when no health check path is configured.
+const typenameQuery = `{"query":"{ __typename }"}`
+
+// Start probes every upstream of lb on the configured interval. An upstream is marked
+// unhealthy after UnhealthyThreshold failed probes in a row and healthy again after
+// HealthyThreshold successful ones. The returned function stops the probes.
+func Start(cfg config.HealthCheckConfig, lb *loadbalancer.LoadBalancer, m *metrics.Metrics, logger *slog.Logger) func() {
+	if !cfg.Enabled {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &checker{
+		cfg:     cfg,
+		client:  &http.Client{},
+		metrics: m,
+		logger:  logger,
+	}
+
+	var wg sync.WaitGroup
+	for _, u := range lb.Upstreams() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, u)
+		}()
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+type checker struct {
+	cfg     config.HealthCheckConfig
+	client  *http.Client
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+}
+
+func (c *checker) run(ctx context.Context, u *loadbalancer.Upstream) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	var successes, failures int
+	for {
+		err := c.probe(ctx, u)
+		if ctx.Err() != nil {
+			return
+		}
+		u.RecordCheck(err)
+
+		if err == nil {
+			successes, failures = successes+1, 0
+			if !u.Healthy() && successes >= c.cfg.HealthyThreshold && u.SetHealthy(true) {
+				c.logger.Info("upstream became healthy", "upstream", u.URL)
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if u.Healthy() && failures >= c.cfg.UnhealthyThreshold && u.SetHealthy(false) {
+				c.logger.Warn("upstream became unhealthy", "upstream", u.URL, "error", err)
+			}
+		}
+		c.metrics.SetUpstreamHealth(u.URL, u.Healthy())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks the upstream once, with GET on the health check path or with a
+// { __typename } query when there is none.
+func (c *checker) probe(ctx context.Context, u *loadbalancer.Upstream) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	var req *http.Request
+	if c.cfg.Path != "" {
+		target, err := url.Parse(u.URL)
+		if err != nil {
+			return err
+		}
+		target.Path, target.RawQuery = c.cfg.Path, ""
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.URL, strings.NewReader(typenameQuery))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if c.cfg.Path != "" {
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	summary, err := graphql.ScanErrors(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if summary.Count() > 0 {
+		return fmt.Errorf("response has GraphQL errors: %s", strings.Join(summary.Codes, ", "))
+	}
+	if !summary.HasData {
+		return fmt.Errorf("response has no data")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package healthcheck
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/loadbalancer"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if down.Load() {
+			io.WriteString(w, `{"data":null,"errors":[{"message":"db down"}]}`)
+			return
+		}
+		io.WriteString(w, `{"data":{"__typename":"Query"}}`)
+	}))
+	defer upstream.Close()
+
+	lb := loadbalancer.New([]config.UpstreamServer{
+		{URL: upstream.URL, Capabilities: []config.Capability{config.CapabilityQuery}, Weight: 1},
+	}, loadbalancer.Options{})
+	stop := Start(config.HealthCheckConfig{
+		Enabled:            true,
+		Interval:           5 * time.Millisecond,
+		Timeout:            time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}, lb, metrics.New(), slog.New(slog.DiscardHandler))
+	defer stop()
+
+	waitFor := func(healthy bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for lb.Upstreams()[0].Healthy() != healthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("upstream did not become healthy=%v", healthy)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	down.Store(true)
+	waitFor(false)
+	if _, err := lb.GetServer(loadbalancer.Route{Capability: config.CapabilityQuery}); err == nil {
+		t.Error("unhealthy upstream was selected")
+	}
+	if check := lb.Upstreams()[0].LastCheck(); check == nil || check.Error == "" {
+		t.Errorf("last check does not report the error: %+v", check)
+	}
+
+	down.Store(false)
+	waitFor(true)
+	if _, err := lb.GetServer(loadbalancer.Route{Capability: config.CapabilityQuery}); err != nil {
+		t.Errorf("healthy upstream was not selected: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of sources.
+const (
+	KindIP       = "ip"
+	KindHeader   = "header"
+	KindJWT      = "jwt"
+	KindVariable = "variable"
+)
+
+// Source is where the key identifying a request is taken from. It is written as
+// "kind:name":
+//
+//	ip                 the address of the client
+//	header:X-User-ID   a request header
+//	jwt:sub            a claim of the bearer token in the Authorization header
+//	variable:userId    a variable of the GraphQL request
+//
+// Claims and variables can be nested with dots, as in "variable:where.id".
+type Source struct {
+	kind string
+	name string
+	// secret verifies the signature of bearer tokens, which are decoded unverified
+	// without it.
+	secret []byte
+}
+
+// ParseSource parses a source written as "kind:name".
+func ParseSource(spec string) (*Source, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch kind {
+	case KindIP:
+		if name != "" {
+			return nil, fmt.Errorf("identity source %q: ip takes no name", spec)
+		}
+	case KindHeader:
+		name = http.CanonicalHeaderKey(name)
+	case KindJWT, KindVariable:
+	default:
+		return nil, fmt.Errorf("unsupported identity source: %q", spec)
+	}
+	if kind != KindIP && name == "" {
+		return nil, fmt.Errorf("identity source %q has no name", spec)
+	}
+	return &Source{kind: kind, name: name}, nil
+}
+
+// Verified returns a copy of the source that only takes claims from bearer tokens signed
+// with secret using HS256, HS384 or HS512 that have not expired. Tokens that fail
+// verification have no key.
+func (s *Source) Verified(secret []byte) *Source {
+	verified := *s
+	verified.secret = secret
+	return &verified
+}
+
+// Kind returns the kind of the source, such as KindIP.
+func (s *Source) Kind() string {
+	return s.kind
+}
+
+// String returns the source as it is written.
+func (s *Source) String() string {
+	if s.kind == KindIP {
+		return s.kind
+	}
+	return s.kind + ":" + s.name
+}
+
+// Key returns the key of a request, or "" if the request has none. Unless the source is
+// Verified, bearer tokens are decoded without verifying their signature, which is left
+// to the upstreams.
+func (s *Source) Key(r *http.Request, variables map[string]interface{}) string {
+	switch s.kind {
+	case KindIP:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case KindHeader:
+		return r.Header.Get(s.name)
+	case KindJWT:
+		claims := bearerClaims(r, s.secret, time.Now())
+		if claims == nil {
+			return ""
+		}
+		return lookup(claims, s.name)
+	case KindVariable:
+		return lookup(variables, s.name)
+	default:
+		return ""
+	}
+}
+
+// bearerClaims returns the claims of the bearer token of a request, or nil if it has no
+// token that can be decoded. With a secret, tokens must also be signed with it and valid
+// at now.
+func bearerClaims(r *http.Request, secret []byte, now time.Time) map[string]interface{} {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	if secret != nil && !(verifySignature(parts, secret) && validAt(claims, now)) {
+		return nil
+	}
+	return claims
+}
+
+// verifySignature reports whether a token split into its three parts is signed with
+// secret by the HMAC algorithm named in its header.
+func verifySignature(parts []string, secret []byte) bool {
+	header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return false
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return false
+	}
+	var newHash func() hash.Hash
+	switch h.Alg {
+	case "HS256":
+		newHash = sha256.New
+	case "HS384":
+		newHash = sha512.New384
+	case "HS512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// validAt reports whether the exp and nbf claims, where present, allow the token at now.
+func validAt(claims map[string]interface{}, now time.Time) bool {
+	unix := float64(now.Unix())
+	if exp, ok := claims["exp"].(float64); ok && unix >= exp {
+		return false
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && unix < nbf {
+		return false
+	}
+	return true
+}
+
+// lookup returns the value at a dotted path of values as a string. Keys that contain dots
+// themselves, like the claims namespace of Hasura, are matched whole first.
+func lookup(values map[string]interface{}, path string) string {
+	if v, ok := values[path]; ok {
+		return format(v)
+	}
+	for i := strings.IndexByte(path, '.'); i >= 0; {
+		if nested, ok := values[path[:i]].(map[string]interface{}); ok {
+			if key := lookup(nested, path[i+1:]); key != "" {
+				return key
+			}
+		}
+		next := strings.IndexByte(path[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return ""
+}
+
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+}
@@ -0,0 +1,105 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSourceKey(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1","https://hasura.io/jwt/claims":{"x-hasura-user-id":"42"},"org":{"id":7}}`))
+	token := "Bearer eyJhbGciOiJIUzI1NiJ9." + payload + ".c2ln"
+	variables := map[string]interface{}{
+		"userId": "u-9",
+		"where":  map[string]interface{}{"id": float64(12)},
+	}
+
+	tests := []struct {
+		spec          string
+		authorization string
+		want          string
+	}{
+		{"ip", "", "192.0.2.1"},
+		{"header:x-user-id", "", "alice"},
+		{"jwt:sub", token, "user-1"},
+		{"jwt:https://hasura.io/jwt/claims.x-hasura-user-id", token, "42"},
+		{"jwt:org.id", token, "7"},
+		{"jwt:sub", "Basic dXNlcjpwYXNz", ""},
+		{"jwt:sub", "Bearer not-a-token", ""},
+		{"variable:userId", "", "u-9"},
+		{"variable:where.id", "", "12"},
+		{"variable:missing", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			source, err := ParseSource(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("POST", "/graphql", nil)
+			r.Header.Set("X-User-ID", "alice")
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if got := source.Key(r, variables); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifiedSource(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(alg, claims string, key []byte) string {
+		unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"`+alg+`"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(claims))
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(unsigned))
+		return "Bearer " + unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{"signed", sign("HS256", `{"sub":"user-1"}`, secret), "user-1"},
+		{"not expired", sign("HS256", `{"sub":"user-1","exp":`+itoa(future)+`}`, secret), "user-1"},
+		{"expired", sign("HS256", `{"sub":"user-1","exp":`+itoa(past)+`}`, secret), ""},
+		{"not yet valid", sign("HS256", `{"sub":"user-1","nbf":`+itoa(future)+`}`, secret), ""},
+		{"other secret", sign("HS256", `{"sub":"user-1"}`, []byte("guess")), ""},
+		{"unsigned", sign("none", `{"sub":"user-1"}`, secret), ""},
+	}
+	source, err := ParseSource("jwt:sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source = source.Verified(secret)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/graphql", nil)
+			r.Header.Set("Authorization", tt.authorization)
+			if got := source.Key(r, nil); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func TestParseSourceErrors(t *testing.T) {
+	for _, spec := range []string{"", "cookie:session", "header", "header:", "jwt:", "ip:x"} {
+		if _, err := ParseSource(spec); err == nil {
+			t.Errorf("ParseSource(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package loadbalancer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// ErrCircuitOpen is returned by GetServer when the circuits of all eligible upstreams are
+// open.
+var ErrCircuitOpen = errors.New("circuit open for every eligible upstream")
+
+// Circuit states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// breaker is the circuit breaker of an upstream. It opens after FailureThreshold failed
+// requests in a row, counting only requests that could not reach the upstream, timed
+// out or got a 5xx status, rejects requests for OpenDuration, and then lets HalfOpenRequests
+// probes through. The circuit closes once SuccessThreshold probes succeed and opens again
+// as soon as one fails.
+type breaker struct {
+	cfg config.CircuitBreakerConfig
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+// usable reports whether the breaker may let a request through, without reserving it.
+func (b *breaker) usable(now time.Time) bool {
+	if !b.cfg.Enabled {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		return now.Sub(b.openedAt) >= b.cfg.OpenDuration
+	case CircuitHalfOpen:
+		return b.probes < b.cfg.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// acquire lets a request through if the breaker allows it. It returns the state the
+// breaker moved to, or "" if it did not change.
+func (b *breaker) acquire(now time.Time) (bool, string) {
+	if !b.cfg.Enabled {
+		return true, ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	transition := ""
+	if b.state == CircuitOpen {
+		if now.Sub(b.openedAt) < b.cfg.OpenDuration {
+			return false, ""
+		}
+		b.state, b.probes, b.successes = CircuitHalfOpen, 0, 0
+		transition = CircuitHalfOpen
+	}
+	if b.state == CircuitHalfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return false, transition
+		}
+		b.probes++
+	}
+	return true, transition
+}
+
+// record records the outcome of a request the breaker let through. It returns the state
+// the breaker moved to, or "" if it did not change.
+func (b *breaker) record(now time.Time, outcome Outcome) string {
+	if !b.cfg.Enabled {
+		return ""
+	}
+	// An upstream that answers with GraphQL errors is up, whatever caused them.
+	if outcome == Errored {
+		outcome = Success
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		switch outcome {
+		case Success:
+			b.successes++
+			if b.successes >= b.cfg.SuccessThreshold {
+				b.state, b.failures = CircuitClosed, 0
+				return CircuitClosed
+			}
+		case Failure:
+			b.state, b.openedAt = CircuitOpen, now
+			return CircuitOpen
+		}
+	case CircuitOpen:
+		// Requests let through before the circuit opened.
+	default:
+		switch outcome {
+		case Success:
+			b.failures = 0
+		case Failure:
+			b.failures++
+			if b.failures >= b.cfg.FailureThreshold {
+				b.state, b.openedAt = CircuitOpen, now
+				return CircuitOpen
+			}
+		}
+	}
+	return ""
+}
+
+// Circuit returns the state of the circuit breaker of the upstream.
+func (u *Upstream) Circuit() string {
+	u.breaker.mu.Lock()
+	defer u.breaker.mu.Unlock()
+	if u.breaker.state == "" {
+		return CircuitClosed
+	}
+	return u.breaker.state
+}
+
+func (lb *LoadBalancer) circuitTransition(u *Upstream, state string) {
+	if state == "" {
+		return
+	}
+	if state == CircuitOpen {
+		lb.logger.Warn("circuit breaker opened", "upstream", u.URL)
+	} else {
+		lb.logger.Info("circuit breaker state changed", "upstream", u.URL, "state", state)
+	}
+	lb.metrics.SetCircuitState(u.URL, state)
+}
@@ -0,0 +1,83 @@
+package loadbalancer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	lb := New(testServers(1), Options{CircuitBreaker: config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		OpenDuration:     50 * time.Millisecond,
+		HalfOpenRequests: 1,
+		SuccessThreshold: 1,
+	}})
+	u := lb.Upstreams()[0]
+
+	get := func() (*Upstream, error) {
+		return lb.GetServer(Route{Capability: config.CapabilityQuery})
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := get(); err != nil {
+			t.Fatal(err)
+		}
+		lb.Report(u, Failure, 0)
+	}
+	if u.Circuit() != CircuitOpen {
+		t.Fatalf("circuit is %s after 2 failures, want open", u.Circuit())
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := get(); err != nil {
+		t.Fatalf("half-open probe rejected: %v", err)
+	}
+	if u.Circuit() != CircuitHalfOpen {
+		t.Fatalf("circuit is %s after open duration, want half_open", u.Circuit())
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe let through: %v", err)
+	}
+
+	// Canceled probes release their slot without closing the circuit.
+	lb.Report(u, Canceled, 0)
+	if u.Circuit() != CircuitHalfOpen {
+		t.Fatalf("circuit is %s after canceled probe, want half_open", u.Circuit())
+	}
+
+	if _, err := get(); err != nil {
+		t.Fatal(err)
+	}
+	lb.Report(u, Success, 0)
+	if u.Circuit() != CircuitClosed {
+		t.Fatalf("circuit is %s after successful probe, want closed", u.Circuit())
+	}
+}
+
+func TestCircuitBreakerIgnoresGraphQLErrors(t *testing.T) {
+	lb := New(testServers(1), Options{CircuitBreaker: config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+		HalfOpenRequests: 1,
+		SuccessThreshold: 1,
+	}})
+	u := lb.Upstreams()[0]
+
+	for _, outcome := range []Outcome{Failure, Errored, Failure, Errored, Errored} {
+		if _, err := lb.GetServer(Route{Capability: config.CapabilityQuery}); err != nil {
+			t.Fatal(err)
+		}
+		lb.Report(u, outcome, 0)
+	}
+	if u.Circuit() != CircuitClosed {
+		t.Fatalf("circuit is %s after GraphQL errors, want closed", u.Circuit())
+	}
+}
@@ -0,0 +1,147 @@
+package loadbalancer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// vnodesPerWeight is the number of points an upstream gets on the ring for every unit of
+// its weight. More points spread the keys more evenly at the cost of a larger ring.
+const vnodesPerWeight = 160
+
+// rampSteps is the number of steps in which an upstream that warms up gets its points
+// back. The ring is rebuilt at every step, so coarse steps keep rebuilds rare.
+const rampSteps = 10
+
+// consistentHash sends every key to the same upstream for as long as that upstream is
+// eligible. Upstreams are placed on a ring of hashes in proportion to their weight, and a
+// key goes to the first eligible upstream clockwise from its own hash, so that an upstream
+// becoming ineligible only moves the keys it had. Upstreams are placed by their effective
+// weight: an upstream that warms up owns a growing subset of its points, so its keys
+// come back to it gradually. Requests without a key are left to fallback.
+type consistentHash struct {
+	fallback Strategy
+
+	mu sync.RWMutex
+	// known is the number of points of every upstream on the ring, hashes the hashes of
+	// all the points it has at its full weight.
+	known  map[*Upstream]int
+	hashes map[*Upstream][]uint64
+	ring   []vnode
+}
+
+type vnode struct {
+	hash     uint64
+	upstream *Upstream
+}
+
+func newConsistentHash(fallback Strategy) *consistentHash {
+	return &consistentHash{
+		fallback: fallback,
+		known:    make(map[*Upstream]int),
+		hashes:   make(map[*Upstream][]uint64),
+	}
+}
+
+func (ch *consistentHash) Select(route Route, upstreams []*Upstream) *Upstream {
+	if route.Key == "" {
+		return ch.fallback.Select(route, upstreams)
+	}
+	ring := ch.ringWith(upstreams)
+
+	eligible := make(map[*Upstream]bool, len(upstreams))
+	for _, u := range upstreams {
+		eligible[u] = true
+	}
+	h := hashKey(route.Key)
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	for i := range ring {
+		if u := ring[(start+i)%len(ring)].upstream; eligible[u] {
+			return u
+		}
+	}
+	return ch.fallback.Select(route, upstreams)
+}
+
+// ringWith returns a ring that contains every upstream of upstreams with as many points as
+// its effective weight earns it. The ring keeps the upstreams that are not eligible right
+// now, so that their keys come back to them.
+func (ch *consistentHash) ringWith(upstreams []*Upstream) []vnode {
+	points := make([]int, len(upstreams))
+	for i, u := range upstreams {
+		points[i] = vnodes(u)
+	}
+
+	ch.mu.RLock()
+	ring, current := ch.ring, ch.current(upstreams, points)
+	ch.mu.RUnlock()
+	if current {
+		return ring
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.current(upstreams, points) {
+		return ch.ring
+	}
+	for i, u := range upstreams {
+		ch.known[u] = points[i]
+	}
+	ring = make([]vnode, 0, len(ch.ring))
+	for u, n := range ch.known {
+		// The points of an upstream are numbered, so that an upstream with fewer points
+		// has a subset of the ones it has at its full weight.
+		hashes := ch.hashes[u]
+		for i := len(hashes); i < n; i++ {
+			sum := sha256.Sum256([]byte(u.URL + "#" + strconv.Itoa(i)))
+			hashes = append(hashes, binary.BigEndian.Uint64(sum[:8]))
+		}
+		ch.hashes[u] = hashes
+		for _, h := range hashes[:n] {
+			ring = append(ring, vnode{hash: h, upstream: u})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	// The old ring is left untouched for the readers that still use it.
+	ch.ring = ring
+	return ring
+}
+
+func (ch *consistentHash) current(upstreams []*Upstream, points []int) bool {
+	for i, u := range upstreams {
+		if n, ok := ch.known[u]; !ok || n != points[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// vnodes returns the number of points of an upstream: its share of the points of its
+// full weight, rounded to a step of the ramp but at least one step.
+func vnodes(u *Upstream) int {
+	if u.Weight <= 0 {
+		return 0
+	}
+	share := u.EffectiveWeight() / float64(u.Weight)
+	steps := max(math.Round(share*rampSteps), 1)
+	return int(steps * float64(u.Weight*vnodesPerWeight) / rampSteps)
+}
+
+// hashKey hashes a key onto the ring. FNV alone clusters similar keys like sequential
+// IDs, so its result is mixed with the finalizer of SplitMix64.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
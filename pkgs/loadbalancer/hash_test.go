@@ -0,0 +1,146 @@
+package loadbalancer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// hashRoutes returns the upstream every one of n keys is sent to.
+func hashRoutes(t *testing.T, lb *LoadBalancer, n int) map[string]*Upstream {
+	t.Helper()
+	routes := make(map[string]*Upstream, n)
+	for i := 0; i < n; i++ {
+		key := "user-" + strconv.Itoa(i)
+		u, err := lb.GetServer(Route{Capability: config.CapabilityQuery, Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lb.Report(u, Success, 0)
+		routes[key] = u
+	}
+	return routes
+}
+
+func TestConsistentHashIsSticky(t *testing.T) {
+	strategy, _ := NewStrategy(config.StrategyConsistentHash)
+	lb := New(weightedServers(1, 1, 1), Options{Strategy: strategy})
+
+	first := hashRoutes(t, lb, 1000)
+	for key, u := range hashRoutes(t, lb, 1000) {
+		if first[key] != u {
+			t.Fatalf("key %s moved from %s to %s", key, first[key].URL, u.URL)
+		}
+	}
+}
+
+func TestConsistentHashRemapsOnlyRemovedKeys(t *testing.T) {
+	strategy, _ := NewStrategy(config.StrategyConsistentHash)
+	lb := New(weightedServers(1, 1, 1, 1), Options{Strategy: strategy})
+	removed := lb.Upstreams()[1]
+
+	before := hashRoutes(t, lb, 2000)
+	removed.SetHealthy(false)
+	after := hashRoutes(t, lb, 2000)
+
+	moved := 0
+	for key, u := range after {
+		if u == removed {
+			t.Fatalf("key %s sent to the unhealthy upstream", key)
+		}
+		if before[key] != u {
+			if before[key] != removed {
+				t.Fatalf("key %s moved off the healthy upstream %s", key, before[key].URL)
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / 2000; share < 0.15 || share > 0.35 {
+		t.Fatalf("%.3f of the keys moved, want about 0.25", share)
+	}
+
+	removed.SetHealthy(true)
+	for key, u := range hashRoutes(t, lb, 2000) {
+		if before[key] != u {
+			t.Fatalf("key %s did not return to %s", key, before[key].URL)
+		}
+	}
+}
+
+func TestConsistentHashFollowsWeights(t *testing.T) {
+	strategy, _ := NewStrategy(config.StrategyConsistentHash)
+	lb := New(weightedServers(1, 2, 3), Options{Strategy: strategy})
+
+	counts := make(map[*Upstream]int)
+	for _, u := range hashRoutes(t, lb, 6000) {
+		counts[u]++
+	}
+	var shares []float64
+	for _, u := range lb.Upstreams() {
+		shares = append(shares, float64(counts[u])/6000)
+	}
+	assertShares(t, shares, []float64{1.0 / 6, 2.0 / 6, 3.0 / 6}, 0.04)
+}
+
+func TestConsistentHashRampsUpKeys(t *testing.T) {
+	strategy, _ := NewStrategy(config.StrategyConsistentHash)
+	lb := New(weightedServers(1, 1), Options{
+		Strategy: strategy,
+		SlowStart: config.SlowStartConfig{
+			Window:           time.Hour,
+			Aggression:       1,
+			MinWeightPercent: 10,
+		},
+	})
+	recovered := lb.Upstreams()[0]
+
+	before := hashRoutes(t, lb, 4000)
+	recovered.SetHealthy(false)
+	recovered.SetHealthy(true)
+
+	counts := make(map[*Upstream]int)
+	for key, u := range hashRoutes(t, lb, 4000) {
+		if u == recovered && before[key] != recovered {
+			t.Fatalf("key %s moved to the recovered upstream from %s", key, before[key].URL)
+		}
+		counts[u]++
+	}
+	assertShares(t, []float64{float64(counts[recovered]) / 4000}, []float64{1.0 / 11}, 0.03)
+
+	recovered.slowStart.since.Store(0)
+	for key, u := range hashRoutes(t, lb, 4000) {
+		if before[key] != u {
+			t.Fatalf("key %s did not return to %s after the ramp", key, before[key].URL)
+		}
+	}
+}
+
+func BenchmarkConsistentHashSlowStart(b *testing.B) {
+	strategy, _ := NewStrategy(config.StrategyConsistentHash)
+	lb := New(weightedServers(100, 100, 100), Options{
+		Strategy: strategy,
+		SlowStart: config.SlowStartConfig{
+			Window:           10 * time.Second,
+			Aggression:       1,
+			MinWeightPercent: 1,
+		},
+	})
+	recovered := lb.Upstreams()[0]
+	recovered.SetHealthy(false)
+	recovered.SetHealthy(true)
+
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "user-" + strconv.Itoa(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u, err := lb.GetServer(Route{Capability: config.CapabilityQuery, Key: keys[i%len(keys)]})
+		if err != nil {
+			b.Fatal(err)
+		}
+		lb.Report(u, Success, 0)
+	}
+}
@@ -0,0 +1,150 @@
+package loadbalancer
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// limiter adjusts the number of requests an upstream may have in flight to the latency it
+// answers with, finding the concurrency it can take without queueing requests itself.
+type limiter struct {
+	cfg       config.AdaptiveConcurrencyConfig
+	algorithm limitAlgorithm
+
+	mu    sync.Mutex
+	value float64
+	// current is value rounded, for reading without the lock.
+	current atomic.Int64
+}
+
+// limitAlgorithm returns the new limit after a request that took rtt, with inflight
+// requests in flight when it ended. It is called with the lock of the limiter held.
+type limitAlgorithm interface {
+	update(limit float64, rtt time.Duration, inflight int64, failed bool) float64
+}
+
+func newLimiter(cfg config.AdaptiveConcurrencyConfig) *limiter {
+	if !cfg.Enabled {
+		return nil
+	}
+	l := &limiter{cfg: cfg, value: float64(cfg.InitialLimit)}
+	switch cfg.Algorithm {
+	case config.AdaptiveVegas:
+		l.algorithm = &vegas{}
+	case config.AdaptiveAIMD:
+		l.algorithm = aimd{threshold: cfg.LatencyThreshold, backoff: cfg.BackoffRatio}
+	default:
+		l.algorithm = &gradient{tolerance: cfg.Tolerance, smoothing: cfg.Smoothing}
+	}
+	l.current.Store(int64(cfg.InitialLimit))
+	return l
+}
+
+func (l *limiter) limit() int64 {
+	return l.current.Load()
+}
+
+// observe feeds a request to the algorithm and reports whether the limit changed.
+func (l *limiter) observe(rtt time.Duration, inflight int64, failed bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.value = l.algorithm.update(l.value, rtt, inflight, failed)
+	l.value = min(max(l.value, float64(l.cfg.MinLimit)), float64(l.cfg.MaxLimit))
+	limit := int64(math.Round(l.value))
+	return l.current.Swap(limit) != limit
+}
+
+// gradient compares the latency of every request with the long term average latency. The
+// limit shrinks in proportion as the latency rises above the average by more than
+// tolerance, and otherwise grows by the square root of the limit, the number of requests
+// the upstream is allowed to queue. The average follows drops of the latency over
+// gradientWindow requests but rises ten times slower, so that it is not taken over by
+// the latency the limit lets the upstream queue.
+type gradient struct {
+	tolerance float64
+	smoothing float64
+	long      float64
+}
+
+const gradientWindow = 600
+
+func (g *gradient) update(limit float64, rtt time.Duration, inflight int64, failed bool) float64 {
+	short := float64(rtt)
+	if g.long == 0 {
+		g.long = short
+	}
+	if short > g.long {
+		g.long += (short - g.long) / (10 * gradientWindow)
+	} else {
+		g.long += (short - g.long) / gradientWindow
+	}
+	// When the latency dropped a lot the average is too high to notice it rising again.
+	if g.long/short > 2 {
+		g.long *= 0.95
+	}
+	// Requests that fail fast or an upstream that is not busy say little about the limit.
+	if failed || float64(inflight) < limit/2 {
+		return limit
+	}
+
+	grad := min(max(g.tolerance*g.long/short, 0.5), 1)
+	next := limit*grad + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + next*g.smoothing
+}
+
+// vegas estimates the number of requests queued in the upstream from how much the latency
+// exceeds the lowest latency seen, and grows the limit while few are queued and shrinks
+// it when many are. The lowest latency is probed again every vegasProbe requests in case
+// the upstream got slower for good.
+type vegas struct {
+	minRTT   time.Duration
+	requests int
+}
+
+const vegasProbe = 1000
+
+func (v *vegas) update(limit float64, rtt time.Duration, inflight int64, failed bool) float64 {
+	v.requests++
+	if v.minRTT == 0 || rtt < v.minRTT || v.requests%vegasProbe == 0 {
+		v.minRTT = rtt
+	}
+	step := math.Max(math.Log10(limit), 1)
+	if failed {
+		return limit - step
+	}
+	if float64(inflight) < limit/2 {
+		return limit
+	}
+
+	queued := limit * (1 - float64(v.minRTT)/float64(rtt))
+	switch {
+	case queued < 3*step:
+		return limit + step
+	case queued > 6*step:
+		return limit - step
+	default:
+		return limit
+	}
+}
+
+// aimd grows the limit by one for every request while the upstream is busy and cuts it
+// by backoff for every request that fails or takes longer than threshold.
+type aimd struct {
+	threshold time.Duration
+	backoff   float64
+}
+
+func (a aimd) update(limit float64, rtt time.Duration, inflight int64, failed bool) float64 {
+	switch {
+	case failed || rtt > a.threshold:
+		return limit * a.backoff
+	case float64(inflight) >= limit/2:
+		return limit + 1
+	default:
+		return limit
+	}
+}
@@ -0,0 +1,107 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// simulate feeds a limiter with n requests to an upstream that takes base until more than
+// capacity requests are in flight and queues the rest, keeping the upstream busy at the
+// limit.
+func simulate(l *limiter, n int, base time.Duration, capacity int64) {
+	for i := 0; i < n; i++ {
+		inflight := l.limit()
+		rtt := base
+		if inflight > capacity {
+			rtt = base * time.Duration(inflight) / time.Duration(capacity)
+		}
+		l.observe(rtt, inflight, false)
+	}
+}
+
+func TestLimiterConverges(t *testing.T) {
+	for _, algorithm := range []string{config.AdaptiveGradient, config.AdaptiveVegas, config.AdaptiveAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			l := newLimiter(config.AdaptiveConcurrencyConfig{
+				Enabled:          true,
+				Algorithm:        algorithm,
+				InitialLimit:     5,
+				MinLimit:         1,
+				MaxLimit:         1000,
+				Tolerance:        1.5,
+				Smoothing:        0.2,
+				LatencyThreshold: 15 * time.Millisecond,
+				BackoffRatio:     0.9,
+			})
+
+			simulate(l, 2000, 10*time.Millisecond, 20)
+			if limit := l.limit(); limit < 15 || limit > 60 {
+				t.Fatalf("limit = %d for an upstream that takes 20 requests", limit)
+			}
+
+			// The upstream slows down for good and can take fewer requests.
+			before := l.limit()
+			simulate(l, 200, 10*time.Millisecond, 5)
+			if limit := l.limit(); limit >= before {
+				t.Fatalf("limit = %d after the upstream slowed down, was %d", limit, before)
+			}
+		})
+	}
+}
+
+func TestAdaptiveLimitSheds(t *testing.T) {
+	lb := New(testServers(1), Options{Adaptive: config.AdaptiveConcurrencyConfig{
+		Enabled:      true,
+		Algorithm:    config.AdaptiveAIMD,
+		InitialLimit: 2,
+		MinLimit:     1,
+		MaxLimit:     10,
+		// Every request is slow, so the limit only goes down.
+		LatencyThreshold: time.Nanosecond,
+		BackoffRatio:     0.5,
+	}})
+
+	u, _ := lb.GetServer(queryRoute)
+	if _, err := lb.GetServer(queryRoute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.GetServer(queryRoute); err == nil {
+		t.Fatal("request over the limit was not shed")
+	}
+	lb.Report(u, Success, time.Millisecond)
+	if limit := u.ConcurrencyLimit(); limit != 1 {
+		t.Fatalf("limit = %d after a slow request, want 1", limit)
+	}
+	if _, err := lb.GetServer(queryRoute); err == nil {
+		t.Fatal("request over the lowered limit was not shed")
+	}
+}
+
+func TestAdaptiveLimitIgnoresGraphQLErrors(t *testing.T) {
+	for _, algorithm := range []string{config.AdaptiveVegas, config.AdaptiveAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			lb := New(testServers(1), Options{Adaptive: config.AdaptiveConcurrencyConfig{
+				Enabled:          true,
+				Algorithm:        algorithm,
+				InitialLimit:     10,
+				MinLimit:         1,
+				MaxLimit:         10,
+				LatencyThreshold: time.Second,
+				BackoffRatio:     0.5,
+			}})
+			u := lb.Upstreams()[0]
+
+			for i := 0; i < 20; i++ {
+				if _, err := lb.GetServer(queryRoute); err != nil {
+					t.Fatal(err)
+				}
+				lb.Report(u, Errored, time.Millisecond)
+			}
+			if limit := u.ConcurrencyLimit(); limit != 10 {
+				t.Fatalf("limit = %d after fast requests with GraphQL errors, want 10", limit)
+			}
+		})
+	}
+}
@@ -2,44 +2,208 @@ package loadbalancer
 
 import (
 	"fmt"
+	"log/slog"
 	"math/rand"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
 )
 
+// Upstream is an upstream server together with the state the load balancer keeps for it.
+type Upstream struct {
+	config.UpstreamServer
+
+	healthy   atomic.Bool
+	lastCheck atomic.Pointer[Check]
+	outlier   outlierState
+	breaker   breaker
+	slowStart slowStart
+	waiting   queue
+	limiter   *limiter
+
+	outstanding atomic.Int64
+	ewma        ewma
+}
+
+// Check is the result of the last health check of an upstream.
+type Check struct {
+	Time  time.Time
+	Error string
+}
+
+// Healthy reports whether the upstream passes its health checks. Upstreams are healthy
+// until a health check fails.
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+// SetHealthy marks the upstream healthy or unhealthy and reports whether that changed.
+// An upstream that becomes healthy starts slowly.
+func (u *Upstream) SetHealthy(healthy bool) bool {
+	changed := u.healthy.Swap(healthy) != healthy
+	if changed && healthy {
+		u.slowStart.begin(time.Now())
+	}
+	return changed
+}
+
+// RecordCheck records the result of a health check.
+func (u *Upstream) RecordCheck(err error) {
+	check := &Check{Time: time.Now()}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	u.lastCheck.Store(check)
+}
+
+// LastCheck returns the result of the last health check, or nil if the upstream has not
+// been checked.
+func (u *Upstream) LastCheck() *Check {
+	return u.lastCheck.Load()
+}
+
+// Outstanding returns the number of requests sent to the upstream that have not been
+// reported yet.
+func (u *Upstream) Outstanding() int64 {
+	return u.outstanding.Load()
+}
+
+// Latency returns the peak EWMA latency of the upstream, or 0 before any request to it
+// was reported.
+func (u *Upstream) Latency() time.Duration {
+	return time.Duration(u.ewma.value(time.Now()))
+}
+
+// Available reports whether requests can be sent to the upstream: it is healthy and not
+// ejected.
+func (u *Upstream) Available() bool {
+	return u.Healthy() && !u.Ejected()
+}
+
 type LoadBalancer struct {
 	mu      sync.RWMutex
-	servers []config.UpstreamServer
-	weights []int
-	total   int
+	servers []*Upstream
+
+	outlier config.OutlierDetectionConfig
+	ejectMu sync.Mutex
+
+	strategy   Strategy
+	strategies map[config.Capability]Strategy
+
+	logger  *slog.Logger
+	metrics *metrics.Metrics
 }
 
-func New(servers []config.UpstreamServer) *LoadBalancer {
-	weights := make([]int, len(servers))
-	total := 0
+// Options configures the load balancer. Strategy defaults to weighted random selection
+// and is overridden for operation types by Strategies. EWMADecay is the time over which
+// the latency of an upstream is forgotten. SlowStart ramps up the weight of upstreams
+// that return to service. Adaptive limits the requests in flight to every upstream to
+// what its latency shows it can take. Logger and Metrics default to discarding what is
+// recorded.
+type Options struct {
+	Outlier        config.OutlierDetectionConfig
+	CircuitBreaker config.CircuitBreakerConfig
+	SlowStart      config.SlowStartConfig
+	Adaptive       config.AdaptiveConcurrencyConfig
+	Strategy       Strategy
+	Strategies     map[config.Capability]Strategy
+	EWMADecay      time.Duration
+	Logger         *slog.Logger
+	Metrics        *metrics.Metrics
+}
+
+func New(servers []config.UpstreamServer, opts Options) *LoadBalancer {
+	if opts.EWMADecay == 0 {
+		opts.EWMADecay = 10 * time.Second
+	}
 
+	upstreams := make([]*Upstream, len(servers))
 	for i, server := range servers {
-		total += server.Weight
-		weights[i] = total
+		upstreams[i] = &Upstream{UpstreamServer: server}
+		upstreams[i].healthy.Store(true)
+		upstreams[i].breaker.cfg = opts.CircuitBreaker
+		upstreams[i].ewma.decay = opts.EWMADecay
+		upstreams[i].slowStart.cfg = opts.SlowStart
+		upstreams[i].limiter = newLimiter(opts.Adaptive)
+	}
+
+	if opts.Strategy == nil {
+		opts.Strategy = weightedRandom{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.New()
+	}
+
+	for _, u := range upstreams {
+		if limit := u.ConcurrencyLimit(); limit > 0 {
+			opts.Metrics.SetConcurrencyLimit(u.URL, int(limit))
+		}
 	}
 
 	return &LoadBalancer{
-		servers: servers,
-		weights: weights,
-		total:   total,
+		servers:    upstreams,
+		outlier:    opts.Outlier,
+		strategy:   opts.Strategy,
+		strategies: opts.Strategies,
+		logger:     opts.Logger,
+		metrics:    opts.Metrics,
 	}
 }
 
-func (lb *LoadBalancer) GetServer(capability config.Capability, operationName string) (*config.UpstreamServer, error) {
+// Upstreams returns every upstream, in the order they are configured.
+func (lb *LoadBalancer) Upstreams() []*Upstream {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
+	return append([]*Upstream(nil), lb.servers...)
+}
+
+// Route describes the request an upstream is selected for.
+type Route struct {
+	Capability    config.Capability
+	OperationName string
+	// Exclude lists upstreams that must not be selected, such as the ones a retried
+	// request already failed on.
+	Exclude []*Upstream
+	// Key identifies the client for strategies that send the same client to the same
+	// upstream.
+	Key string
+}
+
+func (r Route) excludes(u *Upstream) bool {
+	for _, excluded := range r.Exclude {
+		if excluded == u {
+			return true
+		}
+	}
+	return false
+}
+
+// GetServer selects an upstream for route and takes one of its slots, which Report gives
+// back. Upstreams at their concurrency limit are passed over, ErrSaturated is returned
+// when every upstream that could serve the request is.
+func (lb *LoadBalancer) GetServer(route Route) (*Upstream, error) {
+	server, _, err := lb.getServer(route)
+	return server, err
+}
+
+// getServer is GetServer that also returns the upstreams passed over for being saturated.
+func (lb *LoadBalancer) getServer(route Route) (*Upstream, []*Upstream, error) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	now := time.Now()
+	capability, operationName := route.Capability, route.OperationName
 
 	// Filter servers that support the required capability and operation name
-	var eligible []config.UpstreamServer
-	var eligibleWeights []int
-	totalWeight := 0
+	var eligible, saturated []*Upstream
+	unhealthy, open, excluded := 0, 0, 0
 
 	for _, server := range lb.servers {
 		// Check capabilities
@@ -57,28 +221,124 @@ func (lb *LoadBalancer) GetServer(capability config.Capability, operationName st
 		// Check operation names
 		// If server has no operation names defined, it can handle all operations
 		// Otherwise, check if it can handle this specific operation
-		if len(server.OperationNames) == 0 || containsOperationName(server.OperationNames, operationName) {
-			eligible = append(eligible, server)
-			totalWeight += server.Weight
-			eligibleWeights = append(eligibleWeights, totalWeight)
+		if len(server.OperationNames) != 0 && !containsOperationName(server.OperationNames, operationName) {
+			continue
+		}
+
+		if route.excludes(server) {
+			excluded++
+			continue
+		}
+		if !server.Available() {
+			unhealthy++
+			continue
+		}
+		if !server.breaker.usable(now) {
+			open++
+			continue
+		}
+		if server.saturated() {
+			saturated = append(saturated, server)
+			continue
 		}
+
+		eligible = append(eligible, server)
+	}
+
+	strategy, ok := lb.strategies[capability]
+	if !ok {
+		strategy = lb.strategy
+	}
+
+	// The selected server may have filled up since it was found eligible, or its breaker
+	// may refuse the request if other requests took its half-open probes in the meantime,
+	// another server is selected then.
+	for len(eligible) > 0 {
+		server := strategy.Select(route, eligible)
+		eligible = slices.DeleteFunc(eligible, func(u *Upstream) bool { return u == server })
+		if !server.tryAcquire() {
+			saturated = append(saturated, server)
+			continue
+		}
+		ok, transition := server.breaker.acquire(now)
+		lb.circuitTransition(server, transition)
+		if ok {
+			return server, nil, nil
+		}
+		lb.release(server)
+		open++
 	}
 
-	if len(eligible) == 0 {
-		return nil, fmt.Errorf("no servers available for capability: %s and operation: %s", capability, operationName)
+	switch {
+	case len(saturated) > 0:
+		return nil, saturated, fmt.Errorf("no servers available for capability: %s and operation: %s: %w", capability, operationName, ErrSaturated)
+	case open > 0:
+		return nil, nil, fmt.Errorf("no servers available for capability: %s and operation: %s: %w", capability, operationName, ErrCircuitOpen)
+	case unhealthy > 0:
+		return nil, nil, fmt.Errorf("no healthy servers available for capability: %s and operation: %s", capability, operationName)
+	case excluded > 0:
+		return nil, nil, fmt.Errorf("no other servers available for capability: %s and operation: %s", capability, operationName)
+	default:
+		return nil, nil, fmt.Errorf("no servers available for capability: %s and operation: %s", capability, operationName)
+	}
+}
+
+// pickWeighted returns the index of a server selected at random in proportion to its
+// effective weight.
+func pickWeighted(servers []*Upstream) int {
+	weights := make([]float64, len(servers))
+	totalWeight := 0.0
+	for i, server := range servers {
+		weights[i] = server.EffectiveWeight()
+		totalWeight += weights[i]
 	}
 
 	// Weighted random selection
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	point := r.Intn(totalWeight)
+	point := r.Float64() * totalWeight
 
-	for i, weight := range eligibleWeights {
-		if point < weight {
-			return &eligible[i], nil
+	for i := range servers {
+		point -= weights[i]
+		if point < 0 {
+			return i
 		}
 	}
 
-	return &eligible[len(eligible)-1], nil
+	return len(servers) - 1
+}
+
+// Outcome is the result of a request sent to an upstream.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	// Failure means the upstream could not be reached, timed out or answered with a 5xx
+	// status.
+	Failure
+	// Errored requests were answered with GraphQL errors whose codes are configured as
+	// upstream failures. Only outlier detection counts them against the upstream.
+	Errored
+	// Canceled requests were abandoned before the upstream answered, for example because
+	// the client went away. They say nothing about the upstream.
+	Canceled
+)
+
+// Report records the outcome and latency of a request sent to an upstream returned by
+// GetServer or WaitServer. It must be called once for every upstream they return.
+func (lb *LoadBalancer) Report(u *Upstream, outcome Outcome, latency time.Duration) {
+	now := time.Now()
+	lb.release(u)
+	lb.circuitTransition(u, u.breaker.record(now, outcome))
+	if outcome != Canceled {
+		u.ewma.observe(now, latency)
+		lb.detectOutlier(u, outcome == Success)
+		// GraphQL errors say nothing about how much the upstream can take, only its
+		// latency and failures to answer do.
+		if u.limiter != nil && u.limiter.observe(latency, u.Outstanding()+1, outcome == Failure) {
+			lb.metrics.SetConcurrencyLimit(u.URL, int(u.ConcurrencyLimit()))
+			lb.admit(u)
+		}
+	}
 }
 
 func containsOperationName(names []string, target string) bool {
@@ -0,0 +1,164 @@
+package loadbalancer
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// outlierState tracks the results of the requests sent to an upstream to eject it when it
+// fails too many of them.
+type outlierState struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	requests            int
+	failures            int
+	windowStart         time.Time
+	ejections           int
+	ejectedUntil        time.Time
+
+	ejected atomic.Bool
+}
+
+// Ejected reports whether the upstream is ejected because it failed too many requests.
+func (u *Upstream) Ejected() bool {
+	return u.outlier.ejected.Load()
+}
+
+// Ejection returns the number of times the upstream was ejected in a row and, while it is
+// ejected, when it returns.
+func (u *Upstream) Ejection() (int, time.Time) {
+	u.outlier.mu.Lock()
+	defer u.outlier.mu.Unlock()
+	if !u.outlier.ejected.Load() {
+		return u.outlier.ejections, time.Time{}
+	}
+	return u.outlier.ejections, u.outlier.ejectedUntil
+}
+
+// detectOutlier records the result of a request sent to an upstream. Upstreams that fail
+// ConsecutiveFailures requests in a row, or more than ErrorRatio of at least MinRequests
+// requests in an interval, are ejected unless that would eject more than
+// MaxEjectionPercent of the upstreams that serve the same operations. Each ejection in a
+// row lasts twice as long as the previous one, up to MaxEjectionTime.
+func (lb *LoadBalancer) detectOutlier(u *Upstream, success bool) {
+	cfg := lb.outlier
+	if !cfg.Enabled || u.Ejected() {
+		return
+	}
+
+	o := &u.outlier
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(o.windowStart) >= cfg.Interval {
+		// An upstream that went a whole interval without being ejected is forgiven one of
+		// its ejections.
+		if o.ejections > 0 && now.Sub(o.ejectedUntil) >= cfg.Interval {
+			o.ejections--
+		}
+		o.windowStart, o.requests, o.failures = now, 0, 0
+	}
+
+	o.requests++
+	if success {
+		o.consecutiveFailures = 0
+		return
+	}
+	o.failures++
+	o.consecutiveFailures++
+
+	reason := ""
+	switch {
+	case o.consecutiveFailures >= cfg.ConsecutiveFailures:
+		reason = "consecutive_failures"
+	case o.requests >= cfg.MinRequests && float64(o.failures)/float64(o.requests) >= cfg.ErrorRatio:
+		reason = "error_ratio"
+	default:
+		return
+	}
+
+	lb.ejectMu.Lock()
+	defer lb.ejectMu.Unlock()
+
+	if !lb.canEject(u) {
+		return
+	}
+
+	duration := cfg.BaseEjectionTime << o.ejections
+	if duration > cfg.MaxEjectionTime || duration <= 0 {
+		duration = cfg.MaxEjectionTime
+	}
+	o.ejections++
+	o.ejectedUntil = now.Add(duration)
+	o.consecutiveFailures, o.requests, o.failures = 0, 0, 0
+	o.ejected.Store(true)
+
+	lb.logger.Warn("ejecting upstream",
+		"upstream", u.URL,
+		"reason", reason,
+		"duration", duration,
+		"ejections", o.ejections,
+	)
+	lb.metrics.SetUpstreamEjected(u.URL, true)
+
+	time.AfterFunc(duration, func() {
+		o.mu.Lock()
+		o.windowStart = time.Now()
+		o.ejected.Store(false)
+		o.mu.Unlock()
+		u.slowStart.begin(time.Now())
+
+		lb.logger.Info("upstream returned from ejection", "upstream", u.URL)
+		lb.metrics.SetUpstreamEjected(u.URL, false)
+	})
+}
+
+// canEject reports whether u can be ejected without exceeding MaxEjectionPercent of the
+// upstreams that serve the same operations, or leaving none of them, for any of its
+// capabilities.
+func (lb *LoadBalancer) canEject(u *Upstream) bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	for _, capability := range u.Capabilities {
+		total, ejected := 0, 0
+		for _, v := range lb.servers {
+			if !v.serves(capability, u.OperationNames) {
+				continue
+			}
+			total++
+			if v.Ejected() {
+				ejected++
+			}
+		}
+		if ejected+1 >= total || (ejected+1)*100 > lb.outlier.MaxEjectionPercent*total {
+			return false
+		}
+	}
+	return true
+}
+
+// serves reports whether the upstream serves every operation of a capability with the
+// given names, every operation when names is empty.
+func (u *Upstream) serves(capability config.Capability, names []string) bool {
+	if !slices.Contains(u.Capabilities, capability) {
+		return false
+	}
+	if len(u.OperationNames) == 0 {
+		return true
+	}
+	if len(names) == 0 {
+		return false
+	}
+	for _, name := range names {
+		if !containsOperationName(u.OperationNames, name) {
+			return false
+		}
+	}
+	return true
+}
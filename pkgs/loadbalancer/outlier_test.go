@@ -0,0 +1,144 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func testServers(n int) []config.UpstreamServer {
+	servers := make([]config.UpstreamServer, n)
+	for i := range servers {
+		servers[i] = config.UpstreamServer{
+			URL:          "http://upstream" + string(rune('a'+i)),
+			Capabilities: []config.Capability{config.CapabilityQuery},
+			Weight:       1,
+		}
+	}
+	return servers
+}
+
+func TestOutlierEjection(t *testing.T) {
+	lb := New(testServers(3), Options{Outlier: config.OutlierDetectionConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 3,
+		ErrorRatio:          1,
+		MinRequests:         100,
+		Interval:            time.Minute,
+		BaseEjectionTime:    50 * time.Millisecond,
+		MaxEjectionTime:     time.Second,
+		MaxEjectionPercent:  50,
+	}})
+	upstreams := lb.Upstreams()
+
+	fail := func(u *Upstream, n int) {
+		for i := 0; i < n; i++ {
+			lb.Report(u, Failure, 0)
+		}
+	}
+
+	fail(upstreams[0], 2)
+	lb.Report(upstreams[0], Success, 0)
+	fail(upstreams[0], 2)
+	if upstreams[0].Ejected() {
+		t.Fatal("upstream ejected although its failures were not consecutive")
+	}
+
+	fail(upstreams[0], 1)
+	if !upstreams[0].Ejected() {
+		t.Fatal("upstream not ejected after 3 consecutive failures")
+	}
+	for i := 0; i < 100; i++ {
+		u, err := lb.GetServer(Route{Capability: config.CapabilityQuery})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u == upstreams[0] {
+			t.Fatal("ejected upstream was selected")
+		}
+	}
+
+	// A second ejection would exceed 50% of the upstreams.
+	fail(upstreams[1], 3)
+	if upstreams[1].Ejected() {
+		t.Error("more than max_ejection_percent of the upstreams were ejected")
+	}
+
+	waitReturned(t, upstreams[0])
+
+	// The next ejection lasts twice as long.
+	before := time.Now()
+	fail(upstreams[0], 3)
+	ejections, until := upstreams[0].Ejection()
+	if ejections != 2 {
+		t.Errorf("got %d ejections, want 2", ejections)
+	}
+	if until.Before(before.Add(100*time.Millisecond)) || until.After(time.Now().Add(100*time.Millisecond)) {
+		t.Errorf("second ejection lasts %s, want 100ms", until.Sub(before))
+	}
+}
+
+// waitReturned waits for an ejected upstream to return.
+func waitReturned(t *testing.T, u *Upstream) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); u.Ejected(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("upstream did not return after its ejection time")
+		}
+	}
+}
+
+func TestOutlierEjectionPerPool(t *testing.T) {
+	servers := testServers(4)
+	servers[0].Capabilities = []config.Capability{config.CapabilityQuery, config.CapabilityMutation}
+	servers[1].OperationNames = []string{"Search"}
+	lb := New(servers, Options{Outlier: config.OutlierDetectionConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 1,
+		ErrorRatio:          1,
+		MinRequests:         100,
+		Interval:            time.Minute,
+		BaseEjectionTime:    time.Minute,
+		MaxEjectionTime:     time.Minute,
+		MaxEjectionPercent:  50,
+	}})
+	upstreams := lb.Upstreams()
+
+	// The only upstream serving mutations stays, although no other upstream is ejected.
+	lb.Report(upstreams[0], Failure, 0)
+	if upstreams[0].Ejected() {
+		t.Error("the only mutation upstream was ejected")
+	}
+	// Upstreams that serve every query and the one that only serves Search are in
+	// different pools, so half of each can be ejected.
+	lb.Report(upstreams[1], Failure, 0)
+	lb.Report(upstreams[2], Failure, 0)
+	if !upstreams[1].Ejected() || !upstreams[2].Ejected() {
+		t.Error("query upstreams were not ejected")
+	}
+	lb.Report(upstreams[3], Failure, 0)
+	if upstreams[3].Ejected() {
+		t.Error("more than max_ejection_percent of the query upstreams were ejected")
+	}
+}
+
+func TestOutlierErrorCodes(t *testing.T) {
+	lb := New(testServers(2), Options{Outlier: config.OutlierDetectionConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 2,
+		ErrorRatio:          1,
+		MinRequests:         100,
+		Interval:            time.Minute,
+		BaseEjectionTime:    time.Minute,
+		MaxEjectionTime:     time.Minute,
+		MaxEjectionPercent:  50,
+	}})
+	u := lb.Upstreams()[0]
+
+	lb.Report(u, Errored, 0)
+	lb.Report(u, Errored, 0)
+	if !u.Ejected() {
+		t.Error("upstream not ejected after errors with codes counted as failures")
+	}
+}
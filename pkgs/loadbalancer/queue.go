@@ -0,0 +1,187 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrSaturated is returned when every upstream that could serve a request has as many
+// requests in flight as it may and no room in its queue.
+var ErrSaturated = errors.New("upstreams saturated")
+
+// queue holds the requests waiting for an upstream at its concurrency limit. A request
+// that ends hands its slot to the request that waited longest instead of freeing it, so
+// that new requests cannot overtake the ones waiting.
+type queue struct {
+	mu      sync.Mutex
+	waiters []chan struct{}
+}
+
+// Queued returns the number of requests waiting for the upstream.
+func (u *Upstream) Queued() int {
+	u.waiting.mu.Lock()
+	defer u.waiting.mu.Unlock()
+	return len(u.waiting.waiters)
+}
+
+// ConcurrencyLimit returns the number of requests the upstream may have in flight: the
+// lower of MaxConcurrent and its adaptive limit, 0 when it has neither.
+func (u *Upstream) ConcurrencyLimit() int64 {
+	limit := int64(u.MaxConcurrent)
+	if u.limiter != nil {
+		if limit == 0 {
+			return u.limiter.limit()
+		}
+		limit = min(limit, u.limiter.limit())
+	}
+	return limit
+}
+
+// saturated reports whether the upstream has as many requests in flight as it may.
+func (u *Upstream) saturated() bool {
+	limit := u.ConcurrencyLimit()
+	return limit > 0 && u.outstanding.Load() >= limit
+}
+
+// tryAcquire takes a slot of the upstream for a request and reports whether one was
+// free.
+func (u *Upstream) tryAcquire() bool {
+	limit := u.ConcurrencyLimit()
+	if limit == 0 {
+		u.outstanding.Add(1)
+		return true
+	}
+	for {
+		n := u.outstanding.Load()
+		if n >= limit {
+			return false
+		}
+		if u.outstanding.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release gives back the slot of a request that ended. The slot is not handed on when
+// the limit was lowered below the requests in flight.
+func (lb *LoadBalancer) release(u *Upstream) {
+	limit := u.ConcurrencyLimit()
+	if limit == 0 {
+		u.outstanding.Add(-1)
+		return
+	}
+	q := &u.waiting
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 || u.outstanding.Load() > limit {
+		u.outstanding.Add(-1)
+		return
+	}
+	close(q.waiters[0])
+	q.waiters = q.waiters[1:]
+	lb.metrics.SetQueueDepth(u.URL, len(q.waiters))
+}
+
+// admit hands the slots an upstream gained when its limit was raised to the requests
+// waiting for it.
+func (lb *LoadBalancer) admit(u *Upstream) {
+	q := &u.waiting
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		return
+	}
+	for len(q.waiters) > 0 && u.tryAcquire() {
+		close(q.waiters[0])
+		q.waiters = q.waiters[1:]
+	}
+	lb.metrics.SetQueueDepth(u.URL, len(q.waiters))
+}
+
+// WaitServer selects an upstream for route like GetServer. When every upstream that could
+// serve the request is at its concurrency limit, the request waits in the shortest queue
+// of them until a slot is handed to it, the queue timeout passes or ctx ends.
+func (lb *LoadBalancer) WaitServer(ctx context.Context, route Route) (*Upstream, error) {
+	server, saturated, err := lb.getServer(route)
+	if !errors.Is(err, ErrSaturated) {
+		return server, err
+	}
+
+	u := cheapest(saturated, func(u *Upstream) float64 {
+		u.waiting.mu.Lock()
+		defer u.waiting.mu.Unlock()
+		return float64(len(u.waiting.waiters)) / float64(u.Weight)
+	})
+	admitted, ok := lb.enqueue(u)
+	if !ok {
+		lb.metrics.RecordQueueRejected(u.URL)
+		return nil, err
+	}
+
+	if admitted != nil {
+		start := time.Now()
+		timer := time.NewTimer(u.Queue.Timeout)
+		defer timer.Stop()
+		select {
+		case <-admitted:
+			lb.metrics.RecordQueueWait(u.URL, time.Since(start), false)
+		case <-timer.C:
+			lb.metrics.RecordQueueWait(u.URL, time.Since(start), true)
+			if lb.dequeue(u, admitted) {
+				return nil, fmt.Errorf("waiting for %s: timed out after %s: %w", u.URL, u.Queue.Timeout, ErrSaturated)
+			}
+		case <-ctx.Done():
+			lb.metrics.RecordQueueWait(u.URL, time.Since(start), false)
+			if lb.dequeue(u, admitted) {
+				return nil, fmt.Errorf("waiting for %s: %w", u.URL, ctx.Err())
+			}
+		}
+	}
+
+	// The circuit may have opened while the request waited.
+	ok, transition := u.breaker.acquire(time.Now())
+	lb.circuitTransition(u, transition)
+	if !ok {
+		lb.release(u)
+		return nil, fmt.Errorf("waiting for %s: %w", u.URL, ErrCircuitOpen)
+	}
+	return u, nil
+}
+
+// enqueue adds a request to the queue of an upstream and returns the channel that is
+// closed when it is handed a slot, or nil if a slot is free already. It reports false
+// when the queue is full.
+func (lb *LoadBalancer) enqueue(u *Upstream) (chan struct{}, bool) {
+	q := &u.waiting
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u.tryAcquire() {
+		return nil, true
+	}
+	if len(q.waiters) >= u.Queue.Size {
+		return nil, false
+	}
+	admitted := make(chan struct{})
+	q.waiters = append(q.waiters, admitted)
+	lb.metrics.SetQueueDepth(u.URL, len(q.waiters))
+	return admitted, true
+}
+
+// dequeue removes a request that gave up waiting from the queue of an upstream. It
+// reports false if the request was handed a slot in the meantime, which it then holds.
+func (lb *LoadBalancer) dequeue(u *Upstream, admitted chan struct{}) bool {
+	q := &u.waiting
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.Index(q.waiters, admitted)
+	if i < 0 {
+		return false
+	}
+	q.waiters = slices.Delete(q.waiters, i, i+1)
+	lb.metrics.SetQueueDepth(u.URL, len(q.waiters))
+	return true
+}
@@ -0,0 +1,89 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+var queryRoute = Route{Capability: config.CapabilityQuery}
+
+func TestSaturatedUpstreamFailsOver(t *testing.T) {
+	servers := testServers(2)
+	servers[0].MaxConcurrent = 1
+	lb := New(servers, Options{})
+	limited := lb.Upstreams()[0]
+
+	for i := 0; i < 20; i++ {
+		if _, err := lb.GetServer(queryRoute); err != nil {
+			t.Fatal(err)
+		}
+		if limited.Outstanding() > 1 {
+			t.Fatalf("upstream has %d requests in flight, limit is 1", limited.Outstanding())
+		}
+	}
+	if limited.Outstanding() != 1 {
+		t.Fatalf("limited upstream has %d requests in flight, want 1", limited.Outstanding())
+	}
+}
+
+func TestQueue(t *testing.T) {
+	servers := testServers(1)
+	servers[0].MaxConcurrent = 1
+	servers[0].Queue = config.UpstreamQueueConfig{Size: 1, Timeout: time.Minute}
+	lb := New(servers, Options{})
+
+	first, err := lb.WaitServer(context.Background(), queryRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waited := make(chan error)
+	go func() {
+		_, err := lb.WaitServer(context.Background(), queryRoute)
+		waited <- err
+	}()
+	for first.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := lb.WaitServer(context.Background(), queryRoute); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("request over a full queue: error = %v, want ErrSaturated", err)
+	}
+	if _, err := lb.GetServer(queryRoute); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("request overtaking the queue: error = %v, want ErrSaturated", err)
+	}
+
+	lb.Report(first, Success, time.Millisecond)
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+	if n := first.Outstanding(); n != 1 {
+		t.Fatalf("outstanding = %d, want the slot handed to the waiting request", n)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	servers := testServers(1)
+	servers[0].MaxConcurrent = 1
+	servers[0].Queue = config.UpstreamQueueConfig{Size: 1, Timeout: 10 * time.Millisecond}
+	lb := New(servers, Options{})
+
+	u, err := lb.WaitServer(context.Background(), queryRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.WaitServer(context.Background(), queryRoute); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("error = %v, want ErrSaturated", err)
+	}
+	if u.Queued() != 0 {
+		t.Fatalf("%d requests left in the queue", u.Queued())
+	}
+
+	lb.Report(u, Success, time.Millisecond)
+	if u.Outstanding() != 0 {
+		t.Fatalf("outstanding = %d, want 0", u.Outstanding())
+	}
+}
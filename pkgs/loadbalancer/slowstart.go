@@ -0,0 +1,48 @@
+package loadbalancer
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// slowStart ramps up the weight of an upstream that returns to service, so that it warms
+// its caches with a fraction of the traffic before it gets its share.
+type slowStart struct {
+	cfg config.SlowStartConfig
+	// since is when the upstream returned in Unix nanoseconds, 0 when it is not warming
+	// up.
+	since atomic.Int64
+}
+
+// begin starts the ramp of an upstream that returns to service at now.
+func (s *slowStart) begin(now time.Time) {
+	if s.cfg.Window > 0 {
+		s.since.Store(now.UnixNano())
+	}
+}
+
+// factor returns the share of its weight the upstream gets at now: MinWeightPercent at
+// first, growing to 1 at the end of the window as the elapsed share of the window to the
+// power of 1/Aggression.
+func (s *slowStart) factor(now time.Time) float64 {
+	since := s.since.Load()
+	if since == 0 {
+		return 1
+	}
+	elapsed := now.Sub(time.Unix(0, since))
+	if elapsed >= s.cfg.Window {
+		s.since.CompareAndSwap(since, 0)
+		return 1
+	}
+	ramp := math.Pow(float64(max(elapsed, 0))/float64(s.cfg.Window), 1/s.cfg.Aggression)
+	return max(ramp, s.cfg.MinWeightPercent/100)
+}
+
+// EffectiveWeight returns the weight of the upstream that strategies select it by: its
+// configured weight, reduced while it warms up after returning to service.
+func (u *Upstream) EffectiveWeight() float64 {
+	return float64(u.Weight) * u.slowStart.factor(time.Now())
+}
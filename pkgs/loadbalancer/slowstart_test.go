@@ -0,0 +1,49 @@
+package loadbalancer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name       string
+		aggression float64
+		elapsed    time.Duration
+		want       float64
+	}{
+		{"minimum at first", 1, 0, 0.1},
+		{"linear", 1, 30 * time.Second, 0.5},
+		{"aggressive", 2, 15 * time.Second, 0.5},
+		{"done", 1, time.Minute, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := slowStart{cfg: config.SlowStartConfig{Window: time.Minute, Aggression: tt.aggression, MinWeightPercent: 10}}
+			s.begin(start)
+			if got := s.factor(start.Add(tt.elapsed)); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("factor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlowStartAfterRecovery(t *testing.T) {
+	lb := New(weightedServers(1, 1), Options{SlowStart: config.SlowStartConfig{
+		Window:           time.Hour,
+		Aggression:       1,
+		MinWeightPercent: 10,
+	}})
+	recovered := lb.Upstreams()[0]
+	if recovered.EffectiveWeight() != 1 {
+		t.Fatalf("effective weight at startup = %v, want 1", recovered.EffectiveWeight())
+	}
+
+	recovered.SetHealthy(false)
+	recovered.SetHealthy(true)
+	assertShares(t, distribution(t, lb, 5000, noLatency), []float64{1.0 / 11, 10.0 / 11}, 0.02)
+}
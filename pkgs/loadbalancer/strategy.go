@@ -0,0 +1,174 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// Strategy selects the upstream a request is sent to.
+type Strategy interface {
+	// Select returns one of upstreams, which is never empty, for a request to route.
+	Select(route Route, upstreams []*Upstream) *Upstream
+}
+
+// NewStrategy returns the strategy with the given name.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case config.StrategyRandom, "":
+		return weightedRandom{}, nil
+	case config.StrategyRoundRobin:
+		return &roundRobin{current: make(map[*Upstream]float64)}, nil
+	case config.StrategyLeastOutstanding:
+		return leastOutstanding{}, nil
+	case config.StrategyPowerOfTwo:
+		return powerOfTwo{}, nil
+	case config.StrategyPeakEWMA:
+		return peakEWMA{}, nil
+	case config.StrategyConsistentHash:
+		return newConsistentHash(weightedRandom{}), nil
+	default:
+		return nil, fmt.Errorf("unsupported load balancing strategy: %s", name)
+	}
+}
+
+// weightedRandom selects upstreams at random in proportion to their weight.
+type weightedRandom struct{}
+
+func (weightedRandom) Select(_ Route, upstreams []*Upstream) *Upstream {
+	return upstreams[pickWeighted(upstreams)]
+}
+
+// roundRobin is the smooth weighted round-robin of nginx: every upstream is selected in
+// proportion to its weight, and selections of the same upstream are spread out rather
+// than made in a row.
+type roundRobin struct {
+	mu      sync.Mutex
+	current map[*Upstream]float64
+}
+
+func (rr *roundRobin) Select(_ Route, upstreams []*Upstream) *Upstream {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	var best *Upstream
+	total := 0.0
+	for _, u := range upstreams {
+		weight := u.EffectiveWeight()
+		rr.current[u] += weight
+		total += weight
+		if best == nil || rr.current[u] > rr.current[best] {
+			best = u
+		}
+	}
+	rr.current[best] -= total
+	return best
+}
+
+// load returns the outstanding requests of an upstream per unit of effective weight.
+func load(u *Upstream) float64 {
+	return float64(u.Outstanding()) / u.EffectiveWeight()
+}
+
+// leastOutstanding selects the upstream with the fewest outstanding requests for its
+// weight. Ties are broken at random in proportion to weight.
+type leastOutstanding struct{}
+
+func (leastOutstanding) Select(_ Route, upstreams []*Upstream) *Upstream {
+	return cheapest(upstreams, load)
+}
+
+// cheapest returns the upstream with the lowest cost. Ties are broken at random in
+// proportion to weight.
+func cheapest(upstreams []*Upstream, cost func(*Upstream) float64) *Upstream {
+	var best *Upstream
+	bestCost, tiedWeight := math.Inf(1), 0.0
+	for _, u := range upstreams {
+		c := cost(u)
+		switch {
+		case c < bestCost:
+			best, bestCost, tiedWeight = u, c, u.EffectiveWeight()
+		case c == bestCost:
+			// Weighted reservoir sampling keeps each tied upstream with a probability
+			// proportional to its weight.
+			weight := u.EffectiveWeight()
+			tiedWeight += weight
+			if rand.Float64()*tiedWeight < weight {
+				best = u
+			}
+		}
+	}
+	return best
+}
+
+// powerOfTwo picks two upstreams at random in proportion to their weight and selects the
+// one with fewer outstanding requests for its weight, the first one on a tie.
+type powerOfTwo struct{}
+
+func (powerOfTwo) Select(_ Route, upstreams []*Upstream) *Upstream {
+	if len(upstreams) == 1 {
+		return upstreams[0]
+	}
+	i := pickWeighted(upstreams)
+	rest := make([]*Upstream, 0, len(upstreams)-1)
+	rest = append(append(rest, upstreams[:i]...), upstreams[i+1:]...)
+	a, b := upstreams[i], rest[pickWeighted(rest)]
+	if load(b) < load(a) {
+		return b
+	}
+	return a
+}
+
+// peakEWMA selects the upstream with the lowest expected latency: its peak EWMA latency
+// multiplied by its outstanding requests, counting the one being selected for, for its
+// weight. Upstreams without latency yet count as taking a nanosecond, so that they are
+// preferred until they have one.
+type peakEWMA struct{}
+
+func (peakEWMA) Select(_ Route, upstreams []*Upstream) *Upstream {
+	now := time.Now()
+	return cheapest(upstreams, func(u *Upstream) float64 {
+		return math.Max(u.ewma.value(now), 1) * float64(u.Outstanding()+1) / u.EffectiveWeight()
+	})
+}
+
+// ewma is a moving average of the latency of an upstream that jumps to every latency
+// above it and decays exponentially towards lower ones, so that an upstream that slows
+// down is avoided at once and only trusted again gradually. decay is the time over which
+// a latency is forgotten.
+type ewma struct {
+	decay time.Duration
+
+	mu      sync.Mutex
+	latency float64
+	last    time.Time
+}
+
+func (e *ewma) observe(now time.Time, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sample := float64(latency)
+	if sample > e.latency || e.last.IsZero() {
+		e.latency = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(e.last)) / float64(e.decay))
+		e.latency = e.latency*w + sample*(1-w)
+	}
+	e.last = now
+}
+
+// value returns the average latency in nanoseconds, decayed towards zero for the time
+// no latency was observed, or 0 if none was.
+func (e *ewma) value(now time.Time) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last.IsZero() {
+		return 0
+	}
+	return e.latency * math.Exp(-float64(now.Sub(e.last))/float64(e.decay))
+}
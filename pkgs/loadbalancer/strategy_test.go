@@ -0,0 +1,176 @@
+package loadbalancer
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// weightedServers returns query upstreams with the given weights.
+func weightedServers(weights ...int) []config.UpstreamServer {
+	servers := testServers(len(weights))
+	for i, w := range weights {
+		servers[i].Weight = w
+	}
+	return servers
+}
+
+// distribution selects n upstreams, reporting each request right away, and returns the
+// share of the selections every upstream got.
+func distribution(t *testing.T, lb *LoadBalancer, n int, latency func(*Upstream) time.Duration) []float64 {
+	t.Helper()
+	counts := make(map[*Upstream]int)
+	for i := 0; i < n; i++ {
+		u, err := lb.GetServer(Route{Capability: config.CapabilityQuery})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[u]++
+		lb.Report(u, Success, latency(u))
+	}
+	shares := make([]float64, 0, len(counts))
+	for _, u := range lb.Upstreams() {
+		shares = append(shares, float64(counts[u])/float64(n))
+	}
+	return shares
+}
+
+func noLatency(*Upstream) time.Duration { return time.Millisecond }
+
+func assertShares(t *testing.T, got, want []float64, tolerance float64) {
+	t.Helper()
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > tolerance {
+			t.Fatalf("shares = %.3f, want %.3f ± %.2f", got, want, tolerance)
+		}
+	}
+}
+
+func TestStrategyWeightedDistribution(t *testing.T) {
+	want := []float64{1.0 / 6, 2.0 / 6, 3.0 / 6}
+	tests := []struct {
+		strategy  string
+		tolerance float64
+	}{
+		{config.StrategyRandom, 0.03},
+		{config.StrategyRoundRobin, 0.001},
+		{config.StrategyPowerOfTwo, 0.03},
+		{config.StrategyLeastOutstanding, 0.03},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			strategy, err := NewStrategy(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lb := New(weightedServers(1, 2, 3), Options{Strategy: strategy})
+			if tt.strategy == config.StrategyLeastOutstanding {
+				// Without outstanding requests every upstream is tied, hold some so that
+				// the weights matter.
+				assertShares(t, outstandingShares(t, lb, 600), want, tt.tolerance)
+				return
+			}
+			assertShares(t, distribution(t, lb, 6000, noLatency), want, tt.tolerance)
+		})
+	}
+}
+
+// outstandingShares selects n upstreams without reporting any request and returns the
+// share of the selections every upstream got.
+func outstandingShares(t *testing.T, lb *LoadBalancer, n int) []float64 {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := lb.GetServer(Route{Capability: config.CapabilityQuery}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var shares []float64
+	for _, u := range lb.Upstreams() {
+		shares = append(shares, float64(u.Outstanding())/float64(n))
+	}
+	return shares
+}
+
+func TestRoundRobinIsSmooth(t *testing.T) {
+	strategy, _ := NewStrategy(config.StrategyRoundRobin)
+	lb := New(weightedServers(5, 1, 1), Options{Strategy: strategy})
+	upstreams := lb.Upstreams()
+
+	var sequence strings.Builder
+	for i := 0; i < 14; i++ {
+		u, err := lb.GetServer(Route{Capability: config.CapabilityQuery})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range upstreams {
+			if u == upstreams[j] {
+				sequence.WriteByte(byte('a' + j))
+			}
+		}
+	}
+	if got, want := sequence.String(), "aabacaaaabacaa"; got != want {
+		t.Fatalf("sequence = %s, want %s", got, want)
+	}
+}
+
+func TestStrategyAvoidsLoadedUpstream(t *testing.T) {
+	for _, name := range []string{config.StrategyLeastOutstanding, config.StrategyPowerOfTwo, config.StrategyPeakEWMA} {
+		t.Run(name, func(t *testing.T) {
+			strategy, _ := NewStrategy(name)
+			lb := New(weightedServers(1, 1), Options{Strategy: strategy})
+			busy := lb.Upstreams()[0]
+			busy.outstanding.Add(10)
+
+			shares := distribution(t, lb, 1000, noLatency)
+			// Power of two choices only compares the busy upstream with itself when both
+			// picks are the same, which they cannot be with two upstreams.
+			if shares[0] > 0.01 {
+				t.Fatalf("busy upstream got %.3f of the requests", shares[0])
+			}
+		})
+	}
+}
+
+func TestPeakEWMAPrefersFastUpstream(t *testing.T) {
+	strategy, _ := NewStrategy(config.StrategyPeakEWMA)
+	lb := New(weightedServers(1, 1, 1), Options{Strategy: strategy})
+	slow := lb.Upstreams()[0]
+
+	shares := distribution(t, lb, 3000, func(u *Upstream) time.Duration {
+		if u == slow {
+			return 100 * time.Millisecond
+		}
+		return time.Millisecond
+	})
+	if shares[0] > 0.01 {
+		t.Fatalf("slow upstream got %.3f of the requests", shares[0])
+	}
+	assertShares(t, shares[1:], []float64{0.5, 0.5}, 0.1)
+}
+
+func TestStrategyPerOperationType(t *testing.T) {
+	roundRobin, _ := NewStrategy(config.StrategyRoundRobin)
+	servers := testServers(2)
+	for i := range servers {
+		servers[i].Capabilities = []config.Capability{config.CapabilityQuery, config.CapabilityMutation}
+	}
+	lb := New(servers, Options{
+		Strategies: map[config.Capability]Strategy{config.CapabilityMutation: roundRobin},
+	})
+
+	var previous *Upstream
+	for i := 0; i < 10; i++ {
+		u, err := lb.GetServer(Route{Capability: config.CapabilityMutation})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u == previous {
+			t.Fatal("round robin selected the same upstream twice in a row")
+		}
+		previous = u
+		lb.Report(u, Success, time.Millisecond)
+	}
+}
@@ -0,0 +1,281 @@
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+var (
+	filesMu sync.Mutex
+	files   []*File
+)
+
+// Reopen closes and reopens every log file, so that logs are written to a new file after
+// an external tool such as logrotate moved the current one away.
+func Reopen() error {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	var errs []error
+	for _, f := range files {
+		if err := f.Reopen(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// File is a log file that is rotated once it grows beyond a size or an interval elapses.
+// Rotated files are renamed with the time of the rotation inserted before their extension,
+// optionally compressed, and removed once there are more than MaxBackups of them or they
+// are older than MaxAge.
+type File struct {
+	path     string
+	rotation config.LogRotationConfig
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	rotateAt time.Time
+	cleanup  sync.Mutex
+	cleanups sync.WaitGroup
+}
+
+// OpenFile opens the log file at path for appending. The file is reopened by Reopen.
+func OpenFile(path string, rotation config.LogRotationConfig) (*File, error) {
+	f := &File{path: path, rotation: rotation}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	filesMu.Lock()
+	files = append(files, f)
+	filesMu.Unlock()
+
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	if f.rotation.Interval > 0 {
+		f.rotateAt = time.Now().Truncate(f.rotation.Interval).Add(f.rotation.Interval)
+	}
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// A file that cannot be rotated is written to regardless, and rotated on a later
+	// write.
+	var rotateErr error
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			rotateErr = fmt.Errorf("rotating log file: %w", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, errors.Join(rotateErr, err)
+}
+
+// Reopen closes the file and opens the file at its path again.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.file.Close()
+	if err := f.open(); err != nil {
+		return fmt.Errorf("reopening log file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file once the rotated files have been cleaned up. A closed file is no
+// longer reopened by Reopen.
+func (f *File) Close() error {
+	filesMu.Lock()
+	for i, other := range files {
+		if other == f {
+			files = append(files[:i], files[i+1:]...)
+			break
+		}
+	}
+	filesMu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cleanups.Wait()
+	return f.file.Close()
+}
+
+func (f *File) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if max := int64(f.rotation.MaxSize) * 1024 * 1024; max > 0 && f.size+int64(n) > max {
+		return true
+	}
+	return !f.rotateAt.IsZero() && !time.Now().Before(f.rotateAt)
+}
+
+// rotate moves the file away and opens a new one at its path. The old file is only closed
+// once the new one is open, so that a failed rotation leaves a file to write to.
+func (f *File) rotate() error {
+	// Rotations within the same millisecond get distinct names.
+	t := time.Now()
+	for {
+		if _, err := os.Stat(f.backupName(t)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		t = t.Add(time.Millisecond)
+	}
+	if err := os.Rename(f.path, f.backupName(t)); err != nil {
+		return err
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+
+	f.cleanups.Add(1)
+	go func() {
+		defer f.cleanups.Done()
+		if err := f.cleanupBackups(); err != nil {
+			// There is no log to report to but the one that is being cleaned up.
+			fmt.Fprintf(os.Stderr, "cleaning up rotated log files of %s: %v\n", f.path, err)
+		}
+	}()
+	return nil
+}
+
+func (f *File) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// backups returns the rotated files, oldest first.
+func (f *File) backups() ([]string, error) {
+	dir, base := filepath.Split(f.path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, dir+name)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// cleanupBackups compresses the rotated files and removes the ones that are no longer
+// retained. A file that cannot be compressed is kept as it is.
+func (f *File) cleanupBackups() error {
+	f.cleanup.Lock()
+	defer f.cleanup.Unlock()
+
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+
+	var kept []string
+	for _, b := range backups {
+		info, err := os.Stat(b)
+		if err != nil {
+			continue
+		}
+		if f.rotation.MaxAge > 0 && time.Since(info.ModTime()) > f.rotation.MaxAge {
+			os.Remove(b)
+			continue
+		}
+		kept = append(kept, b)
+	}
+
+	if f.rotation.MaxBackups > 0 && len(kept) > f.rotation.MaxBackups {
+		for _, b := range kept[:len(kept)-f.rotation.MaxBackups] {
+			os.Remove(b)
+		}
+		kept = kept[len(kept)-f.rotation.MaxBackups:]
+	}
+
+	var errs []error
+	if f.rotation.Compress {
+		for _, b := range kept {
+			if !strings.HasSuffix(b, ".gz") {
+				if err := compress(b); err != nil {
+					errs = append(errs, fmt.Errorf("compressing %s: %w", b, err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
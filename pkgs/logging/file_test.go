@@ -0,0 +1,161 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.log")
+
+	f, err := OpenFile(path, config.LogRotationConfig{MaxSize: 1, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	chunk := bytes.Repeat([]byte("x"), 600*1024)
+	for i := 0; i < 4; i++ {
+		if _, err := f.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.cleanupBackups(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(chunk)) {
+		t.Errorf("current file has %d bytes, want %d", info.Size(), len(chunk))
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2: %v", len(backups), backups)
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".log.gz") {
+			t.Errorf("backup %s is not compressed", b)
+		}
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.log")
+
+	f, err := OpenFile(path, config.LogRotationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("before\n"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after\n"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "after\n" {
+		t.Errorf("got %q, want %q", data, "after\n")
+	}
+}
+
+func TestFileRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	f, err := OpenFile(path, config.LogRotationConfig{MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	chunk := bytes.Repeat([]byte("x"), 600*1024)
+	f.Write(chunk)
+	// The file cannot be renamed once it is gone.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := f.Write(chunk); err == nil || n != len(chunk) {
+			t.Fatalf("write %d: wrote %d bytes, error %v", i, n, err)
+		}
+	}
+}
+
+func TestFileBackupsWithPatternCharacters(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy[1].log")
+	f, err := OpenFile(path, config.LogRotationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	backup := f.backupName(time.Now())
+	for _, name := range []string{backup, filepath.Join(dir, "proxy1-2024-01-01T00-00-00.000.log")} {
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0] != backup {
+		t.Errorf("got backups %v, want %v", backups, []string{backup})
+	}
+}
+
+func TestFileCompressFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	f, err := OpenFile(path, config.LogRotationConfig{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	backup := f.backupName(time.Now())
+	if err := os.WriteFile(backup, []byte("rotated\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The compressed file cannot be created where a directory is.
+	if err := os.Mkdir(backup+".gz", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.cleanupBackups(); err == nil {
+		t.Error("expected an error for a backup that cannot be compressed")
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("uncompressed backup removed: %v", err)
+	}
+}
+
+func TestNewLoggerFailsOnUnopenableSink(t *testing.T) {
+	cfg := config.LogConfig{Sinks: []config.LogSinkConfig{
+		{Type: "stdout"},
+		{Type: "file", Path: filepath.Join(t.TempDir(), "missing", "proxy.log")},
+	}}
+	if _, err := NewLogger(cfg, nil); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -1,52 +1,143 @@
 package logging
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
 )
 
-func NewLogger(level, format, output string) *slog.Logger {
-	var logLevel slog.Level
+// NewLogger creates the application logger writing to every configured sink. Records are
+// redacted by redactor, which may be nil. It fails when a sink cannot be opened.
+func NewLogger(cfg config.LogConfig, redactor *Redactor) (*slog.Logger, error) {
+	handlers := make([]slog.Handler, 0, len(cfg.Sinks))
+	for i, sink := range cfg.Sinks {
+		h, err := newSinkHandler(sink)
+		if err != nil {
+			return nil, fmt.Errorf("opening log sink #%d (%s): %w", i+1, sink.Type, err)
+		}
+		handlers = append(handlers, h)
+	}
+
+	var handler slog.Handler
+	if len(handlers) == 1 {
+		handler = handlers[0]
+	} else {
+		handler = multiHandler(handlers)
+	}
+
+	return slog.New(redactor.Handler(handler)), nil
+}
+
+func newSinkHandler(sink config.LogSinkConfig) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		Level: parseLevel(sink.Level),
+	}
+
+	switch sink.Type {
+	case "syslog":
+		w, err := dialSyslog(sink.Address, sink.Tag)
+		if err != nil {
+			return nil, err
+		}
+		// The syslog daemon adds its own timestamp.
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+		return &syslogHandler{mu: &sync.Mutex{}, w: w, format: newFormatHandler(w, sink.Format, opts)}, nil
+	case "file":
+		w, err := OpenFile(sink.Path, sink.Rotation)
+		if err != nil {
+			return nil, err
+		}
+		return newFormatHandler(w, sink.Format, opts), nil
+	default:
+		w, err := OpenOutput(sink.Type, config.LogRotationConfig{})
+		if err != nil {
+			return nil, err
+		}
+		return newFormatHandler(w, sink.Format, opts), nil
+	}
+}
+
+func newFormatHandler(w io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if strings.ToLower(format) == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func parseLevel(level string) slog.Level {
 	switch strings.ToLower(level) {
 	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
+		return slog.LevelDebug
 	case "warn":
-		logLevel = slog.LevelWarn
+		return slog.LevelWarn
 	case "error":
-		logLevel = slog.LevelError
+		return slog.LevelError
 	default:
-		logLevel = slog.LevelInfo
+		return slog.LevelInfo
 	}
+}
 
-	var writer io.Writer
+// OpenOutput returns the writer for a log output: stdout, stderr or the path of a file
+// that logs are appended to and that is rotated as configured.
+func OpenOutput(output string, rotation config.LogRotationConfig) (io.Writer, error) {
 	switch strings.ToLower(output) {
 	case "stdout":
-		writer = os.Stdout
+		return os.Stdout, nil
 	case "stderr":
-		writer = os.Stderr
+		return os.Stderr, nil
 	default:
-		file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err != nil {
-			writer = os.Stdout
-		} else {
-			writer = file
+		return OpenFile(output, rotation)
+	}
+}
+
+// multiHandler passes records to every handler that is enabled for their level.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
 		}
 	}
+	return false
+}
 
-	opts := &slog.HandlerOptions{
-		Level: logLevel,
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	var handler slog.Handler
-	if strings.ToLower(format) == "json" {
-		handler = slog.NewJSONHandler(writer, opts)
-	} else {
-		handler = slog.NewTextHandler(writer, opts)
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithAttrs(attrs)
 	}
+	return handlers
+}
 
-	return slog.New(handler)
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
 }
@@ -0,0 +1,433 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/lexer"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// Redacted replaces every value removed from the logs.
+const Redacted = "[REDACTED]"
+
+// Redactor removes sensitive values from log records. It recognises the attributes the
+// proxy logs requests with:
+//
+//   - http.Header values and attributes in a "headers" group lose the denied headers
+//   - "variables" maps lose the values matched by the variable paths
+//   - "query" strings lose the literals passed to arguments matching the argument patterns
+//   - "path" request URIs get the same treatment for the query and variables of GET requests
+//   - "error" strings and errors lose the quoted values, which may be query literals
+//
+// A nil Redactor leaves records unchanged.
+type Redactor struct {
+	headers   map[string]bool
+	variables [][]pathSegment
+	arguments []string
+}
+
+func NewRedactor(cfg config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{headers: make(map[string]bool, len(cfg.Headers))}
+	for _, h := range cfg.Headers {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, v := range cfg.Variables {
+		segments, err := parsePath(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid variable path %q: %w", v, err)
+		}
+		r.variables = append(r.variables, segments)
+	}
+	for _, a := range cfg.Arguments {
+		if _, err := path.Match(a, ""); err != nil {
+			return nil, fmt.Errorf("invalid argument pattern %q: %w", a, err)
+		}
+		r.arguments = append(r.arguments, strings.ToLower(a))
+	}
+	return r, nil
+}
+
+// Handler wraps h so that every record is redacted before it is handled.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	if r == nil {
+		return h
+	}
+	return &redactHandler{next: h, redactor: r}
+}
+
+type redactHandler struct {
+	next     slog.Handler
+	redactor *Redactor
+	// headers is set within a "headers" group.
+	headers bool
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactor.attr(a, h.headers))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactor.attr(a, h.headers)
+	}
+	return &redactHandler{next: h.next.WithAttrs(redacted), redactor: h.redactor, headers: h.headers}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &redactHandler{
+		next:     h.next.WithGroup(name),
+		redactor: h.redactor,
+		headers:  h.headers || strings.EqualFold(name, "headers"),
+	}
+}
+
+// attr redacts a. headers is set when a is within a "headers" group.
+func (r *Redactor) attr(a slog.Attr, headers bool) slog.Attr {
+	v := a.Value.Resolve()
+
+	if headers && r.headers[http.CanonicalHeaderKey(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = r.attr(ga, headers || strings.EqualFold(a.Key, "headers"))
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindString:
+		switch a.Key {
+		case "query":
+			return slog.String(a.Key, r.Query(v.String()))
+		case "path":
+			return slog.String(a.Key, r.RequestURI(v.String()))
+		case "error":
+			return slog.String(a.Key, r.Error(v.String()))
+		}
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case http.Header:
+			return slog.Any(a.Key, r.Headers(x))
+		case map[string]interface{}:
+			if a.Key == "variables" {
+				return slog.Any(a.Key, r.Variables(x))
+			}
+		case error:
+			if a.Key == "error" {
+				return slog.String(a.Key, r.Error(x.Error()))
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// Headers returns a copy of h with the values of the denied headers redacted.
+func (r *Redactor) Headers(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vv := range h {
+		if r.headers[http.CanonicalHeaderKey(k)] {
+			redacted := make([]string, len(vv))
+			for i := range vv {
+				redacted[i] = Redacted
+			}
+			out[k] = redacted
+			continue
+		}
+		out[k] = vv
+	}
+	return out
+}
+
+// Variables returns a copy of the variables of a request with the values matched by the
+// variable paths redacted.
+func (r *Redactor) Variables(vars map[string]interface{}) map[string]interface{} {
+	var v interface{} = vars
+	for _, segments := range r.variables {
+		v = redactPath(v, segments)
+	}
+	return v.(map[string]interface{})
+}
+
+// Query returns the query with the literals passed to matching arguments, or to input
+// object fields, and the default values of matching variables redacted. Queries that
+// cannot be tokenized are redacted entirely.
+func (r *Redactor) Query(query string) string {
+	if len(r.arguments) == 0 {
+		return query
+	}
+
+	src := []rune(query)
+	lex := lexer.New(&ast.Source{Input: query})
+
+	var (
+		out     strings.Builder
+		last    int
+		prev    []lexer.Token // the last two significant tokens
+		depth   int           // nesting of the value being redacted, 0 when not redacting
+		pending bool          // an argument matched and its value starts with the next token
+		name    string        // the name of the last variable
+	)
+	for {
+		tok, err := lex.ReadToken()
+		if err != nil {
+			return Redacted
+		}
+		if tok.Kind == lexer.EOF {
+			break
+		}
+		if tok.Kind == lexer.Comment {
+			continue
+		}
+
+		if pending {
+			pending = false
+			if tok.Kind == lexer.BracketL || tok.Kind == lexer.BraceL {
+				depth = 1
+			} else if isLiteral(tok.Kind) {
+				out.WriteString(string(src[last:tok.Pos.Start]))
+				out.WriteString(strconv.Quote(Redacted))
+				last = tok.Pos.End
+			}
+		} else if depth > 0 {
+			switch {
+			case tok.Kind == lexer.BracketL || tok.Kind == lexer.BraceL:
+				depth++
+			case tok.Kind == lexer.BracketR || tok.Kind == lexer.BraceR:
+				depth--
+			case isLiteral(tok.Kind):
+				out.WriteString(string(src[last:tok.Pos.Start]))
+				out.WriteString(strconv.Quote(Redacted))
+				last = tok.Pos.End
+			}
+		} else if tok.Kind == lexer.Colon && len(prev) == 2 && prev[1].Kind == lexer.Name &&
+			prev[0].Kind != lexer.Dollar && r.sensitiveArgument(prev[1].Value) {
+			pending = true
+		} else if tok.Kind == lexer.Equals {
+			// Default values only follow the type of a variable definition.
+			pending = r.sensitiveArgument(name)
+		}
+		if tok.Kind == lexer.Name && len(prev) > 0 && prev[len(prev)-1].Kind == lexer.Dollar {
+			name = tok.Value
+		}
+
+		prev = append(prev, tok)
+		if len(prev) > 2 {
+			prev = prev[1:]
+		}
+	}
+	out.WriteString(string(src[last:]))
+	return out.String()
+}
+
+// Error returns the error message msg with every double quoted value redacted. Errors
+// parsing or validating a request, and those of the upstream, quote the literals of the
+// query and the values of variables. Messages are kept as they are when no argument or
+// variable is redacted.
+func (r *Redactor) Error(msg string) string {
+	if len(r.arguments) == 0 && len(r.variables) == 0 {
+		return msg
+	}
+
+	var out strings.Builder
+	for {
+		start := strings.IndexByte(msg, '"')
+		if start < 0 {
+			break
+		}
+		end := start + 1
+		for end < len(msg) && msg[end] != '"' {
+			if msg[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		out.WriteString(msg[:start])
+		out.WriteString(strconv.Quote(Redacted))
+		// A value that is not terminated takes the rest of the message.
+		msg = msg[min(end+1, len(msg)):]
+	}
+	out.WriteString(msg)
+	return out.String()
+}
+
+// RequestURI redacts the query and variables of GraphQL requests sent with GET.
+func (r *Redactor) RequestURI(uri string) string {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil || u.RawQuery == "" {
+		return uri
+	}
+
+	params := u.Query()
+	if !params.Has("query") && !params.Has("variables") {
+		return uri
+	}
+	if params.Has("query") {
+		params.Set("query", r.Query(params.Get("query")))
+	}
+	if params.Has("variables") {
+		var vars map[string]interface{}
+		if err := json.Unmarshal([]byte(params.Get("variables")), &vars); err != nil {
+			params.Set("variables", Redacted)
+		} else if encoded, err := json.Marshal(r.Variables(vars)); err == nil {
+			params.Set("variables", string(encoded))
+		}
+	}
+	u.RawQuery = params.Encode()
+	return u.RequestURI()
+}
+
+func (r *Redactor) sensitiveArgument(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range r.arguments {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isLiteral(kind lexer.Type) bool {
+	return kind == lexer.String || kind == lexer.BlockString || kind == lexer.Int || kind == lexer.Float
+}
+
+// pathSegment is one step of a variable path such as $.input.password, $..token or
+// $.users[*].ssn.
+type pathSegment struct {
+	key       string
+	index     int // -1 unless the segment selects an array element
+	wildcard  bool
+	recursive bool // matches at any depth below the current value
+}
+
+func (s pathSegment) matchesKey(key string) bool {
+	return s.wildcard || (s.index < 0 && s.key == key)
+}
+
+func (s pathSegment) matchesIndex(i int) bool {
+	return s.wildcard || s.index == i
+}
+
+func parsePath(p string) ([]pathSegment, error) {
+	if !strings.HasPrefix(p, "$") {
+		return nil, fmt.Errorf("path must start with $")
+	}
+	p = p[1:]
+
+	var segments []pathSegment
+	for p != "" {
+		seg := pathSegment{index: -1}
+		switch {
+		case strings.HasPrefix(p, ".."):
+			seg.recursive = true
+			p = p[2:]
+		case strings.HasPrefix(p, "."):
+			p = p[1:]
+		case strings.HasPrefix(p, "["):
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			inner := p[1:end]
+			p = p[end+1:]
+			switch {
+			case inner == "*":
+				seg.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg.key = inner[1 : len(inner)-1]
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("invalid index %q", inner)
+				}
+				seg.index = i
+			}
+			segments = append(segments, seg)
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected %q", p)
+		}
+
+		end := strings.IndexAny(p, ".[")
+		if end < 0 {
+			end = len(p)
+		}
+		name := p[:end]
+		p = p[end:]
+		if name == "" {
+			return nil, fmt.Errorf("empty name")
+		}
+		if name == "*" {
+			seg.wildcard = true
+		} else {
+			seg.key = name
+		}
+		segments = append(segments, seg)
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("path selects every variable")
+	}
+	return segments, nil
+}
+
+// redactPath returns a copy of v with the values selected by the segments redacted.
+func redactPath(v interface{}, segments []pathSegment) interface{} {
+	if len(segments) == 0 {
+		return Redacted
+	}
+	seg := segments[0]
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			if seg.matchesKey(k) {
+				child = redactPath(child, segments[1:])
+			}
+			if seg.recursive {
+				child = redactPath(child, segments)
+			}
+			out[k] = child
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			if seg.matchesIndex(i) {
+				child = redactPath(child, segments[1:])
+			}
+			if seg.recursive {
+				child = redactPath(child, segments)
+			}
+			out[i] = child
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,179 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func newTestRedactor(t *testing.T) *Redactor {
+	t.Helper()
+	r, err := NewRedactor(config.RedactionConfig{
+		Headers:   []string{"authorization", "Cookie"},
+		Variables: []string{"$.input.password", "$..token", "$.users[*].ssn"},
+		Arguments: []string{"password", "*secret*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRedactQuery(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		query string
+		want  string
+	}{
+		{
+			desc:  "Matching arguments are redacted",
+			query: `mutation { login(user: "bob", password: "hunter2") { token } }`,
+			want:  `mutation { login(user: "bob", password: "[REDACTED]") { token } }`,
+		},
+		{
+			desc:  "Patterns are case insensitive",
+			query: `{ a(clientSecret: 12345) { id } }`,
+			want:  `{ a(clientSecret: "[REDACTED]") { id } }`,
+		},
+		{
+			desc:  "Input object fields and lists are redacted",
+			query: `mutation { signup(input: {name: "x", password: "p"}, secrets: ["a", "b"]) { id } }`,
+			want:  `mutation { signup(input: {name: "x", password: "[REDACTED]"}, secrets: ["[REDACTED]", "[REDACTED]"]) { id } }`,
+		},
+		{
+			desc:  "Variables and aliases are kept",
+			query: `query ($password: String) { password: login(password: $password) { id } }`,
+			want:  `query ($password: String) { password: login(password: $password) { id } }`,
+		},
+		{
+			desc:  "Default values of matching variables are redacted",
+			query: `query Q($apiSecret: [String!] = ["t"], $user: String = "bob", $password: String! = "d") { a }`,
+			want:  `query Q($apiSecret: [String!] = ["[REDACTED]"], $user: String = "bob", $password: String! = "[REDACTED]") { a }`,
+		},
+		{
+			desc:  "Matching fields of default values are redacted",
+			query: `query Q($input: Input = {name: "x", password: "p"}) { a }`,
+			want:  `query Q($input: Input = {name: "x", password: "[REDACTED]"}) { a }`,
+		},
+		{
+			desc:  "Invalid queries are redacted entirely",
+			query: `{ login(password: "unterminated }`,
+			want:  Redacted,
+		},
+	}
+
+	r := newTestRedactor(t)
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if got := r.Query(tC.query); got != tC.want {
+				t.Errorf("got %s, want %s", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestRedactVariables(t *testing.T) {
+	var vars map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"input": {"name": "bob", "password": "hunter2"},
+		"session": {"auth": {"token": "abc"}},
+		"users": [{"name": "a", "ssn": "1"}, {"name": "b", "ssn": "2"}]
+	}`), &vars)
+
+	var want map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"input": {"name": "bob", "password": "[REDACTED]"},
+		"session": {"auth": {"token": "[REDACTED]"}},
+		"users": [{"name": "a", "ssn": "[REDACTED]"}, {"name": "b", "ssn": "[REDACTED]"}]
+	}`), &want)
+
+	r := newTestRedactor(t)
+	if got := r.Variables(vars); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if vars["input"].(map[string]interface{})["password"] != "hunter2" {
+		t.Error("the variables of the request were modified")
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	var buf bytes.Buffer
+	r := newTestRedactor(t)
+	logger := slog.New(r.Handler(slog.NewJSONHandler(&buf, nil)))
+
+	header := http.Header{"Authorization": {"Bearer abc"}, "Accept": {"*/*"}}
+	logger.With("headers", header).WithGroup("req").Info("request",
+		slog.Group("headers", "cookie", "id=1"),
+		"query", `{ login(password: "hunter2") }`,
+		"variables", map[string]interface{}{"input": map[string]interface{}{"password": "hunter2"}},
+		"path", `/v1/graphql?query={login(password:"hunter2")}`,
+	)
+
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || strings.Contains(out, "Bearer") || strings.Contains(out, "id=1") {
+		t.Errorf("sensitive value logged: %s", out)
+	}
+	if !strings.Contains(out, `"Accept":["*/*"]`) {
+		t.Errorf("allowed header missing: %s", out)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	testCases := []struct {
+		msg  string
+		want string
+	}{
+		{`input:1: Expected Name, found String "hunter2"`, `input:1: Expected Name, found String "[REDACTED]"`},
+		{`Variable "$password" got invalid value "a\"b"; Expected type Int`, `Variable "[REDACTED]" got invalid value "[REDACTED]"; Expected type Int`},
+		{`unterminated "hunter2`, `unterminated "[REDACTED]"`},
+		{`connection refused`, `connection refused`},
+	}
+	r := newTestRedactor(t)
+	for _, tC := range testCases {
+		if got := r.Error(tC.msg); got != tC.want {
+			t.Errorf("Error(%q) = %q, want %q", tC.msg, got, tC.want)
+		}
+	}
+
+	r, err := NewRedactor(config.RedactionConfig{Headers: []string{"Authorization"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Error(testCases[0].msg); got != testCases[0].msg {
+		t.Errorf("Error(%q) = %q without arguments or variables to redact", testCases[0].msg, got)
+	}
+}
+
+func TestRedactHandlerGroups(t *testing.T) {
+	var buf bytes.Buffer
+	r := newTestRedactor(t)
+	logger := slog.New(r.Handler(slog.NewJSONHandler(&buf, nil)))
+
+	logger.WithGroup("headers").WithGroup("").WithGroup("request").Info("request",
+		"authorization", "Bearer abc",
+		"error", errors.New(`invalid value "hunter2"`),
+	)
+
+	out := buf.String()
+	if strings.Contains(out, "Bearer") || strings.Contains(out, "hunter2") {
+		t.Errorf("sensitive value logged: %s", out)
+	}
+	if !strings.Contains(out, `"headers":{"request":{"authorization":"[REDACTED]"`) {
+		t.Errorf("groups not nested: %s", out)
+	}
+}
+
+func TestNewRedactorInvalidPath(t *testing.T) {
+	for _, p := range []string{"input.password", "$", "$.users[x]", "$.a[", "$.."} {
+		if _, err := NewRedactor(config.RedactionConfig{Variables: []string{p}}); err == nil {
+			t.Errorf("expected an error for %q", p)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogSockets are the usual locations of the local syslog socket.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// facilityDaemon is the syslog facility messages are sent with.
+const facilityDaemon = 3
+
+// syslogWriter sends every write as one message to the local syslog daemon. A message
+// that cannot be sent is sent again over a new connection, so that logging survives a
+// restart of the daemon.
+type syslogWriter struct {
+	network  string
+	address  string
+	conn     net.Conn
+	tag      string
+	severity int
+}
+
+func dialSyslog(address, tag string) (*syslogWriter, error) {
+	addresses := syslogSockets
+	if address != "" {
+		addresses = []string{address}
+	}
+
+	var errs []error
+	for _, addr := range addresses {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, addr)
+			if err == nil {
+				return &syslogWriter{network: network, address: addr, conn: conn, tag: tag}, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return nil, fmt.Errorf("connecting to syslog: %w", errors.Join(errs...))
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	msg := []byte(fmt.Sprintf("<%d>%s %s[%d]: %s\n",
+		facilityDaemon*8+w.severity,
+		time.Now().Format(time.Stamp),
+		w.tag,
+		os.Getpid(),
+		strings.TrimSuffix(string(p), "\n"),
+	))
+	if w.conn != nil {
+		if _, err := w.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	conn, err := net.Dial(w.network, w.address)
+	if err != nil {
+		return 0, fmt.Errorf("reconnecting to syslog: %w", err)
+	}
+	w.conn = conn
+	if _, err := w.conn.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// syslogHandler formats records with the handler of the sink and sends them with the
+// severity matching their level.
+type syslogHandler struct {
+	mu     *sync.Mutex
+	w      *syslogWriter
+	format slog.Handler
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.format.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.w.severity = severity(r.Level)
+	return h.format.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{mu: h.mu, w: h.w, format: h.format.WithAttrs(attrs)}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{mu: h.mu, w: h.w, format: h.format.WithGroup(name)}
+}
+
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
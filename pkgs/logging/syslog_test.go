@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// listenSyslog listens for syslog messages at path.
+func listenSyslog(t *testing.T, path string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readSyslog returns the next message received on conn.
+func readSyslog(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSyslogSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	server := listenSyslog(t, path)
+
+	logger, err := NewLogger(config.LogConfig{Sinks: []config.LogSinkConfig{
+		{Type: "syslog", Level: "debug", Address: path, Tag: "gqlproxy"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		log  func(string, ...any)
+		want string
+	}{
+		{logger.Error, `^<27>\w{3} [ \d]\d \d\d:\d\d:\d\d gqlproxy\[\d+\]: level=ERROR msg=failed key=value\n$`},
+		{logger.Warn, `^<28>.* gqlproxy\[\d+\]: level=WARN msg=failed key=value\n$`},
+		{logger.Info, `^<30>.* gqlproxy\[\d+\]: level=INFO msg=failed key=value\n$`},
+		{logger.Debug, `^<31>.* gqlproxy\[\d+\]: level=DEBUG msg=failed key=value\n$`},
+	}
+	for _, tC := range testCases {
+		tC.log("failed", "key", "value")
+		if got := readSyslog(t, server); !regexp.MustCompile(tC.want).MatchString(got) {
+			t.Errorf("got %q, want %s", got, tC.want)
+		}
+	}
+}
+
+func TestSyslogReconnect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	server := listenSyslog(t, path)
+
+	w, err := dialSyslog(path, "gqlproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	readSyslog(t, server)
+
+	// The daemon restarts and listens on a new socket at the same path.
+	server.Close()
+	os.Remove(path)
+	if _, err := w.Write([]byte("while down")); err == nil {
+		t.Fatal("expected an error while the daemon is down")
+	}
+	server = listenSyslog(t, path)
+
+	if _, err := w.Write([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readSyslog(t, server); !regexp.MustCompile(`: after\n$`).MatchString(got) {
+		t.Errorf("got %q after reconnecting", got)
+	}
+}
+
+func TestDialSyslogFails(t *testing.T) {
+	if _, err := dialSyslog(filepath.Join(t.TempDir(), "missing"), "gqlproxy"); err == nil {
+		t.Error("expected an error for a missing socket")
+	}
+}
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorClass classifies why a request failed.
+type ErrorClass string
+
+const (
+	// ClassParseError is a request that is not a GraphQL request.
+	ClassParseError ErrorClass = "request_parse_error"
+	// ClassInvalidOperation is a query that cannot be parsed or has no operation to execute.
+	ClassInvalidOperation ErrorClass = "invalid_operation"
+	// ClassRateLimited is a request over the rate limit of its client.
+	ClassRateLimited ErrorClass = "rate_limited"
+	// ClassNoUpstream is an operation no upstream can serve.
+	ClassNoUpstream ErrorClass = "no_eligible_upstream"
+	// ClassUpstreamConnect is an upstream that could not be reached or broke the connection.
+	ClassUpstreamConnect ErrorClass = "upstream_connect_error"
+	// ClassTimeout is an upstream that did not respond in time.
+	ClassTimeout ErrorClass = "timeout"
+	// ClassUpstream4xx and ClassUpstream5xx are upstream responses with an error status.
+	ClassUpstream4xx ErrorClass = "upstream_4xx"
+	ClassUpstream5xx ErrorClass = "upstream_5xx"
+	// ClassGraphQLErrors is an upstream response with GraphQL errors and no data. Partial
+	// responses, which have data too, are not failures.
+	ClassGraphQLErrors ErrorClass = "graphql_errors"
+	// ClassClientDisconnect is a client that went away before the response was written.
+	ClassClientDisconnect ErrorClass = "client_disconnect"
+	// ClassInternal is a failure of the proxy itself.
+	ClassInternal ErrorClass = "internal_error"
+)
+
+// maxErrorKeys caps the combinations failed requests are counted under, operation names
+// beyond it are counted as otherOperation so that clients sending ever new operation names
+// cannot exhaust memory.
+const (
+	maxErrorKeys   = 1000
+	otherOperation = "other"
+)
+
+type errorKey struct {
+	class         ErrorClass
+	operationType string
+	operationName string
+	upstream      string
+}
+
+type errorCounters struct {
+	mu       sync.RWMutex
+	counters map[errorKey]*atomic.Int64
+}
+
+func (e *errorCounters) add(key errorKey) {
+	e.mu.RLock()
+	counter, ok := e.counters[key]
+	e.mu.RUnlock()
+
+	if !ok {
+		e.mu.Lock()
+		counter = e.counter(key)
+		e.mu.Unlock()
+	}
+
+	counter.Add(1)
+}
+
+// counter returns the counter of key, creating it if needed. It must be called with the
+// lock held.
+func (e *errorCounters) counter(key errorKey) *atomic.Int64 {
+	if counter, ok := e.counters[key]; ok {
+		return counter
+	}
+	if e.counters == nil {
+		e.counters = make(map[errorKey]*atomic.Int64)
+	}
+	if len(e.counters) >= maxErrorKeys {
+		key.operationName = otherOperation
+		if counter, ok := e.counters[key]; ok {
+			return counter
+		}
+	}
+	counter := &atomic.Int64{}
+	e.counters[key] = counter
+	return counter
+}
+
+// ErrorSnapshot is the number of failed requests of an operation sent to an upstream
+// with the same class. Operation and upstream are empty when the request failed before
+// they were known.
+type ErrorSnapshot struct {
+	Class         ErrorClass
+	OperationType string
+	OperationName string
+	Upstream      string
+	Count         int64
+}
+
+func (e *errorCounters) snapshot() []ErrorSnapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	errors := make([]ErrorSnapshot, 0, len(e.counters))
+	for k, counter := range e.counters {
+		errors = append(errors, ErrorSnapshot{
+			Class:         k.class,
+			OperationType: k.operationType,
+			OperationName: k.operationName,
+			Upstream:      k.upstream,
+			Count:         counter.Load(),
+		})
+	}
+	sort.Slice(errors, func(i, j int) bool {
+		a, b := errors[i], errors[j]
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		if a.OperationType != b.OperationType {
+			return a.OperationType < b.OperationType
+		}
+		if a.OperationName != b.OperationName {
+			return a.OperationName < b.OperationName
+		}
+		return a.Upstream < b.Upstream
+	})
+	return errors
+}
+
+// RecordError records a failed request by class. The request itself is recorded by
+// RecordRequest.
+func (m *Metrics) RecordError(class ErrorClass, operation OperationInfo, upstream string) {
+	m.errors.add(errorKey{
+		class:         class,
+		operationType: operation.Type,
+		operationName: operation.Name,
+		upstream:      upstream,
+	})
+}
+
+// errorStats returns the number of failed requests by class, broken down by operation
+// and by upstream.
+func (m *Metrics) errorStats() map[string]interface{} {
+	return classStats(m.errors.snapshot())
+}
+
+func classStats(counts []ErrorSnapshot) map[string]interface{} {
+	stats := make(map[string]interface{})
+	for _, e := range counts {
+		class, ok := stats[string(e.Class)].(map[string]interface{})
+		if !ok {
+			class = map[string]interface{}{
+				"total":      int64(0),
+				"operations": make(map[string]int64),
+				"upstreams":  make(map[string]int64),
+			}
+			stats[string(e.Class)] = class
+		}
+		class["total"] = class["total"].(int64) + e.Count
+		if e.OperationType != "" {
+			op := e.OperationType
+			if e.OperationName != "" {
+				op += " " + e.OperationName
+			}
+			class["operations"].(map[string]int64)[op] += e.Count
+		}
+		if e.Upstream != "" {
+			class["upstreams"].(map[string]int64)[e.Upstream] += e.Count
+		}
+	}
+	return stats
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRecordError(t *testing.T) {
+	m := New()
+	query := OperationInfo{Type: "query", Name: "Q", Hash: "q"}
+	m.RecordError(ClassTimeout, query, "http://a")
+	m.RecordError(ClassTimeout, query, "http://a")
+	m.RecordError(ClassTimeout, query, "http://b")
+	m.RecordError(ClassParseError, OperationInfo{}, "")
+
+	want := []ErrorSnapshot{
+		{Class: ClassParseError, Count: 1},
+		{Class: ClassTimeout, OperationType: "query", OperationName: "Q", Upstream: "http://a", Count: 2},
+		{Class: ClassTimeout, OperationType: "query", OperationName: "Q", Upstream: "http://b", Count: 1},
+	}
+	if got := m.Snapshot().Errors; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	stats := m.errorStats()
+	timeouts := stats[string(ClassTimeout)].(map[string]interface{})
+	if timeouts["total"] != int64(3) || timeouts["operations"].(map[string]int64)["query Q"] != 3 || timeouts["upstreams"].(map[string]int64)["http://b"] != 1 {
+		t.Errorf("timeout stats = %v", timeouts)
+	}
+}
+
+func TestRecordErrorBound(t *testing.T) {
+	m := New()
+	for i := 0; i < maxErrorKeys+500; i++ {
+		m.RecordError(ClassUpstream5xx, OperationInfo{Type: "query", Name: "Q" + strconv.Itoa(i)}, "http://a")
+	}
+
+	errors := m.Snapshot().Errors
+	if len(errors) != maxErrorKeys+1 {
+		t.Fatalf("got %d error counters, want %d", len(errors), maxErrorKeys+1)
+	}
+	var total, other int64
+	for _, e := range errors {
+		total += e.Count
+		if e.OperationName == otherOperation {
+			other = e.Count
+		}
+	}
+	if total != maxErrorKeys+500 || other != 500 {
+		t.Errorf("counted %d requests, %d under %q", total, other, otherOperation)
+	}
+}
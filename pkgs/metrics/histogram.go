@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// bucketBounds are the upper bounds of the latency histogram buckets. They grow
+// log-linearly from 10µs to 90s so that percentiles can be estimated with an error of
+// at most 25% of the bucket value.
+var bucketBounds = func() [105]time.Duration {
+	mantissas := [...]int64{100, 125, 150, 175, 200, 250, 300, 350, 400, 450, 500, 600, 700, 800, 900}
+
+	var bounds [105]time.Duration
+	i := 0
+	for scale := int64(10 * time.Microsecond); i < len(bounds); scale *= 10 {
+		for _, m := range mantissas {
+			bounds[i] = time.Duration(m * scale / 100)
+			i++
+		}
+	}
+	return bounds
+}()
+
+// exportBounds are the bucket bounds exposed to Prometheus. Each of them is also a bound of
+// bucketBounds so the exported buckets are exact.
+var exportBounds = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram counts durations into fixed buckets without locking. The last bucket
+// counts durations larger than every bound.
+type Histogram struct {
+	counts [len(bucketBounds) + 1]atomic.Int64
+	sum    atomic.Int64
+	max    atomic.Int64
+}
+
+type HistogramSnapshot struct {
+	// Bounds are the upper bounds of each bucket except the last one.
+	Bounds []time.Duration
+	// Counts are the number of observations in each bucket. They are not cumulative.
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(bucketBounds), func(i int) bool { return bucketBounds[i] >= d })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+
+	for {
+		cur := h.max.Load()
+		if int64(d) <= cur || h.max.CompareAndSwap(cur, int64(d)) {
+			break
+		}
+	}
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: bucketBounds[:],
+		Counts: make([]int64, len(h.counts)),
+		Sum:    time.Duration(h.sum.Load()),
+		Max:    time.Duration(h.max.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// Mean returns the average of the observations.
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observations by interpolating
+// linearly within the bucket that contains it.
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(s.Count)
+	var cumulative int64
+	for i, c := range s.Counts {
+		if c == 0 || float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+
+		var lower, upper time.Duration
+		if i > 0 {
+			lower = s.Bounds[i-1]
+		}
+		if i < len(s.Bounds) {
+			upper = s.Bounds[i]
+		} else {
+			upper = s.Max
+		}
+		if upper > s.Max {
+			upper = s.Max
+		}
+		if lower > upper {
+			lower = upper
+		}
+
+		fraction := (rank - float64(cumulative)) / float64(c)
+		return lower + time.Duration(fraction*float64(upper-lower))
+	}
+
+	return s.Max
+}
+
+// Export returns the snapshot with its buckets accumulated into the coarser buckets
+// that are exported to monitoring systems.
+func (s HistogramSnapshot) Export() HistogramSnapshot {
+	e := HistogramSnapshot{
+		Bounds: exportBounds[:],
+		Counts: make([]int64, len(exportBounds)+1),
+		Count:  s.Count,
+		Sum:    s.Sum,
+		Max:    s.Max,
+	}
+
+	j := 0
+	for i, c := range s.Counts {
+		for j < len(exportBounds) && i < len(s.Bounds) && s.Bounds[i] > exportBounds[j] {
+			j++
+		}
+		if i >= len(s.Bounds) {
+			j = len(exportBounds)
+		}
+		e.Counts[j] += c
+	}
+	return e
+}
+
+// merge adds the observations of o to s. Both snapshots must have the same bounds.
+func (s *HistogramSnapshot) merge(o HistogramSnapshot) {
+	if s.Counts == nil {
+		s.Bounds = o.Bounds
+		s.Counts = make([]int64, len(o.Counts))
+	}
+	for i, c := range o.Counts {
+		s.Counts[i] += c
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+	if o.Max > s.Max {
+		s.Max = o.Max
+	}
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	s := h.Snapshot()
+	if s.Count != 1000 {
+		t.Fatalf("expected 1000 observations, got %v", s.Count)
+	}
+	if s.Max != time.Second {
+		t.Errorf("expected max %v, got %v", time.Second, s.Max)
+	}
+
+	testCases := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{q: 0.50, expected: 500 * time.Millisecond},
+		{q: 0.95, expected: 950 * time.Millisecond},
+		{q: 0.99, expected: 990 * time.Millisecond},
+		{q: 1, expected: time.Second},
+	}
+
+	for _, tC := range testCases {
+		got := s.Quantile(tC.q)
+		if diff := got - tC.expected; diff > tC.expected/10 || diff < -tC.expected/10 {
+			t.Errorf("q%v: expected about %v, got %v", tC.q, tC.expected, got)
+		}
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	var h Histogram
+	s := h.Snapshot()
+	if q := s.Quantile(0.99); q != 0 {
+		t.Errorf("expected 0, got %v", q)
+	}
+	if m := s.Mean(); m != 0 {
+		t.Errorf("expected 0, got %v", m)
+	}
+}
+
+func TestExportBoundsAreBucketBounds(t *testing.T) {
+	for _, bound := range exportBounds {
+		found := false
+		for _, b := range bucketBounds {
+			if b == bound {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("export bound %v is not a bucket bound", bound)
+		}
+	}
+}
+
+func TestHistogramExport(t *testing.T) {
+	var h Histogram
+	h.Observe(time.Millisecond)
+	h.Observe(5 * time.Millisecond)
+	h.Observe(7 * time.Millisecond)
+	h.Observe(time.Minute)
+
+	e := h.Snapshot().Export()
+	expected := []int64{2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	if len(e.Counts) != len(expected) {
+		t.Fatalf("expected %v buckets, got %v", len(expected), len(e.Counts))
+	}
+	for i := range expected {
+		if e.Counts[i] != expected[i] {
+			t.Errorf("bucket %v: expected %v, got %v", i, expected[i], e.Counts[i])
+		}
+	}
+}
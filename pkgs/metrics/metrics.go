@@ -10,109 +10,421 @@ type OperationMetrics struct {
 	TotalRequests   atomic.Int64
 	SuccessRequests atomic.Int64
 	FailedRequests  atomic.Int64
-	TotalDuration   atomic.Int64
+	Duration        Histogram
+	// Recent is only kept for operation types, not for every signature.
+	Recent        *Window
+	GraphQLErrors GraphQLErrorMetrics
+	// Hedges counts the requests a hedge was sent for, HedgesWon the ones the hedge
+	// answered first.
+	Hedges    atomic.Int64
+	HedgesWon atomic.Int64
+}
+
+// GraphQLErrorMetrics counts the responses with GraphQL errors and the errors by code.
+type GraphQLErrorMetrics struct {
+	// PartialResponses have errors and data, FailedResponses have errors and no data.
+	PartialResponses atomic.Int64
+	FailedResponses  atomic.Int64
+	codes            sync.Map // code -> *atomic.Int64
+}
+
+func (g *GraphQLErrorMetrics) record(partial bool, codes []string) {
+	if partial {
+		g.PartialResponses.Add(1)
+	} else {
+		g.FailedResponses.Add(1)
+	}
+	for _, code := range codes {
+		counter, ok := g.codes.Load(code)
+		if !ok {
+			counter, _ = g.codes.LoadOrStore(code, &atomic.Int64{})
+		}
+		counter.(*atomic.Int64).Add(1)
+	}
+}
+
+// Codes returns the number of errors by code.
+func (g *GraphQLErrorMetrics) Codes() map[string]int64 {
+	codes := make(map[string]int64)
+	g.codes.Range(func(code, counter any) bool {
+		codes[code.(string)] = counter.(*atomic.Int64).Load()
+		return true
+	})
+	return codes
+}
+
+func (g *GraphQLErrorMetrics) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"partial": g.PartialResponses.Load(),
+		"failed":  g.FailedResponses.Load(),
+		"codes":   g.Codes(),
+	}
 }
 
 type UpstreamMetrics struct {
 	TotalRequests   atomic.Int64
 	SuccessRequests atomic.Int64
 	FailedRequests  atomic.Int64
-	TotalLatency    atomic.Int64
+	Latency         Histogram
+	Recent          Window
+	// Health is 0 while unknown, healthUp or healthDown once the upstream was checked.
+	Health    atomic.Int32
+	Ejected   atomic.Bool
+	Ejections atomic.Int64
+	// ConcurrencyLimit is the number of requests the upstream may have in flight, 0 when
+	// unlimited. Requests over the limit wait in a queue of QueueDepth requests.
+	ConcurrencyLimit atomic.Int64
+	QueueDepth       atomic.Int64
+	QueueWait        Histogram
+	QueueTimeouts    atomic.Int64
+	QueueRejected    atomic.Int64
+
+	circuitMu          sync.Mutex
+	circuitState       string
+	circuitTransitions map[string]int64
+}
+
+// Circuit returns the state of the circuit breaker of the upstream, empty until it first
+// changed, and the number of times the circuit entered each state.
+func (u *UpstreamMetrics) Circuit() (string, map[string]int64) {
+	u.circuitMu.Lock()
+	defer u.circuitMu.Unlock()
+	transitions := make(map[string]int64, len(u.circuitTransitions))
+	for state, n := range u.circuitTransitions {
+		transitions[state] = n
+	}
+	return u.circuitState, transitions
+}
+
+const (
+	healthUp   = 1
+	healthDown = 2
+)
+
+// OperationInfo identifies the operation a request executed.
+type OperationInfo struct {
+	Type string
+	Name string
+	Hash string
+}
+
+// maxSignatures caps the signatures requests are counted under, operations with further
+// signatures are counted under one signature per type named otherOperation, which also
+// bounds the operation names metrics are labeled with.
+const maxSignatures = 1000
+
+type SignatureMetrics struct {
+	OperationMetrics
+	Type string
+	Name string
 }
 
 type Metrics struct {
 	mu             sync.RWMutex
 	operations     map[string]*OperationMetrics
+	signatures     map[string]*SignatureMetrics
 	upstreams      map[string]*UpstreamMetrics
 	startTime      time.Time
 	totalRequests  atomic.Int64
 	activeRequests atomic.Int64
+	recent         Window
+	errors         errorCounters
+
+	retries              errorCounters
+	retryBudgetExhausted atomic.Int64
+	hedgeBudgetExhausted atomic.Int64
+	rateLimits           rateLimitCounters
 }
 
 func New() *Metrics {
 	return &Metrics{
 		operations: make(map[string]*OperationMetrics),
+		signatures: make(map[string]*SignatureMetrics),
 		upstreams:  make(map[string]*UpstreamMetrics),
 		startTime:  time.Now(),
 	}
 }
 
-func (m *Metrics) RecordRequest(operation string, duration time.Duration, success bool) {
+func (m *Metrics) RecordRequest(operation OperationInfo, duration time.Duration, success bool) {
+	opMetrics, sigMetrics := m.operation(operation)
+
+	now := time.Now()
+	opMetrics.record(now, duration, success)
+	sigMetrics.record(now, duration, success)
+	m.recent.Record(now, duration, success)
+	m.totalRequests.Add(1)
+}
+
+// RecordGraphQLErrors records a response that has GraphQL errors with the given codes.
+// The request itself is recorded by RecordRequest.
+func (m *Metrics) RecordGraphQLErrors(operation OperationInfo, partial bool, codes []string) {
+	opMetrics, sigMetrics := m.operation(operation)
+	opMetrics.GraphQLErrors.record(partial, codes)
+	sigMetrics.GraphQLErrors.record(partial, codes)
+}
+
+// RecordHedge records that a hedge was sent for a request and whether it answered first.
+func (m *Metrics) RecordHedge(operation OperationInfo, won bool) {
+	opMetrics, sigMetrics := m.operation(operation)
+	for _, o := range []*OperationMetrics{opMetrics, &sigMetrics.OperationMetrics} {
+		o.Hedges.Add(1)
+		if won {
+			o.HedgesWon.Add(1)
+		}
+	}
+}
+
+// RecordHedgeBudgetExhausted records a request that was not hedged because the hedging
+// budget was spent.
+func (m *Metrics) RecordHedgeBudgetExhausted() {
+	m.hedgeBudgetExhausted.Add(1)
+}
+
+// Latency returns the q-quantile of the duration of the requests with the signature of
+// the operation and the number of requests it is estimated from.
+func (m *Metrics) Latency(operation OperationInfo, q float64) (time.Duration, int64) {
+	m.mu.RLock()
+	sigMetrics, ok := m.signatures[operation.Hash]
+	m.mu.RUnlock()
+	if !ok {
+		return 0, 0
+	}
+	duration := sigMetrics.Duration.Snapshot()
+	return duration.Quantile(q), duration.Count
+}
+
+// operation returns the metrics of the type and the signature of an operation.
+func (m *Metrics) operation(operation OperationInfo) (*OperationMetrics, *SignatureMetrics) {
 	m.mu.RLock()
-	opMetrics, exists := m.operations[operation]
+	opMetrics, exists := m.operations[operation.Type]
+	sigMetrics, sigExists := m.signatures[operation.Hash]
 	m.mu.RUnlock()
 
-	if !exists {
+	if !exists || !sigExists {
 		m.mu.Lock()
-		opMetrics = &OperationMetrics{}
-		m.operations[operation] = opMetrics
+		if opMetrics, exists = m.operations[operation.Type]; !exists {
+			opMetrics = &OperationMetrics{Recent: &Window{}}
+			m.operations[operation.Type] = opMetrics
+		}
+		if sigMetrics, sigExists = m.signatures[operation.Hash]; !sigExists {
+			hash := operation.Hash
+			if len(m.signatures) >= maxSignatures {
+				// Hashes are hex, so the key cannot collide with a signature.
+				hash, operation.Name = otherOperation+" "+operation.Type, otherOperation
+				sigMetrics, sigExists = m.signatures[hash]
+			}
+			if !sigExists {
+				sigMetrics = &SignatureMetrics{Type: operation.Type, Name: operation.Name}
+				m.signatures[hash] = sigMetrics
+			}
+		}
 		m.mu.Unlock()
 	}
 
-	opMetrics.TotalRequests.Add(1)
+	return opMetrics, sigMetrics
+}
+
+func (o *OperationMetrics) record(now time.Time, duration time.Duration, success bool) {
+	o.TotalRequests.Add(1)
 	if success {
-		opMetrics.SuccessRequests.Add(1)
+		o.SuccessRequests.Add(1)
 	} else {
-		opMetrics.FailedRequests.Add(1)
+		o.FailedRequests.Add(1)
+	}
+	o.Duration.Observe(duration)
+	if o.Recent != nil {
+		o.Recent.Record(now, duration, success)
 	}
-	opMetrics.TotalDuration.Add(duration.Microseconds())
-	m.totalRequests.Add(1)
 }
 
 func (m *Metrics) RecordUpstreamRequest(url string, latency time.Duration, success bool) {
+	upMetrics := m.upstream(url)
+
+	upMetrics.TotalRequests.Add(1)
+	if success {
+		upMetrics.SuccessRequests.Add(1)
+	} else {
+		upMetrics.FailedRequests.Add(1)
+	}
+	upMetrics.Latency.Observe(latency)
+	upMetrics.Recent.Record(time.Now(), latency, success)
+}
+
+// SetUpstreamHealth records the result of the health checks of an upstream.
+func (m *Metrics) SetUpstreamHealth(url string, healthy bool) {
+	if healthy {
+		m.upstream(url).Health.Store(healthUp)
+	} else {
+		m.upstream(url).Health.Store(healthDown)
+	}
+}
+
+// SetUpstreamEjected records that an upstream was ejected or returned from ejection.
+func (m *Metrics) SetUpstreamEjected(url string, ejected bool) {
+	upMetrics := m.upstream(url)
+	upMetrics.Ejected.Store(ejected)
+	if ejected {
+		upMetrics.Ejections.Add(1)
+	}
+}
+
+// SetCircuitState records that the circuit breaker of an upstream entered a state.
+func (m *Metrics) SetCircuitState(url string, state string) {
+	upMetrics := m.upstream(url)
+	upMetrics.circuitMu.Lock()
+	defer upMetrics.circuitMu.Unlock()
+	upMetrics.circuitState = state
+	if upMetrics.circuitTransitions == nil {
+		upMetrics.circuitTransitions = make(map[string]int64)
+	}
+	upMetrics.circuitTransitions[state]++
+}
+
+// SetConcurrencyLimit records the number of requests an upstream may have in flight.
+func (m *Metrics) SetConcurrencyLimit(url string, limit int) {
+	m.upstream(url).ConcurrencyLimit.Store(int64(limit))
+}
+
+// SetQueueDepth records the number of requests waiting for an upstream.
+func (m *Metrics) SetQueueDepth(url string, depth int) {
+	m.upstream(url).QueueDepth.Store(int64(depth))
+}
+
+// RecordQueueWait records how long a request waited for an upstream and whether it gave
+// up because it timed out.
+func (m *Metrics) RecordQueueWait(url string, wait time.Duration, timedOut bool) {
+	upMetrics := m.upstream(url)
+	upMetrics.QueueWait.Observe(wait)
+	if timedOut {
+		upMetrics.QueueTimeouts.Add(1)
+	}
+}
+
+// RecordQueueRejected records a request rejected because the queue of an upstream was
+// full.
+func (m *Metrics) RecordQueueRejected(url string) {
+	m.upstream(url).QueueRejected.Add(1)
+}
+
+func (m *Metrics) upstream(url string) *UpstreamMetrics {
 	m.mu.RLock()
 	upMetrics, exists := m.upstreams[url]
 	m.mu.RUnlock()
 
 	if !exists {
 		m.mu.Lock()
-		upMetrics = &UpstreamMetrics{}
-		m.upstreams[url] = upMetrics
+		if upMetrics, exists = m.upstreams[url]; !exists {
+			upMetrics = &UpstreamMetrics{}
+			m.upstreams[url] = upMetrics
+		}
 		m.mu.Unlock()
 	}
 
-	upMetrics.TotalRequests.Add(1)
-	if success {
-		upMetrics.SuccessRequests.Add(1)
-	} else {
-		upMetrics.FailedRequests.Add(1)
-	}
-	upMetrics.TotalLatency.Add(latency.Microseconds())
+	return upMetrics
 }
 
 func (m *Metrics) GetStats() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	now := time.Now()
 	stats := map[string]interface{}{
-		"uptime_seconds":  time.Since(m.startTime).Seconds(),
+		"uptime_seconds":  now.Sub(m.startTime).Seconds(),
 		"total_requests":  m.totalRequests.Load(),
 		"operations":      make(map[string]interface{}),
+		"signatures":      make(map[string]interface{}),
 		"upstreams":       make(map[string]interface{}),
 		"active_requests": m.activeRequests.Load(),
+		"windows":         windowStats(&m.recent, now),
+		"errors":          m.errorStats(),
+		"retries":         m.retryStats(),
+		"rate_limits":     m.rateLimitStats(),
+		"hedging": map[string]interface{}{
+			"budget_exhausted": m.hedgeBudgetExhausted.Load(),
+		},
 	}
 
 	for op, metrics := range m.operations {
+		duration := metrics.Duration.Snapshot()
 		stats["operations"].(map[string]interface{})[op] = map[string]interface{}{
-			"total":    metrics.TotalRequests.Load(),
-			"success":  metrics.SuccessRequests.Load(),
-			"failed":   metrics.FailedRequests.Load(),
-			"avg_time": float64(metrics.TotalDuration.Load()) / float64(metrics.TotalRequests.Load()) / 1000.0,
+			"total":          metrics.TotalRequests.Load(),
+			"success":        metrics.SuccessRequests.Load(),
+			"failed":         metrics.FailedRequests.Load(),
+			"avg_time":       milliseconds(duration.Mean()),
+			"latency":        latencyStats(duration),
+			"windows":        windowStats(metrics.Recent, now),
+			"graphql_errors": metrics.GraphQLErrors.stats(),
+			"hedges":         metrics.Hedges.Load(),
+			"hedges_won":     metrics.HedgesWon.Load(),
+		}
+	}
+
+	for hash, metrics := range m.signatures {
+		duration := metrics.Duration.Snapshot()
+		stats["signatures"].(map[string]interface{})[hash] = map[string]interface{}{
+			"type":           metrics.Type,
+			"name":           metrics.Name,
+			"total":          metrics.TotalRequests.Load(),
+			"success":        metrics.SuccessRequests.Load(),
+			"failed":         metrics.FailedRequests.Load(),
+			"avg_time":       milliseconds(duration.Mean()),
+			"latency":        latencyStats(duration),
+			"graphql_errors": metrics.GraphQLErrors.stats(),
+			"hedges":         metrics.Hedges.Load(),
+			"hedges_won":     metrics.HedgesWon.Load(),
 		}
 	}
 
 	for url, metrics := range m.upstreams {
-		stats["upstreams"].(map[string]interface{})[url] = map[string]interface{}{
+		latency := metrics.Latency.Snapshot()
+		upstream := map[string]interface{}{
 			"total":       metrics.TotalRequests.Load(),
 			"success":     metrics.SuccessRequests.Load(),
 			"failed":      metrics.FailedRequests.Load(),
-			"avg_latency": float64(metrics.TotalLatency.Load()) / float64(metrics.TotalRequests.Load()) / 1000.0,
+			"avg_latency": milliseconds(latency.Mean()),
+			"latency":     latencyStats(latency),
+			"windows":     windowStats(&metrics.Recent, now),
+			"ejected":     metrics.Ejected.Load(),
+			"ejections":   metrics.Ejections.Load(),
+		}
+		if health := metrics.Health.Load(); health != 0 {
+			upstream["healthy"] = health == healthUp
+		}
+		if limit := metrics.ConcurrencyLimit.Load(); limit > 0 {
+			upstream["concurrency"] = map[string]interface{}{
+				"limit":          limit,
+				"queue_depth":    metrics.QueueDepth.Load(),
+				"queue_wait":     latencyStats(metrics.QueueWait.Snapshot()),
+				"queue_timeouts": metrics.QueueTimeouts.Load(),
+				"queue_rejected": metrics.QueueRejected.Load(),
+			}
+		}
+		if state, transitions := metrics.Circuit(); state != "" {
+			upstream["circuit"] = map[string]interface{}{
+				"state":       state,
+				"transitions": transitions,
+			}
 		}
+		stats["upstreams"].(map[string]interface{})[url] = upstream
 	}
 
 	return stats
 }
 
+// latencyStats returns the percentiles of a histogram in milliseconds.
+func latencyStats(h HistogramSnapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"p50": milliseconds(h.Quantile(0.50)),
+		"p95": milliseconds(h.Quantile(0.95)),
+		"p99": milliseconds(h.Quantile(0.99)),
+		"max": milliseconds(h.Max),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 func (m *Metrics) IncActiveRequests() {
 	m.activeRequests.Add(1)
 }
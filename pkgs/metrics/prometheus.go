@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const namespace = "graphql_proxy_"
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	s := m.Snapshot()
+	p := &promWriter{w: bufio.NewWriter(w)}
+
+	p.header("uptime_seconds", "gauge", "Time since the proxy started.")
+	p.sample("uptime_seconds", nil, s.Uptime.Seconds())
+
+	p.header("active_requests", "gauge", "Number of requests currently being proxied.")
+	p.sample("active_requests", nil, float64(s.ActiveRequests))
+
+	p.header("requests_total", "counter", "Total number of GraphQL requests.")
+	for _, op := range s.Operations {
+		p.sample("requests_total", operationLabels(op), float64(op.Total))
+	}
+
+	p.header("request_errors_total", "counter", "Total number of failed GraphQL requests.")
+	for _, op := range s.Operations {
+		p.sample("request_errors_total", operationLabels(op), float64(op.Failed))
+	}
+
+	p.header("request_duration_seconds", "histogram", "Duration of GraphQL requests.")
+	for _, op := range s.Operations {
+		p.histogram("request_duration_seconds", operationLabels(op), op.Duration)
+	}
+
+	p.header("graphql_error_responses_total", "counter", "Total number of responses with GraphQL errors, with (partial) or without data (failed).")
+	for _, op := range s.Operations {
+		p.sample("graphql_error_responses_total", append(operationLabels(op), "result", "partial"), float64(op.PartialResponses))
+		p.sample("graphql_error_responses_total", append(operationLabels(op), "result", "failed"), float64(op.FailedResponses))
+	}
+
+	p.header("graphql_errors_total", "counter", "Total number of GraphQL errors by extensions.code.")
+	for _, op := range s.Operations {
+		for _, code := range sortedKeys(op.ErrorCodes) {
+			p.sample("graphql_errors_total", append(operationLabels(op), "code", code), float64(op.ErrorCodes[code]))
+		}
+	}
+
+	p.header("hedged_requests_total", "counter", "Total number of requests a hedge was sent for.")
+	for _, op := range s.Operations {
+		p.sample("hedged_requests_total", operationLabels(op), float64(op.Hedges))
+	}
+
+	p.header("hedge_wins_total", "counter", "Total number of hedged requests the hedge answered first.")
+	for _, op := range s.Operations {
+		p.sample("hedge_wins_total", operationLabels(op), float64(op.HedgesWon))
+	}
+
+	p.header("hedge_budget_exhausted_total", "counter", "Total number of requests not hedged because the hedging budget was spent.")
+	p.sample("hedge_budget_exhausted_total", nil, float64(s.HedgeBudgetExhausted))
+
+	p.header("errors_total", "counter", "Total number of failed requests by class.")
+	for _, e := range s.Errors {
+		p.sample("errors_total", []string{
+			"class", string(e.Class),
+			"operation_type", e.OperationType,
+			"operation_name", e.OperationName,
+			"upstream", e.Upstream,
+		}, float64(e.Count))
+	}
+
+	p.header("retries_total", "counter", "Total number of retried requests by the class of the failure.")
+	for _, e := range s.Retries {
+		p.sample("retries_total", []string{
+			"class", string(e.Class),
+			"operation_type", e.OperationType,
+			"operation_name", e.OperationName,
+			"upstream", e.Upstream,
+		}, float64(e.Count))
+	}
+
+	p.header("retry_budget_exhausted_total", "counter", "Total number of failed requests not retried because the retry budget was spent.")
+	p.sample("retry_budget_exhausted_total", nil, float64(s.RetryBudgetExhausted))
+
+	p.header("rate_limit_requests_total", "counter", "Total number of requests counted by a rate limit rule by whether they were limited.")
+	for _, l := range s.RateLimits {
+		labels := []string{"rule", l.Rule, "key", l.Key, "operation_name", l.Operation}
+		p.sample("rate_limit_requests_total", append(labels[:len(labels):len(labels)], "result", "allowed"), float64(l.Allowed))
+		p.sample("rate_limit_requests_total", append(labels, "result", "limited"), float64(l.Limited))
+	}
+
+	p.header("upstream_requests_total", "counter", "Total number of requests sent to upstreams.")
+	for _, up := range s.Upstreams {
+		p.sample("upstream_requests_total", []string{"upstream", up.URL}, float64(up.Total))
+	}
+
+	p.header("upstream_errors_total", "counter", "Total number of failed requests sent to upstreams.")
+	for _, up := range s.Upstreams {
+		p.sample("upstream_errors_total", []string{"upstream", up.URL}, float64(up.Failed))
+	}
+
+	p.header("upstream_healthy", "gauge", "Whether the upstream passes its health checks.")
+	for _, up := range s.Upstreams {
+		if up.Checked {
+			p.sample("upstream_healthy", []string{"upstream", up.URL}, boolValue(up.Healthy))
+		}
+	}
+
+	p.header("upstream_ejected", "gauge", "Whether the upstream is ejected for failing requests.")
+	for _, up := range s.Upstreams {
+		p.sample("upstream_ejected", []string{"upstream", up.URL}, boolValue(up.Ejected))
+	}
+
+	p.header("upstream_ejections_total", "counter", "Total number of times the upstream was ejected.")
+	for _, up := range s.Upstreams {
+		p.sample("upstream_ejections_total", []string{"upstream", up.URL}, float64(up.Ejections))
+	}
+
+	p.header("upstream_circuit_state", "gauge", "Whether the circuit breaker of the upstream is in the state.")
+	for _, up := range s.Upstreams {
+		for _, state := range up.circuitStates() {
+			p.sample("upstream_circuit_state", []string{"upstream", up.URL, "state", state}, boolValue(state == up.CircuitState))
+		}
+	}
+
+	p.header("upstream_circuit_transitions_total", "counter", "Total number of times the circuit breaker of the upstream entered the state.")
+	for _, up := range s.Upstreams {
+		for _, state := range up.circuitStates() {
+			p.sample("upstream_circuit_transitions_total", []string{"upstream", up.URL, "state", state}, float64(up.CircuitTransitions[state]))
+		}
+	}
+
+	p.header("upstream_concurrency_limit", "gauge", "Number of requests the upstream may have in flight.")
+	for _, up := range s.Upstreams {
+		if up.ConcurrencyLimit > 0 {
+			p.sample("upstream_concurrency_limit", []string{"upstream", up.URL}, float64(up.ConcurrencyLimit))
+		}
+	}
+
+	p.header("upstream_queue_depth", "gauge", "Number of requests waiting for the upstream.")
+	for _, up := range s.Upstreams {
+		if up.ConcurrencyLimit > 0 {
+			p.sample("upstream_queue_depth", []string{"upstream", up.URL}, float64(up.QueueDepth))
+		}
+	}
+
+	p.header("upstream_queue_timeouts_total", "counter", "Total number of requests that timed out waiting for the upstream.")
+	for _, up := range s.Upstreams {
+		if up.ConcurrencyLimit > 0 {
+			p.sample("upstream_queue_timeouts_total", []string{"upstream", up.URL}, float64(up.QueueTimeouts))
+		}
+	}
+
+	p.header("upstream_queue_rejected_total", "counter", "Total number of requests rejected because the queue of the upstream was full.")
+	for _, up := range s.Upstreams {
+		if up.ConcurrencyLimit > 0 {
+			p.sample("upstream_queue_rejected_total", []string{"upstream", up.URL}, float64(up.QueueRejected))
+		}
+	}
+
+	p.header("upstream_queue_wait_seconds", "histogram", "Time requests waited for the upstream.")
+	for _, up := range s.Upstreams {
+		if up.ConcurrencyLimit > 0 {
+			p.histogram("upstream_queue_wait_seconds", []string{"upstream", up.URL}, up.QueueWait)
+		}
+	}
+
+	p.header("upstream_request_duration_seconds", "histogram", "Latency of requests sent to upstreams.")
+	for _, up := range s.Upstreams {
+		p.histogram("upstream_request_duration_seconds", []string{"upstream", up.URL}, up.Latency)
+	}
+
+	return p.w.Flush()
+}
+
+func operationLabels(op OperationSnapshot) []string {
+	return []string{"operation_type", op.Type, "operation_name", op.Name}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promWriter writes metric families. Labels are given as alternating names and values.
+type promWriter struct {
+	w *bufio.Writer
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	p.w.WriteString("# HELP " + namespace + name + " " + help + "\n")
+	p.w.WriteString("# TYPE " + namespace + name + " " + typ + "\n")
+}
+
+func (p *promWriter) sample(name string, labels []string, value float64) {
+	p.w.WriteString(namespace + name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			p.w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	p.w.WriteByte('\n')
+}
+
+func (p *promWriter) histogram(name string, labels []string, h HistogramSnapshot) {
+	h = h.Export()
+	var cumulative int64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		p.sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)), float64(cumulative))
+	}
+	p.sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(h.Count))
+	p.sample(name+"_sum", labels, h.Sum.Seconds())
+	p.sample(name+"_count", labels, float64(h.Count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
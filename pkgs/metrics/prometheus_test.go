@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := New()
+	query := OperationInfo{Type: "query", Name: `Q"1`, Hash: "q1"}
+	m.RecordRequest(query, 3*time.Millisecond, true)
+	m.RecordRequest(query, 40*time.Millisecond, false)
+	m.RecordGraphQLErrors(query, true, []string{"NOT_FOUND"})
+	m.RecordError(ClassUpstream5xx, query, "http://a")
+	m.RecordUpstreamRequest("http://a", 2*time.Millisecond, true)
+	m.SetUpstreamHealth("http://a", true)
+	m.RecordRateLimit("per-ip", "192.0.2.1", "", true)
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# HELP graphql_proxy_requests_total Total number of GraphQL requests.\n# TYPE graphql_proxy_requests_total counter\n",
+		`graphql_proxy_requests_total{operation_type="query",operation_name="Q\"1"} 2`,
+		`graphql_proxy_request_errors_total{operation_type="query",operation_name="Q\"1"} 1`,
+		`graphql_proxy_request_duration_seconds_bucket{operation_type="query",operation_name="Q\"1",le="0.005"} 1`,
+		`graphql_proxy_request_duration_seconds_bucket{operation_type="query",operation_name="Q\"1",le="0.025"} 1`,
+		`graphql_proxy_request_duration_seconds_bucket{operation_type="query",operation_name="Q\"1",le="0.05"} 2`,
+		`graphql_proxy_request_duration_seconds_bucket{operation_type="query",operation_name="Q\"1",le="+Inf"} 2`,
+		`graphql_proxy_request_duration_seconds_count{operation_type="query",operation_name="Q\"1"} 2`,
+		`graphql_proxy_graphql_error_responses_total{operation_type="query",operation_name="Q\"1",result="partial"} 1`,
+		`graphql_proxy_graphql_errors_total{operation_type="query",operation_name="Q\"1",code="NOT_FOUND"} 1`,
+		`graphql_proxy_errors_total{class="upstream_5xx",operation_type="query",operation_name="Q\"1",upstream="http://a"} 1`,
+		`graphql_proxy_rate_limit_requests_total{rule="per-ip",key="192.0.2.1",operation_name="",result="limited"} 1`,
+		`graphql_proxy_upstream_requests_total{upstream="http://a"} 1`,
+		`graphql_proxy_upstream_healthy{upstream="http://a"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(out, "upstream_concurrency_limit{") {
+		t.Error("concurrency limit written for an upstream without one")
+	}
+}
+
+func TestSignatureBound(t *testing.T) {
+	m := New()
+	for i := 0; i < maxSignatures+500; i++ {
+		n := strconv.Itoa(i)
+		m.RecordRequest(OperationInfo{Type: "query", Name: "Q" + n, Hash: n}, time.Millisecond, true)
+	}
+	m.RecordRequest(OperationInfo{Type: "mutation", Name: "M", Hash: "m"}, time.Millisecond, true)
+
+	operations := m.Snapshot().Operations
+	if len(operations) != maxSignatures+2 {
+		t.Fatalf("got %d operations, want %d", len(operations), maxSignatures+2)
+	}
+	want := map[string]int64{"mutation": 1, "query": 500}
+	for _, op := range operations {
+		if op.Name == otherOperation && op.Total != want[op.Type] {
+			t.Errorf("counted %d %s requests under %q, want %d", op.Total, op.Type, otherOperation, want[op.Type])
+		}
+	}
+	if _, n := m.Latency(OperationInfo{Type: "query", Hash: "0"}, 0.5); n != 1 {
+		t.Errorf("latency estimated from %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxRateLimitKeys caps the keys rate limited requests are counted under, keys beyond it
+// are counted under rateLimitOther so that a flood of clients cannot exhaust memory.
+const (
+	maxRateLimitKeys = 1000
+	rateLimitOther   = "other"
+)
+
+type rateLimitKey struct {
+	rule      string
+	key       string
+	operation string
+}
+
+type rateLimitCounters struct {
+	mu       sync.RWMutex
+	counters map[rateLimitKey]*rateLimitCounter
+}
+
+type rateLimitCounter struct {
+	allowed atomic.Int64
+	limited atomic.Int64
+}
+
+func (c *rateLimitCounters) counter(k rateLimitKey) *rateLimitCounter {
+	c.mu.RLock()
+	counter, ok := c.counters[k]
+	c.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok = c.counters[k]; ok {
+		return counter
+	}
+	if c.counters == nil {
+		c.counters = make(map[rateLimitKey]*rateLimitCounter)
+	}
+	if len(c.counters) >= maxRateLimitKeys {
+		k.key, k.operation = rateLimitOther, ""
+		if counter, ok = c.counters[k]; ok {
+			return counter
+		}
+	}
+	counter = &rateLimitCounter{}
+	c.counters[k] = counter
+	return counter
+}
+
+// RateLimitSnapshot is the number of requests of a key, and of an operation when the rule
+// limits operations separately, that a rate limit rule allowed and limited.
+type RateLimitSnapshot struct {
+	Rule      string
+	Key       string
+	Operation string
+	Allowed   int64
+	Limited   int64
+}
+
+func (c *rateLimitCounters) snapshot() []RateLimitSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	limits := make([]RateLimitSnapshot, 0, len(c.counters))
+	for k, counter := range c.counters {
+		limits = append(limits, RateLimitSnapshot{
+			Rule:      k.rule,
+			Key:       k.key,
+			Operation: k.operation,
+			Allowed:   counter.allowed.Load(),
+			Limited:   counter.limited.Load(),
+		})
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		a, b := limits[i], limits[j]
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Operation < b.Operation
+	})
+	return limits
+}
+
+// RecordRateLimit records a request a rate limit rule counted under key and operation,
+// and whether it was limited.
+func (m *Metrics) RecordRateLimit(rule, key, operation string, limited bool) {
+	counter := m.rateLimits.counter(rateLimitKey{rule: rule, key: key, operation: operation})
+	if limited {
+		counter.limited.Add(1)
+	} else {
+		counter.allowed.Add(1)
+	}
+}
+
+// rateLimitStats returns the allowed and limited requests of every rule by key.
+func (m *Metrics) rateLimitStats() map[string]interface{} {
+	stats := make(map[string]interface{})
+	for _, l := range m.rateLimits.snapshot() {
+		keys, ok := stats[l.Rule].([]interface{})
+		if !ok {
+			keys = []interface{}{}
+		}
+		entry := map[string]interface{}{
+			"key":     l.Key,
+			"allowed": l.Allowed,
+			"limited": l.Limited,
+		}
+		if l.Operation != "" {
+			entry["operation"] = l.Operation
+		}
+		stats[l.Rule] = append(keys, entry)
+	}
+	return stats
+}
@@ -0,0 +1,26 @@
+package metrics
+
+// RecordRetry records that a request that failed on upstream with class was sent again.
+func (m *Metrics) RecordRetry(class ErrorClass, operation OperationInfo, upstream string) {
+	m.retries.add(errorKey{
+		class:         class,
+		operationType: operation.Type,
+		operationName: operation.Name,
+		upstream:      upstream,
+	})
+}
+
+// RecordRetryBudgetExhausted records a failed request that was not retried because the
+// retry budget was spent.
+func (m *Metrics) RecordRetryBudgetExhausted() {
+	m.retryBudgetExhausted.Add(1)
+}
+
+// retryStats returns the number of retries by the class of the failure that caused them,
+// broken down by operation and by the upstream that failed.
+func (m *Metrics) retryStats() map[string]interface{} {
+	return map[string]interface{}{
+		"classes":          classStats(m.retries.snapshot()),
+		"budget_exhausted": m.retryBudgetExhausted.Load(),
+	}
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot is a point in time copy of the metrics.
+type Snapshot struct {
+	StartTime      time.Time
+	Uptime         time.Duration
+	TotalRequests  int64
+	ActiveRequests int64
+	Operations     []OperationSnapshot
+	Upstreams      []UpstreamSnapshot
+	Errors         []ErrorSnapshot
+	// Retries counts retried requests by the class of the failure that caused them and the
+	// upstream that failed.
+	Retries              []ErrorSnapshot
+	RetryBudgetExhausted int64
+	HedgeBudgetExhausted int64
+	RateLimits           []RateLimitSnapshot
+}
+
+// OperationSnapshot holds the metrics of every operation with the same type and name.
+type OperationSnapshot struct {
+	Type     string
+	Name     string
+	Total    int64
+	Success  int64
+	Failed   int64
+	Duration HistogramSnapshot
+	// PartialResponses and FailedResponses count the responses with GraphQL errors,
+	// ErrorCodes the errors by code.
+	PartialResponses int64
+	FailedResponses  int64
+	ErrorCodes       map[string]int64
+	Hedges           int64
+	HedgesWon        int64
+}
+
+type UpstreamSnapshot struct {
+	URL     string
+	Total   int64
+	Success int64
+	Failed  int64
+	Latency HistogramSnapshot
+	// Checked is set once the health of the upstream is known.
+	Checked   bool
+	Healthy   bool
+	Ejected   bool
+	Ejections int64
+	// CircuitState is empty until the circuit breaker of the upstream first changed state.
+	CircuitState       string
+	CircuitTransitions map[string]int64
+	// ConcurrencyLimit is 0 for upstreams without a limit, which have no queue either.
+	ConcurrencyLimit int64
+	QueueDepth       int64
+	QueueWait        HistogramSnapshot
+	QueueTimeouts    int64
+	QueueRejected    int64
+}
+
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := Snapshot{
+		StartTime:      m.startTime,
+		Uptime:         time.Since(m.startTime),
+		TotalRequests:  m.totalRequests.Load(),
+		ActiveRequests: m.activeRequests.Load(),
+	}
+
+	type opKey struct{ typ, name string }
+	operations := make(map[opKey]*OperationSnapshot)
+	for _, metrics := range m.signatures {
+		k := opKey{metrics.Type, metrics.Name}
+		op, ok := operations[k]
+		if !ok {
+			op = &OperationSnapshot{Type: metrics.Type, Name: metrics.Name, ErrorCodes: make(map[string]int64)}
+			operations[k] = op
+		}
+		op.Total += metrics.TotalRequests.Load()
+		op.Success += metrics.SuccessRequests.Load()
+		op.Failed += metrics.FailedRequests.Load()
+		op.Duration.merge(metrics.Duration.Snapshot())
+		op.PartialResponses += metrics.GraphQLErrors.PartialResponses.Load()
+		op.FailedResponses += metrics.GraphQLErrors.FailedResponses.Load()
+		for code, n := range metrics.GraphQLErrors.Codes() {
+			op.ErrorCodes[code] += n
+		}
+		op.Hedges += metrics.Hedges.Load()
+		op.HedgesWon += metrics.HedgesWon.Load()
+	}
+	for _, op := range operations {
+		s.Operations = append(s.Operations, *op)
+	}
+	sort.Slice(s.Operations, func(i, j int) bool {
+		if s.Operations[i].Type != s.Operations[j].Type {
+			return s.Operations[i].Type < s.Operations[j].Type
+		}
+		return s.Operations[i].Name < s.Operations[j].Name
+	})
+
+	for url, metrics := range m.upstreams {
+		circuitState, circuitTransitions := metrics.Circuit()
+		s.Upstreams = append(s.Upstreams, UpstreamSnapshot{
+			URL:     url,
+			Total:   metrics.TotalRequests.Load(),
+			Success: metrics.SuccessRequests.Load(),
+			Failed:  metrics.FailedRequests.Load(),
+			Latency: metrics.Latency.Snapshot(),
+			Checked: metrics.Health.Load() != 0,
+			Healthy: metrics.Health.Load() == healthUp,
+
+			Ejected:   metrics.Ejected.Load(),
+			Ejections: metrics.Ejections.Load(),
+
+			CircuitState:       circuitState,
+			CircuitTransitions: circuitTransitions,
+
+			ConcurrencyLimit: metrics.ConcurrencyLimit.Load(),
+			QueueDepth:       metrics.QueueDepth.Load(),
+			QueueWait:        metrics.QueueWait.Snapshot(),
+			QueueTimeouts:    metrics.QueueTimeouts.Load(),
+			QueueRejected:    metrics.QueueRejected.Load(),
+		})
+	}
+	sort.Slice(s.Upstreams, func(i, j int) bool {
+		return s.Upstreams[i].URL < s.Upstreams[j].URL
+	})
+
+	s.Errors = m.errors.snapshot()
+	s.Retries = m.retries.snapshot()
+	s.RetryBudgetExhausted = m.retryBudgetExhausted.Load()
+	s.HedgeBudgetExhausted = m.hedgeBudgetExhausted.Load()
+	s.RateLimits = m.rateLimits.snapshot()
+
+	return s
+}
+
+// circuitStates returns the states the circuit breaker of the upstream entered, sorted.
+func (u UpstreamSnapshot) circuitStates() []string {
+	states := make([]string, 0, len(u.CircuitTransitions))
+	for state := range u.CircuitTransitions {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return states
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// windowSlots is the number of one second slots kept by a Window.
+const windowSlots = 15 * 60
+
+// windowDurations are the windows reported in the stats.
+var windowDurations = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+type windowSlot struct {
+	second   int64
+	requests int64
+	failures int64
+	sum      time.Duration
+	max      time.Duration
+	counts   [len(bucketBounds) + 1]int64
+}
+
+// Window counts requests and their latency in a ring of one second slots covering the
+// last 15 minutes. Latencies are counted in the buckets of Histogram so that windowed
+// percentiles are as precise as the ones since startup.
+type Window struct {
+	mu    sync.Mutex
+	slots [windowSlots]windowSlot
+}
+
+// WindowSummary describes the requests recorded over a recent period.
+type WindowSummary struct {
+	Requests   int64
+	Failures   int64
+	Rate       float64
+	ErrorRatio float64
+	Latency    HistogramSnapshot
+}
+
+func (w *Window) Record(now time.Time, latency time.Duration, success bool) {
+	second := now.Unix()
+	i := sort.Search(len(bucketBounds), func(i int) bool { return bucketBounds[i] >= latency })
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	slot := &w.slots[second%windowSlots]
+	if slot.second != second {
+		*slot = windowSlot{second: second}
+	}
+	slot.requests++
+	if !success {
+		slot.failures++
+	}
+	slot.sum += latency
+	if latency > slot.max {
+		slot.max = latency
+	}
+	slot.counts[i]++
+}
+
+// Summary returns the requests recorded in the period d before now.
+func (w *Window) Summary(now time.Time, d time.Duration) WindowSummary {
+	newest := now.Unix()
+	oldest := newest - int64(d/time.Second) + 1
+
+	s := WindowSummary{
+		Latency: HistogramSnapshot{
+			Bounds: bucketBounds[:],
+			Counts: make([]int64, len(bucketBounds)+1),
+		},
+	}
+
+	w.mu.Lock()
+	for i := range w.slots {
+		slot := &w.slots[i]
+		if slot.second < oldest || slot.second > newest {
+			continue
+		}
+		s.Requests += slot.requests
+		s.Failures += slot.failures
+		s.Latency.Sum += slot.sum
+		if slot.max > s.Latency.Max {
+			s.Latency.Max = slot.max
+		}
+		for j, c := range slot.counts {
+			s.Latency.Counts[j] += c
+		}
+	}
+	w.mu.Unlock()
+
+	s.Latency.Count = s.Requests
+	s.Rate = float64(s.Requests) / d.Seconds()
+	if s.Requests > 0 {
+		s.ErrorRatio = float64(s.Failures) / float64(s.Requests)
+	}
+
+	return s
+}
+
+// windowStats returns the summaries of every reported window.
+func windowStats(w *Window, now time.Time) map[string]interface{} {
+	stats := make(map[string]interface{}, len(windowDurations))
+	for _, wd := range windowDurations {
+		s := w.Summary(now, wd.duration)
+		stats[wd.name] = map[string]interface{}{
+			"requests":    s.Requests,
+			"failed":      s.Failures,
+			"rate":        s.Rate,
+			"error_ratio": s.ErrorRatio,
+			"avg_latency": milliseconds(s.Latency.Mean()),
+			"latency":     latencyStats(s.Latency),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowSummary(t *testing.T) {
+	var w Window
+	now := time.Unix(1700000000, 0)
+
+	// Ten minutes ago, outside the one and five minute windows.
+	w.Record(now.Add(-10*time.Minute), time.Second, false)
+	for i := 0; i < 100; i++ {
+		latency := 110 * time.Millisecond
+		if i >= 90 {
+			latency = 240 * time.Millisecond
+		}
+		w.Record(now.Add(-time.Duration(i%30)*time.Second), latency, i%10 != 0)
+	}
+
+	testCases := []struct {
+		d        time.Duration
+		requests int64
+		failures int64
+		max      time.Duration
+	}{
+		{time.Minute, 100, 10, 240 * time.Millisecond},
+		{5 * time.Minute, 100, 10, 240 * time.Millisecond},
+		{15 * time.Minute, 101, 11, time.Second},
+	}
+	for _, tC := range testCases {
+		s := w.Summary(now, tC.d)
+		if s.Requests != tC.requests || s.Failures != tC.failures || s.Latency.Count != tC.requests || s.Latency.Max != tC.max {
+			t.Errorf("%v: got %d requests, %d failures, max %v", tC.d, s.Requests, s.Failures, s.Latency.Max)
+		}
+		if want := float64(tC.requests) / tC.d.Seconds(); s.Rate != want {
+			t.Errorf("%v: rate %v, want %v", tC.d, s.Rate, want)
+		}
+		if want := float64(tC.failures) / float64(tC.requests); s.ErrorRatio != want {
+			t.Errorf("%v: error ratio %v, want %v", tC.d, s.ErrorRatio, want)
+		}
+	}
+
+	// Both latencies fall into the same exported bucket, the percentiles must tell them apart.
+	s := w.Summary(now, time.Minute)
+	for q, want := range map[float64]time.Duration{0.5: 110 * time.Millisecond, 0.99: 240 * time.Millisecond} {
+		if got := s.Latency.Quantile(q); got < want*9/10 || got > want*11/10 {
+			t.Errorf("q%v: got %v, want about %v", q, got, want)
+		}
+	}
+}
+
+func TestWindowReusesSlots(t *testing.T) {
+	var w Window
+	now := time.Unix(1700000000, 0)
+	w.Record(now.Add(-windowSlots*time.Second), time.Millisecond, false)
+	w.Record(now, 2*time.Millisecond, true)
+
+	s := w.Summary(now, 15*time.Minute)
+	if s.Requests != 1 || s.Failures != 0 || s.Latency.Max != 2*time.Millisecond {
+		t.Errorf("got %d requests, %d failures, max %v", s.Requests, s.Failures, s.Latency.Max)
+	}
+}
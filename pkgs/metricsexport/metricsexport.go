@@ -0,0 +1,408 @@
+package metricsexport
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+// Start periodically pushes the metrics to an OTLP collector. Failed exports are retried
+// with exponential backoff. The returned function pushes the metrics one last time and
+// stops the exporter.
+func Start(ctx context.Context, cfg config.MetricsExportConfig, m *metrics.Metrics) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating metrics exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.ServiceVersion),
+		semconv.ServiceInstanceID(cfg.ServiceInstanceID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("creating metrics resource: %w", err)
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(cfg.Interval),
+		sdkmetric.WithProducer(&producer{metrics: m}),
+	)
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res),
+	)
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg config.MetricsExportConfig) (sdkmetric.Exporter, error) {
+	switch cfg.Protocol {
+	case "grpc":
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(cfg.Endpoint),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig{
+				Enabled:         true,
+				InitialInterval: cfg.Retry.InitialInterval,
+				MaxInterval:     cfg.Retry.MaxInterval,
+				MaxElapsedTime:  cfg.Retry.MaxElapsedTime,
+			}),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	default:
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(cfg.Endpoint),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig{
+				Enabled:         true,
+				InitialInterval: cfg.Retry.InitialInterval,
+				MaxInterval:     cfg.Retry.MaxInterval,
+				MaxElapsedTime:  cfg.Retry.MaxElapsedTime,
+			}),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+}
+
+// producer converts a snapshot of the metrics into OTLP metric data. Counters and
+// histograms are cumulative since the start of the proxy.
+type producer struct {
+	metrics *metrics.Metrics
+}
+
+func (p *producer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	s := p.metrics.Snapshot()
+	now := time.Now()
+
+	requests := counter()
+	requestErrors := counter()
+	duration := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+	errorResponses := counter()
+	graphqlErrors := counter()
+	hedges := counter()
+	hedgesWon := counter()
+	for _, op := range s.Operations {
+		attrs := attribute.NewSet(
+			semconv.GraphQLOperationTypeKey.String(op.Type),
+			semconv.GraphQLOperationName(op.Name),
+		)
+		requests.DataPoints = append(requests.DataPoints, point(attrs, s.StartTime, now, op.Total))
+		requestErrors.DataPoints = append(requestErrors.DataPoints, point(attrs, s.StartTime, now, op.Failed))
+		duration.DataPoints = append(duration.DataPoints, histogramPoint(attrs, s.StartTime, now, op.Duration))
+		hedges.DataPoints = append(hedges.DataPoints, point(attrs, s.StartTime, now, op.Hedges))
+		hedgesWon.DataPoints = append(hedgesWon.DataPoints, point(attrs, s.StartTime, now, op.HedgesWon))
+
+		for result, n := range map[string]int64{"partial": op.PartialResponses, "failed": op.FailedResponses} {
+			resultAttrs := attribute.NewSet(append(attrs.ToSlice(), attribute.String("result", result))...)
+			errorResponses.DataPoints = append(errorResponses.DataPoints, point(resultAttrs, s.StartTime, now, n))
+		}
+		for code, n := range op.ErrorCodes {
+			codeAttrs := attribute.NewSet(append(attrs.ToSlice(), attribute.String("code", code))...)
+			graphqlErrors.DataPoints = append(graphqlErrors.DataPoints, point(codeAttrs, s.StartTime, now, n))
+		}
+	}
+
+	failures := counter()
+	for _, e := range s.Errors {
+		attrs := attribute.NewSet(
+			attribute.String("error.class", string(e.Class)),
+			semconv.GraphQLOperationTypeKey.String(e.OperationType),
+			semconv.GraphQLOperationName(e.OperationName),
+			attribute.String("upstream", e.Upstream),
+		)
+		failures.DataPoints = append(failures.DataPoints, point(attrs, s.StartTime, now, e.Count))
+	}
+
+	retries := counter()
+	for _, e := range s.Retries {
+		attrs := attribute.NewSet(
+			attribute.String("error.class", string(e.Class)),
+			semconv.GraphQLOperationTypeKey.String(e.OperationType),
+			semconv.GraphQLOperationName(e.OperationName),
+			attribute.String("upstream", e.Upstream),
+		)
+		retries.DataPoints = append(retries.DataPoints, point(attrs, s.StartTime, now, e.Count))
+	}
+	retryBudgetExhausted := counter()
+	retryBudgetExhausted.DataPoints = append(retryBudgetExhausted.DataPoints, point(*attribute.EmptySet(), s.StartTime, now, s.RetryBudgetExhausted))
+	hedgeBudgetExhausted := counter()
+	hedgeBudgetExhausted.DataPoints = append(hedgeBudgetExhausted.DataPoints, point(*attribute.EmptySet(), s.StartTime, now, s.HedgeBudgetExhausted))
+
+	rateLimits := counter()
+	for _, l := range s.RateLimits {
+		for result, n := range map[string]int64{"allowed": l.Allowed, "limited": l.Limited} {
+			attrs := attribute.NewSet(
+				attribute.String("rule", l.Rule),
+				attribute.String("key", l.Key),
+				semconv.GraphQLOperationName(l.Operation),
+				attribute.String("result", result),
+			)
+			rateLimits.DataPoints = append(rateLimits.DataPoints, point(attrs, s.StartTime, now, n))
+		}
+	}
+
+	upstreamRequests := counter()
+	upstreamErrors := counter()
+	latency := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+	var healthy, ejected, circuitState metricdata.Gauge[int64]
+	ejections := counter()
+	circuitTransitions := counter()
+	var concurrencyLimit, queueDepth metricdata.Gauge[int64]
+	queueTimeouts := counter()
+	queueRejected := counter()
+	queueWait := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+	for _, up := range s.Upstreams {
+		for state, n := range up.CircuitTransitions {
+			stateAttrs := attribute.NewSet(attribute.String("upstream", up.URL), attribute.String("state", state))
+			circuitState.DataPoints = append(circuitState.DataPoints, metricdata.DataPoint[int64]{Attributes: stateAttrs, Time: now, Value: boolValue(state == up.CircuitState)})
+			circuitTransitions.DataPoints = append(circuitTransitions.DataPoints, point(stateAttrs, s.StartTime, now, n))
+		}
+		attrs := attribute.NewSet(attribute.String("upstream", up.URL))
+		ejected.DataPoints = append(ejected.DataPoints, metricdata.DataPoint[int64]{Attributes: attrs, Time: now, Value: boolValue(up.Ejected)})
+		ejections.DataPoints = append(ejections.DataPoints, point(attrs, s.StartTime, now, up.Ejections))
+		if up.Checked {
+			healthy.DataPoints = append(healthy.DataPoints, metricdata.DataPoint[int64]{Attributes: attrs, Time: now, Value: boolValue(up.Healthy)})
+		}
+		upstreamRequests.DataPoints = append(upstreamRequests.DataPoints, point(attrs, s.StartTime, now, up.Total))
+		upstreamErrors.DataPoints = append(upstreamErrors.DataPoints, point(attrs, s.StartTime, now, up.Failed))
+		latency.DataPoints = append(latency.DataPoints, histogramPoint(attrs, s.StartTime, now, up.Latency))
+		if up.ConcurrencyLimit > 0 {
+			concurrencyLimit.DataPoints = append(concurrencyLimit.DataPoints, metricdata.DataPoint[int64]{Attributes: attrs, Time: now, Value: up.ConcurrencyLimit})
+			queueDepth.DataPoints = append(queueDepth.DataPoints, metricdata.DataPoint[int64]{Attributes: attrs, Time: now, Value: up.QueueDepth})
+			queueTimeouts.DataPoints = append(queueTimeouts.DataPoints, point(attrs, s.StartTime, now, up.QueueTimeouts))
+			queueRejected.DataPoints = append(queueRejected.DataPoints, point(attrs, s.StartTime, now, up.QueueRejected))
+			queueWait.DataPoints = append(queueWait.DataPoints, histogramPoint(attrs, s.StartTime, now, up.QueueWait))
+		}
+	}
+
+	return []metricdata.ScopeMetrics{{
+		Scope: instrumentation.Scope{Name: "github.com/abdullah2993/graphql-proxy/pkgs/metrics"},
+		Metrics: []metricdata.Metrics{
+			{
+				Name:        "graphql_proxy.active_requests",
+				Description: "Number of requests currently being proxied.",
+				Unit:        "{request}",
+				Data: metricdata.Gauge[int64]{DataPoints: []metricdata.DataPoint[int64]{
+					{Time: now, Value: s.ActiveRequests},
+				}},
+			},
+			{
+				Name:        "graphql_proxy.requests",
+				Description: "Total number of GraphQL requests.",
+				Unit:        "{request}",
+				Data:        requests,
+			},
+			{
+				Name:        "graphql_proxy.request_errors",
+				Description: "Total number of failed GraphQL requests.",
+				Unit:        "{request}",
+				Data:        requestErrors,
+			},
+			{
+				Name:        "graphql_proxy.request_duration",
+				Description: "Duration of GraphQL requests.",
+				Unit:        "s",
+				Data:        duration,
+			},
+			{
+				Name:        "graphql_proxy.graphql_error_responses",
+				Description: "Total number of responses with GraphQL errors, with (partial) or without data (failed).",
+				Unit:        "{response}",
+				Data:        errorResponses,
+			},
+			{
+				Name:        "graphql_proxy.graphql_errors",
+				Description: "Total number of GraphQL errors by extensions.code.",
+				Unit:        "{error}",
+				Data:        graphqlErrors,
+			},
+			{
+				Name:        "graphql_proxy.hedged_requests",
+				Description: "Total number of requests a hedge was sent for.",
+				Unit:        "{request}",
+				Data:        hedges,
+			},
+			{
+				Name:        "graphql_proxy.hedge_wins",
+				Description: "Total number of hedged requests the hedge answered first.",
+				Unit:        "{request}",
+				Data:        hedgesWon,
+			},
+			{
+				Name:        "graphql_proxy.hedge_budget_exhausted",
+				Description: "Total number of requests not hedged because the hedging budget was spent.",
+				Unit:        "{request}",
+				Data:        hedgeBudgetExhausted,
+			},
+			{
+				Name:        "graphql_proxy.errors",
+				Description: "Total number of failed requests by class.",
+				Unit:        "{request}",
+				Data:        failures,
+			},
+			{
+				Name:        "graphql_proxy.retries",
+				Description: "Total number of retried requests by the class of the failure.",
+				Unit:        "{request}",
+				Data:        retries,
+			},
+			{
+				Name:        "graphql_proxy.retry_budget_exhausted",
+				Description: "Total number of failed requests not retried because the retry budget was spent.",
+				Unit:        "{request}",
+				Data:        retryBudgetExhausted,
+			},
+			{
+				Name:        "graphql_proxy.rate_limit_requests",
+				Description: "Total number of requests counted by a rate limit rule by whether they were limited.",
+				Unit:        "{request}",
+				Data:        rateLimits,
+			},
+			{
+				Name:        "graphql_proxy.upstream_requests",
+				Description: "Total number of requests sent to upstreams.",
+				Unit:        "{request}",
+				Data:        upstreamRequests,
+			},
+			{
+				Name:        "graphql_proxy.upstream_errors",
+				Description: "Total number of failed requests sent to upstreams.",
+				Unit:        "{request}",
+				Data:        upstreamErrors,
+			},
+			{
+				Name:        "graphql_proxy.upstream_healthy",
+				Description: "Whether the upstream passes its health checks.",
+				Unit:        "1",
+				Data:        healthy,
+			},
+			{
+				Name:        "graphql_proxy.upstream_ejected",
+				Description: "Whether the upstream is ejected for failing requests.",
+				Unit:        "1",
+				Data:        ejected,
+			},
+			{
+				Name:        "graphql_proxy.upstream_ejections",
+				Description: "Total number of times the upstream was ejected.",
+				Unit:        "{ejection}",
+				Data:        ejections,
+			},
+			{
+				Name:        "graphql_proxy.upstream_circuit_state",
+				Description: "Whether the circuit breaker of the upstream is in the state.",
+				Unit:        "1",
+				Data:        circuitState,
+			},
+			{
+				Name:        "graphql_proxy.upstream_circuit_transitions",
+				Description: "Total number of times the circuit breaker of the upstream entered the state.",
+				Unit:        "{transition}",
+				Data:        circuitTransitions,
+			},
+			{
+				Name:        "graphql_proxy.upstream_concurrency_limit",
+				Description: "Number of requests the upstream may have in flight.",
+				Unit:        "{request}",
+				Data:        concurrencyLimit,
+			},
+			{
+				Name:        "graphql_proxy.upstream_queue_depth",
+				Description: "Number of requests waiting for the upstream.",
+				Unit:        "{request}",
+				Data:        queueDepth,
+			},
+			{
+				Name:        "graphql_proxy.upstream_queue_timeouts",
+				Description: "Total number of requests that timed out waiting for the upstream.",
+				Unit:        "{request}",
+				Data:        queueTimeouts,
+			},
+			{
+				Name:        "graphql_proxy.upstream_queue_rejected",
+				Description: "Total number of requests rejected because the queue of the upstream was full.",
+				Unit:        "{request}",
+				Data:        queueRejected,
+			},
+			{
+				Name:        "graphql_proxy.upstream_queue_wait",
+				Description: "Time requests waited for the upstream.",
+				Unit:        "s",
+				Data:        queueWait,
+			},
+			{
+				Name:        "graphql_proxy.upstream_request_duration",
+				Description: "Latency of requests sent to upstreams.",
+				Unit:        "s",
+				Data:        latency,
+			},
+		},
+	}}, nil
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func counter() metricdata.Sum[int64] {
+	return metricdata.Sum[int64]{
+		Temporality: metricdata.CumulativeTemporality,
+		IsMonotonic: true,
+	}
+}
+
+func point(attrs attribute.Set, start, now time.Time, value int64) metricdata.DataPoint[int64] {
+	return metricdata.DataPoint[int64]{
+		Attributes: attrs,
+		StartTime:  start,
+		Time:       now,
+		Value:      value,
+	}
+}
+
+func histogramPoint(attrs attribute.Set, start, now time.Time, h metrics.HistogramSnapshot) metricdata.HistogramDataPoint[float64] {
+	h = h.Export()
+
+	dp := metricdata.HistogramDataPoint[float64]{
+		Attributes:   attrs,
+		StartTime:    start,
+		Time:         now,
+		Count:        uint64(h.Count),
+		Sum:          h.Sum.Seconds(),
+		Bounds:       make([]float64, len(h.Bounds)),
+		BucketCounts: make([]uint64, len(h.Counts)),
+		Max:          metricdata.NewExtrema(h.Max.Seconds()),
+	}
+	for i, b := range h.Bounds {
+		dp.Bounds[i] = b.Seconds()
+	}
+	for i, c := range h.Counts {
+		dp.BucketCounts[i] = uint64(c)
+	}
+	return dp
+}
@@ -0,0 +1,113 @@
+package metricsexport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+func TestProduce(t *testing.T) {
+	m := metrics.New()
+	query := metrics.OperationInfo{Type: "query", Name: "Q", Hash: "q"}
+	m.RecordRequest(query, 3*time.Millisecond, true)
+	m.RecordRequest(query, 40*time.Millisecond, false)
+	m.RecordGraphQLErrors(query, false, []string{"NOT_FOUND"})
+	m.RecordError(metrics.ClassUpstream5xx, query, "http://a")
+	m.RecordUpstreamRequest("http://a", 2*time.Millisecond, true)
+	m.SetUpstreamHealth("http://a", false)
+
+	scopes, err := (&producer{metrics: m}).Produce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 1 {
+		t.Fatalf("got %d scopes", len(scopes))
+	}
+	byName := make(map[string]metricdata.Aggregation)
+	for _, metric := range scopes[0].Metrics {
+		byName[metric.Name] = metric.Data
+	}
+
+	operation := attribute.NewSet(attribute.String("graphql.operation.type", "query"), attribute.String("graphql.operation.name", "Q"))
+	upstream := attribute.NewSet(attribute.String("upstream", "http://a"))
+	sums := []struct {
+		name  string
+		attrs attribute.Set
+		value int64
+	}{
+		{"graphql_proxy.requests", operation, 2},
+		{"graphql_proxy.request_errors", operation, 1},
+		{"graphql_proxy.graphql_errors", attribute.NewSet(append(operation.ToSlice(), attribute.String("code", "NOT_FOUND"))...), 1},
+		{"graphql_proxy.graphql_error_responses", attribute.NewSet(append(operation.ToSlice(), attribute.String("result", "failed"))...), 1},
+		{"graphql_proxy.errors", attribute.NewSet(
+			attribute.String("error.class", "upstream_5xx"),
+			attribute.String("graphql.operation.type", "query"),
+			attribute.String("graphql.operation.name", "Q"),
+			attribute.String("upstream", "http://a"),
+		), 1},
+		{"graphql_proxy.upstream_requests", upstream, 1},
+	}
+	for _, want := range sums {
+		sum, ok := byName[want.name].(metricdata.Sum[int64])
+		if !ok || !sum.IsMonotonic || sum.Temporality != metricdata.CumulativeTemporality {
+			t.Errorf("%s is not a cumulative counter: %#v", want.name, byName[want.name])
+			continue
+		}
+		if got, ok := value(sum.DataPoints, want.attrs); !ok || got != want.value {
+			t.Errorf("%s{%s} = %d, want %d", want.name, want.attrs.Encoded(attribute.DefaultEncoder()), got, want.value)
+		}
+	}
+
+	healthy := byName["graphql_proxy.upstream_healthy"].(metricdata.Gauge[int64])
+	if got, ok := value(healthy.DataPoints, upstream); !ok || got != 0 {
+		t.Errorf("upstream_healthy = %d, %v", got, ok)
+	}
+	if limits := byName["graphql_proxy.upstream_concurrency_limit"].(metricdata.Gauge[int64]); len(limits.DataPoints) != 0 {
+		t.Errorf("concurrency limit exported for an upstream without one: %+v", limits.DataPoints)
+	}
+
+	duration := byName["graphql_proxy.request_duration"].(metricdata.Histogram[float64])
+	if len(duration.DataPoints) != 1 {
+		t.Fatalf("got %d duration points", len(duration.DataPoints))
+	}
+	dp := duration.DataPoints[0]
+	if dp.Count != 2 || dp.Sum != 0.043 || len(dp.BucketCounts) != len(dp.Bounds)+1 {
+		t.Fatalf("duration point %+v", dp)
+	}
+	var total uint64
+	for i, c := range dp.BucketCounts {
+		total += c
+		if i < len(dp.Bounds) && dp.Bounds[i] == 0.005 && total != 1 {
+			t.Errorf("%d requests up to 5ms, want 1", total)
+		}
+	}
+	if max, ok := dp.Max.Value(); total != 2 || !ok || max != 0.04 {
+		t.Errorf("bucket total %d, max %v", total, max)
+	}
+}
+
+// value returns the value of the data point with attrs.
+func value(points []metricdata.DataPoint[int64], attrs attribute.Set) (int64, bool) {
+	for _, p := range points {
+		if p.Attributes.Equals(&attrs) {
+			return p.Value, true
+		}
+	}
+	return 0, false
+}
+
+func TestStartDisabled(t *testing.T) {
+	shutdown, err := Start(context.Background(), config.MetricsExportConfig{}, metrics.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// budgetWindow is how many seconds of its minimum rate a budget can save.
+const budgetWindow = 10
+
+// budget limits extra requests sent to upstreams, retries and hedges, to a share of the
+// requests plus a minimum number per second, so that they cannot multiply the load on
+// upstreams that are already struggling. A budget starts with a second of its minimum
+// rate, so that a budget without one allows no extra request before the requests it is a
+// share of.
+type budget struct {
+	ratio     float64
+	perSecond float64
+	max       float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBudget(ratio, perSecond float64) *budget {
+	max := math.Max(perSecond, 1) * budgetWindow
+	return &budget{
+		ratio:     ratio,
+		perSecond: perSecond,
+		max:       max,
+		tokens:    perSecond,
+		last:      time.Now(),
+	}
+}
+
+// deposit records a request that extra requests may be sent for.
+func (b *budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = math.Min(b.tokens+b.ratio, b.max)
+}
+
+// withdraw takes an extra request from the budget and reports whether there was one.
+func (b *budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *budget) refill(now time.Time) {
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*b.perSecond, b.max)
+	b.last = now
+}
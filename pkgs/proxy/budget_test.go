@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	b := newBudget(0.5, 0)
+	if b.withdraw() {
+		t.Fatal("extra request allowed before any request")
+	}
+
+	for i := 0; i < 4; i++ {
+		b.deposit()
+	}
+	for i := 0; i < 2; i++ {
+		if !b.withdraw() {
+			t.Fatalf("extra request %d refused after 4 requests with ratio 0.5", i+1)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("third extra request allowed after 4 requests with ratio 0.5")
+	}
+
+	b = newBudget(0, 10)
+	for i := 0; i < 10; i++ {
+		if !b.withdraw() {
+			t.Fatalf("extra request %d refused at start with 10 per second", i+1)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("11th extra request allowed at start with 10 per second")
+	}
+	b.last = time.Now().Add(-200 * time.Millisecond)
+	if !b.withdraw() {
+		t.Fatal("extra request refused after 200ms at 10 per second")
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/loadbalancer"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+// hedgePolicy decides whether and when a query that has not been answered yet is sent to
+// a second upstream. A nil hedgePolicy never hedges.
+type hedgePolicy struct {
+	cfg        config.HedgingConfig
+	operations map[string]bool
+	budget     *budget
+}
+
+func newHedgePolicy(cfg config.HedgingConfig) *hedgePolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	var operations map[string]bool
+	if len(cfg.Operations) > 0 {
+		operations = make(map[string]bool, len(cfg.Operations))
+		for _, name := range cfg.Operations {
+			operations[name] = true
+		}
+	}
+	return &hedgePolicy{
+		cfg:        cfg,
+		operations: operations,
+		budget:     newBudget(cfg.BudgetPercent/100, 0),
+	}
+}
+
+// delay returns how long to wait for an answer before hedging the operation, and false
+// when it is not hedged. Without a fixed delay the operation is only hedged once its
+// latency was observed MinSamples times.
+func (hp *hedgePolicy) delay(operation metrics.OperationInfo, m *metrics.Metrics) (time.Duration, bool) {
+	if hp == nil || operation.Type != string(config.CapabilityQuery) {
+		return 0, false
+	}
+	if hp.operations != nil && !hp.operations[operation.Name] {
+		return 0, false
+	}
+	if hp.cfg.Delay > 0 {
+		return hp.cfg.Delay, true
+	}
+	latency, samples := m.Latency(operation, hp.cfg.Percentile)
+	if samples < int64(hp.cfg.MinSamples) {
+		return 0, false
+	}
+	return max(latency, hp.cfg.MinDelay), true
+}
+
+// sendHedged sends the request to server and, when it has not answered within delay, a
+// copy to another upstream selected for route. The first successful response is used
+// and the other request is canceled. Upstreams the request was sent to are added to the
+// excluded upstreams of route. Every upstream but the one of the returned attempt is
+// reported to the load balancer.
+func (p *Proxy) sendHedged(ctx context.Context, r *http.Request, ex *exchange, route *loadbalancer.Route, server *loadbalancer.Upstream, body []byte, delay time.Duration, logger *slog.Logger) *attempt {
+	p.hedge.budget.deposit()
+
+	// Each request gets a context of its own so that the slower one can be canceled while
+	// it is still waiting for its upstream.
+	results := make(chan *attempt, 2)
+	cancels := make(map[*loadbalancer.Upstream]context.CancelFunc, 2)
+	send := func(u *loadbalancer.Upstream) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancels[u] = cancel
+		go func() {
+			a := p.sendUpstream(ctx, r, ex.requestID, 0, u, body)
+			release := a.cancel
+			a.cancel = func() {
+				release()
+				cancel()
+			}
+			results <- a
+		}()
+	}
+	send(server)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case a := <-results:
+		return a
+	case <-timer.C:
+	}
+
+	route.Exclude = append(route.Exclude, server)
+	hedge, err := p.selectServer(ctx, *route, false)
+	if err != nil {
+		logger.DebugContext(ctx, "no upstream to hedge on", "upstream", server.URL, "error", err)
+		return <-results
+	}
+	if !p.hedge.budget.withdraw() {
+		p.lb.Report(hedge, loadbalancer.Canceled, 0)
+		p.metrics.RecordHedgeBudgetExhausted()
+		logger.DebugContext(ctx, "hedging budget exhausted", "upstream", server.URL)
+		return <-results
+	}
+
+	logger.DebugContext(ctx, "hedging request", "upstream", server.URL, "hedge_upstream", hedge.URL, "delay", delay)
+	route.Exclude = append(route.Exclude, hedge)
+	send(hedge)
+
+	first := <-results
+	winner, loser := first, (*attempt)(nil)
+	if first.failure() != "" {
+		// The request that answers second wins if the first one failed, it still counts as
+		// failed if both did.
+		second := <-results
+		if second.failure() == "" {
+			winner, loser = second, first
+		} else {
+			loser = second
+		}
+	}
+	p.metrics.RecordHedge(ex.operation, winner.server == hedge)
+
+	if loser != nil {
+		outcome := loser.outcome()
+		if outcome == loadbalancer.Failure {
+			p.metrics.RecordUpstreamRequest(loser.server.URL, loser.latency, false)
+		}
+		p.lb.Report(loser.server, outcome, loser.latency)
+		loser.discard()
+		return winner
+	}
+
+	// The request that did not answer yet is canceled, it says nothing about its upstream.
+	slower := hedge
+	if winner.server == hedge {
+		slower = server
+	}
+	cancels[slower]()
+	go func() {
+		loser := <-results
+		loser.discard()
+		p.lb.Report(loser.server, loadbalancer.Canceled, 0)
+	}()
+	return winner
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+func TestHedgeDelay(t *testing.T) {
+	m := metrics.New()
+	observed := metrics.OperationInfo{Type: "query", Name: "Observed", Hash: "observed"}
+	for i := 0; i < 10; i++ {
+		m.RecordRequest(observed, 50*time.Millisecond, true)
+	}
+
+	hedging := config.HedgingConfig{
+		Enabled:       true,
+		Percentile:    0.95,
+		MinDelay:      10 * time.Millisecond,
+		MinSamples:    10,
+		BudgetPercent: 10,
+	}
+	fixed := hedging
+	fixed.Delay = 20 * time.Millisecond
+	named := fixed
+	named.Operations = []string{"Observed"}
+
+	tests := []struct {
+		name      string
+		cfg       config.HedgingConfig
+		operation metrics.OperationInfo
+		want      bool
+		min, max  time.Duration
+	}{
+		{"disabled", config.HedgingConfig{}, observed, false, 0, 0},
+		{"fixed delay", fixed, metrics.OperationInfo{Type: "query", Hash: "new"}, true, 20 * time.Millisecond, 20 * time.Millisecond},
+		{"mutation", fixed, metrics.OperationInfo{Type: "mutation", Hash: "new"}, false, 0, 0},
+		{"observed latency", hedging, observed, true, 40 * time.Millisecond, 50 * time.Millisecond},
+		{"too few samples", hedging, metrics.OperationInfo{Type: "query", Hash: "new"}, false, 0, 0},
+		{"listed operation", named, observed, true, 20 * time.Millisecond, 20 * time.Millisecond},
+		{"unlisted operation", named, metrics.OperationInfo{Type: "query", Name: "Other", Hash: "other"}, false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := newHedgePolicy(tt.cfg).delay(tt.operation, m)
+			if ok != tt.want || d < tt.min || d > tt.max {
+				t.Errorf("delay() = %s, %v, want between %s and %s, %v", d, ok, tt.min, tt.max, tt.want)
+			}
+		})
+	}
+}
+
+// hedgedUpstreams returns two upstreams that answer the second request they get right
+// away with fast, and the first one, slow, only after delay or once it was canceled,
+// which is reported on canceled.
+func hedgedUpstreams(t *testing.T, delay time.Duration, slow, fast int) (string, chan struct{}) {
+	t.Helper()
+	var requests atomic.Int32
+	canceled := make(chan struct{}, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices that the client went away once the body was read.
+		io.Copy(io.Discard, r.Body)
+		status := fast
+		if requests.Add(1) == 1 {
+			status = slow
+			select {
+			case <-r.Context().Done():
+				canceled <- struct{}{}
+				return
+			case <-time.After(delay):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"data":{"status":%d}}`, status)
+	}))
+	t.Cleanup(s.Close)
+	return `
+upstreams:
+  - url: ` + s.URL + `/a
+    weight: 1
+    capabilities: [query]
+  - url: ` + s.URL + `/b
+    weight: 1
+    capabilities: [query]
+hedging:
+  enabled: true
+  delay: 20ms
+  budget_percent: 100
+circuit_breaker:
+  enabled: true
+  failure_threshold: 1
+`, canceled
+}
+
+// settled waits until no request to the upstreams is outstanding.
+func settled(t *testing.T, p *Proxy) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for _, u := range p.LoadBalancer().Upstreams() {
+		for u.Outstanding() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("request to %s still outstanding", u.URL)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestHedging(t *testing.T) {
+	t.Run("first response wins", func(t *testing.T) {
+		cfg, canceled := hedgedUpstreams(t, 10*time.Second, http.StatusOK, http.StatusOK)
+		p := newTestProxy(t, cfg)
+
+		start := time.Now()
+		w := query(p, "{ status }")
+		if w.Code != http.StatusOK || time.Since(start) > 5*time.Second {
+			t.Fatalf("status %d after %s", w.Code, time.Since(start))
+		}
+		select {
+		case <-canceled:
+		case <-time.After(2 * time.Second):
+			t.Fatal("slower request not canceled")
+		}
+		settled(t, p)
+		for _, u := range p.LoadBalancer().Upstreams() {
+			if u.Circuit() != "closed" {
+				t.Errorf("circuit of %s is %s, the canceled request counted as a failure", u.URL, u.Circuit())
+			}
+		}
+	})
+
+	t.Run("both fail", func(t *testing.T) {
+		cfg, _ := hedgedUpstreams(t, 50*time.Millisecond, http.StatusInternalServerError, http.StatusBadGateway)
+		p := newTestProxy(t, cfg)
+
+		if w := query(p, "{ status }"); w.Code < 500 {
+			t.Fatalf("status %d, want a failure", w.Code)
+		}
+		settled(t, p)
+		for _, u := range p.LoadBalancer().Upstreams() {
+			if u.Circuit() != "open" {
+				t.Errorf("circuit of %s is %s, want both failures counted", u.URL, u.Circuit())
+			}
+		}
+	})
+
+	t.Run("budget starts empty", func(t *testing.T) {
+		cfg, _ := hedgedUpstreams(t, 50*time.Millisecond, http.StatusOK, http.StatusOK)
+		p := newTestProxy(t, strings.Replace(cfg, "budget_percent: 100", "budget_percent: 50", 1))
+
+		if w := query(p, "{ status }"); w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		settled(t, p)
+		if hedges := p.Metrics().Snapshot().HedgeBudgetExhausted; hedges != 1 {
+			t.Errorf("hedges refused = %d, want the first query not hedged", hedges)
+		}
+	})
+}
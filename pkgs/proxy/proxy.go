@@ -2,29 +2,86 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/abdullah2993/graphql-proxy/pkgs/accesslog"
 	"github.com/abdullah2993/graphql-proxy/pkgs/config"
 	"github.com/abdullah2993/graphql-proxy/pkgs/graphql"
+	"github.com/abdullah2993/graphql-proxy/pkgs/identity"
 	"github.com/abdullah2993/graphql-proxy/pkgs/loadbalancer"
+	"github.com/abdullah2993/graphql-proxy/pkgs/logging"
 	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+	"github.com/abdullah2993/graphql-proxy/pkgs/ratelimit"
+	"github.com/abdullah2993/graphql-proxy/pkgs/requestid"
+	"github.com/abdullah2993/graphql-proxy/pkgs/usage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Proxy struct {
-	lb      *loadbalancer.LoadBalancer
-	logger  *slog.Logger
-	client  *http.Client
-	metrics *metrics.Metrics
+	lb          *loadbalancer.LoadBalancer
+	logger      *slog.Logger
+	client      *http.Client
+	metrics     *metrics.Metrics
+	usage       *usage.Tracker
+	usageHeader string
+	tracer      trace.Tracer
+	requestID   *requestid.Resolver
+	retry       *retryPolicy
+	hedge       *hedgePolicy
+	hashKey     *identity.Source
+	rateLimit   *ratelimit.Limiter
+	costLimit   *ratelimit.CostLimiter
+
+	// errorCodes are the GraphQL error codes that count as failures of the upstream.
+	errorCodes map[string]bool
+
+	adminToken string
+
+	accessLog             *accesslog.Logger
+	accessLogClientHeader string
 }
 
-func NewProxy(cfg *config.Config, logger *slog.Logger) *Proxy {
-	return &Proxy{
-		lb:     loadbalancer.New(cfg.Upstreams),
+// NewProxy returns the proxy described by cfg. The access log is redacted by redactor,
+// which is the one logger is redacted by and may be nil.
+func NewProxy(cfg *config.Config, logger *slog.Logger, redactor *logging.Redactor) (*Proxy, error) {
+	strategy, err := loadbalancer.NewStrategy(cfg.LoadBalancing.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	strategies := make(map[config.Capability]loadbalancer.Strategy)
+	for op, name := range cfg.LoadBalancing.Operations {
+		if strategies[op], err = loadbalancer.NewStrategy(name); err != nil {
+			return nil, err
+		}
+	}
+
+	m := metrics.New()
+	p := &Proxy{
+		lb: loadbalancer.New(cfg.Upstreams, loadbalancer.Options{
+			Outlier:        cfg.Outlier,
+			CircuitBreaker: cfg.CircuitBreaker,
+			SlowStart:      cfg.LoadBalancing.SlowStart,
+			Adaptive:       cfg.Adaptive,
+			Strategy:       strategy,
+			Strategies:     strategies,
+			EWMADecay:      cfg.LoadBalancing.EWMADecay,
+			Logger:         logger,
+			Metrics:        m,
+		}),
 		logger: logger,
 		client: &http.Client{
 			Timeout: cfg.Server.ResponseTimeout,
@@ -35,135 +92,758 @@ func NewProxy(cfg *config.Config, logger *slog.Logger) *Proxy {
 				TLSHandshakeTimeout: cfg.Server.HandshakeTimeout,
 			},
 		},
-		metrics: metrics.New(),
+		metrics: m,
+		retry:   newRetryPolicy(cfg.Retry),
+		hedge:   newHedgePolicy(cfg.Hedging),
+		tracer:  otel.Tracer("github.com/abdullah2993/graphql-proxy/pkgs/proxy"),
+
+		adminToken: cfg.Admin.Token,
+	}
+
+	if cfg.LoadBalancing.HashKey != "" {
+		if p.hashKey, err = identity.ParseSource(cfg.LoadBalancing.HashKey); err != nil {
+			return nil, fmt.Errorf("parsing hash key: %w", err)
+		}
+	}
+
+	if len(cfg.Outlier.ErrorCodes) > 0 {
+		p.errorCodes = make(map[string]bool, len(cfg.Outlier.ErrorCodes))
+		for _, code := range cfg.Outlier.ErrorCodes {
+			p.errorCodes[code] = true
+		}
+	}
+
+	if p.rateLimit, err = ratelimit.New(cfg.RateLimit, m); err != nil {
+		return nil, fmt.Errorf("creating rate limiter: %w", err)
+	}
+	if p.costLimit, err = ratelimit.NewCostLimiter(cfg.CostLimit, m); err != nil {
+		return nil, fmt.Errorf("creating cost limiter: %w", err)
+	}
+
+	requestID, err := requestid.NewResolver(cfg.RequestID.Header, cfg.RequestID.TrustIncoming, cfg.RequestID.TrustedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("creating request ID resolver: %w", err)
+	}
+	p.requestID = requestID
+
+	accessLog, err := accesslog.New(cfg.AccessLog, redactor)
+	if err != nil {
+		return nil, fmt.Errorf("creating access log: %w", err)
+	}
+	p.accessLog = accessLog
+	p.accessLogClientHeader = cfg.AccessLog.ClientHeader
+
+	if cfg.Usage.Enabled {
+		schema, err := graphql.LoadSchema(cfg.Usage.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("loading usage schema: %w", err)
+		}
+		p.usage = usage.New(schema, cfg.Usage.Window, cfg.Usage.Resolution)
+		p.usageHeader = cfg.Usage.ClientHeader
 	}
+
+	return p, nil
+}
+
+// exchange holds what is known about a request while it is being proxied.
+type exchange struct {
+	requestID       string
+	operation       metrics.OperationInfo
+	parsed          bool
+	upstream        string
+	upstreamLatency time.Duration
+	retries         int
+	traceID         string
+	errors          graphql.ErrorSummary
+	// cost is the cost of the query and the budget left, for the response extensions.
+	cost map[string]interface{}
+	// class is set once the request failed, err when the proxy ran into an error.
+	class metrics.ErrorClass
+	err   error
+}
+
+func (ex *exchange) fail(class metrics.ErrorClass, err error) {
+	ex.class = class
+	ex.err = err
+}
+
+// transportErrorClass classifies an error sending the request to the upstream or copying
+// its response.
+func transportErrorClass(r *http.Request, err error) metrics.ErrorClass {
+	if r.Context().Err() != nil {
+		return metrics.ClassClientDisconnect
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return metrics.ClassTimeout
+	}
+	return metrics.ClassUpstreamConnect
 }
 
 func (p *Proxy) Handler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	p.metrics.IncActiveRequests()
+	defer p.metrics.DecActiveRequests()
+
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	body := &bodyCounter{ReadCloser: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	ex := &exchange{}
+	if id, err := p.requestID.Resolve(r); err != nil {
+		ex.fail(metrics.ClassInternal, err)
+		p.logger.ErrorContext(r.Context(), "failed to generate request ID", "error", err)
+		p.writeError(rec, ex, http.StatusInternalServerError, codeInternalServerError, "Internal Server Error")
+	} else {
+		ex.requestID = id
+		p.serve(rec, r, ex)
+	}
+
+	duration := time.Since(start)
+	if ex.parsed {
+		p.metrics.RecordRequest(ex.operation, duration, ex.class == "")
+		if ex.errors.Count() > 0 {
+			p.metrics.RecordGraphQLErrors(ex.operation, ex.errors.Partial(), ex.errors.Codes)
+		}
+	}
+	if ex.class != "" {
+		p.metrics.RecordError(ex.class, ex.operation, ex.upstream)
+	}
+
+	entry := accesslog.Entry{
+		Time:            start,
+		RequestID:       ex.requestID,
+		RemoteAddr:      r.RemoteAddr,
+		Client:          r.Header.Get(p.accessLogClientHeader),
+		UserAgent:       r.UserAgent(),
+		Referer:         r.Referer(),
+		Method:          r.Method,
+		Path:            r.URL.RequestURI(),
+		Protocol:        r.Proto,
+		Status:          rec.status,
+		BytesIn:         body.n,
+		BytesOut:        rec.bytes,
+		Duration:        duration,
+		Upstream:        ex.upstream,
+		UpstreamLatency: ex.upstreamLatency,
+		Retries:         ex.retries,
+		OperationType:   ex.operation.Type,
+		OperationName:   ex.operation.Name,
+		OperationHash:   ex.operation.Hash,
+		TraceID:         ex.traceID,
+		GraphQLErrors:   ex.errors.Count(),
+		Partial:         ex.errors.Partial(),
+		ErrorCodes:      ex.errors.Codes,
+		ErrorClass:      string(ex.class),
+	}
+	if ex.err != nil {
+		entry.Error = ex.err.Error()
+	}
+	p.accessLog.Log(r.Context(), entry)
+}
+
+// selectServer selects an upstream for route. With wait the request waits in the queue of
+// an upstream when all of them are at their concurrency limit, retries and hedges are
+// only sent to upstreams that can take them right away.
+func (p *Proxy) selectServer(ctx context.Context, route loadbalancer.Route, wait bool) (*loadbalancer.Upstream, error) {
+	ctx, span := p.tracer.Start(ctx, "graphql.route")
+	defer span.End()
+
+	var server *loadbalancer.Upstream
+	var err error
+	if wait {
+		server, err = p.lb.WaitServer(ctx, route)
+	} else {
+		server, err = p.lb.GetServer(route)
+	}
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.URLFull(server.URL))
+	return server, nil
+}
+
+// attempt is a request sent to an upstream.
+type attempt struct {
+	server  *loadbalancer.Upstream
+	resp    *http.Response
+	latency time.Duration
+	// class is set when the request could not be sent or the upstream did not answer.
+	class  metrics.ErrorClass
+	err    error
+	cancel context.CancelFunc
+}
+
+// failure returns the class of a failed attempt, or "" if the upstream answered with a
+// status below 500.
+func (a *attempt) failure() metrics.ErrorClass {
+	if a.err != nil {
+		return a.class
+	}
+	if a.resp.StatusCode >= 500 {
+		return metrics.ClassUpstream5xx
+	}
+	return ""
+}
+
+// outcome returns what a failed attempt is reported to the load balancer as. Only
+// attempts that could not reach the upstream, timed out or got a 5xx status count
+// against it; attempts the client gave up on or the proxy failed to send do not.
+func (a *attempt) outcome() loadbalancer.Outcome {
+	switch a.failure() {
+	case metrics.ClassUpstreamConnect, metrics.ClassTimeout, metrics.ClassUpstream5xx:
+		return loadbalancer.Failure
+	default:
+		return loadbalancer.Canceled
+	}
+}
+
+// discard releases an attempt whose response is not used.
+func (a *attempt) discard() {
+	if a.resp != nil {
+		a.resp.Body.Close()
+	}
+	a.cancel()
+}
+
+// sendUpstream sends the request body to server. resend is the number of times the
+// request was sent before.
+func (p *Proxy) sendUpstream(ctx context.Context, r *http.Request, requestID string, resend int, server *loadbalancer.Upstream, body []byte) *attempt {
+	ctx, cancel := context.WithCancel(ctx)
+	a := &attempt{server: server, cancel: cancel}
+
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(http.MethodPost),
+		semconv.URLFull(server.URL),
+	}
+	if resend > 0 {
+		attrs = append(attrs, semconv.HTTPRequestResendCount(resend))
+	}
+	upstreamCtx, upstreamSpan := p.tracer.Start(ctx, "graphql.upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer upstreamSpan.End()
+
+	// Create upstream request (always POST)
+	upstreamReq, err := http.NewRequestWithContext(upstreamCtx, http.MethodPost, server.URL, bytes.NewReader(body))
+	if err != nil {
+		a.class, a.err = metrics.ClassInternal, err
+		recordSpanError(upstreamSpan, err)
+		return a
+	}
+
+	// Set headers
+	upstreamReq.Header.Set("Content-Type", "application/json")
+	upstreamReq.Header.Set("X-Forwarded-Host", r.Host)
+	upstreamReq.Header.Set("X-Forwarded-Proto", r.URL.Scheme)
+	upstreamReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	upstreamReq.Header.Set(p.requestID.Header(), requestID)
+
+	// Copy original headers (except those we explicitly set)
+	for k, vv := range r.Header {
+		if k != "Content-Type" && k != p.requestID.Header() && !isForwardedHeader(k) {
+			for _, v := range vv {
+				upstreamReq.Header.Add(k, v)
+			}
+		}
+	}
+	otel.GetTextMapPropagator().Inject(upstreamCtx, propagation.HeaderCarrier(upstreamReq.Header))
+	upstreamStart := time.Now()
+
+	// Send request
+	resp, err := p.client.Do(upstreamReq)
+	a.latency = time.Since(upstreamStart)
+	if err != nil {
+		a.class, a.err = transportErrorClass(r, err), err
+		recordSpanError(upstreamSpan, err)
+		return a
+	}
+	a.resp = resp
+
+	upstreamSpan.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		upstreamSpan.SetStatus(codes.Error, resp.Status)
+	}
+	return a
+}
+
+// serve proxies a single GraphQL request, recording its progress in ex.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request, ex *exchange) {
+	requestID := ex.requestID
+	w.Header().Set(p.requestID.Header(), requestID)
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := p.tracer.Start(ctx, "graphql.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			attribute.String("request.id", requestID),
+		),
+	)
+	defer span.End()
 
-	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
-	ctx := r.Context()
 	logger := p.logger.With(
 		"method", r.Method,
 		"path", r.URL.Path,
 		"remote_addr", r.RemoteAddr,
 		"request_id", requestID,
 	)
+	if sc := span.SpanContext(); sc.IsValid() {
+		ex.traceID = sc.TraceID().String()
+		logger = logger.With("trace_id", ex.traceID, "span_id", sc.SpanID().String())
+	}
+
+	// Rules that do not need the parsed request are checked first, so that clients
+	// flooding the proxy with requests it cannot parse are limited too.
+	limit := p.rateLimit.CheckRequest(r)
+	if !p.allowed(ctx, w, ex, span, logger, limit) {
+		return
+	}
 
+	_, parseSpan := p.tracer.Start(ctx, "graphql.parse")
 	req, err := graphql.ParseGraphQLRequest(r)
 	if err != nil {
-		logger.ErrorContext(ctx, "failed to parse GraphQL request", "error", err)
-		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		ex.fail(metrics.ClassParseError, err)
+		logger.InfoContext(ctx, "failed to parse GraphQL request", "error", err)
+		recordSpanError(parseSpan, err)
+		recordSpanError(span, err)
+		parseSpan.End()
+		p.writeError(w, ex, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Bad Request: %v", err))
 		return
 	}
 
-	op, name, err := req.Parse()
+	doc, operation, err := req.ParseOperation()
 	if err != nil {
-		logger.ErrorContext(ctx, "failed to parse GraphQL operation", "error", err)
-		http.Error(w, fmt.Sprintf("Invalid GraphQL query: %v", err), http.StatusBadRequest)
+		ex.fail(metrics.ClassInvalidOperation, err)
+		logger.InfoContext(ctx, "failed to parse GraphQL operation", "error", err, "query", req.Query)
+		recordSpanError(parseSpan, err)
+		recordSpanError(span, err)
+		parseSpan.End()
+		p.writeError(w, ex, http.StatusBadRequest, codeParseFailed, fmt.Sprintf("Invalid GraphQL query: %v", err))
 		return
 	}
-	defer func() {
-		p.metrics.DecActiveRequests()
-		duration := time.Since(start)
-		success := err == nil
-		p.metrics.RecordRequest(string(op), duration, success)
-	}()
+	op, name := operation.Operation, operation.Name
+	hash := graphql.SignatureHash(graphql.Signature(doc, operation))
+	parseSpan.End()
+
+	ex.parsed = true
+	ex.operation = metrics.OperationInfo{
+		Type: string(op),
+		Name: name,
+		Hash: hash,
+	}
+
+	span.SetName(strings.TrimSpace(string(op) + " " + name))
+	span.SetAttributes(
+		semconv.GraphQLOperationTypeKey.String(string(op)),
+		semconv.GraphQLOperationName(name),
+		attribute.String("graphql.operation.hash", hash),
+	)
 
 	logger = logger.With(
 		"operation", op,
 		"operation_name", name,
+		"operation_hash", hash,
 	)
 
-	server, err := p.lb.GetServer(config.Capability(op), name)
-	if err != nil {
-		logger.ErrorContext(ctx, "no server available", "error", err)
-		http.Error(w, fmt.Sprintf("No server available for operation: %v", err), http.StatusServiceUnavailable)
+	logger.DebugContext(ctx, "parsed operation",
+		"query", req.Query,
+		"variables", req.Variables,
+		"headers", r.Header,
+	)
+
+	limit = limit.Merge(p.rateLimit.CheckOperation(r, req.Variables, name))
+	if !p.allowed(ctx, w, ex, span, logger, limit) {
 		return
 	}
 
-	logger = logger.With("upstream", server.URL)
+	if p.costLimit != nil {
+		cost, err := p.costLimit.Check(r, doc, operation, req.Variables)
+		if err != nil {
+			ex.fail(metrics.ClassRateLimited, err)
+			logger.WarnContext(ctx, "query too large to estimate its cost", "key", cost.Key, "error", err)
+			recordSpanError(span, err)
+			p.writeError(w, ex, http.StatusBadRequest, codeQueryTooExpensive, "Query too expensive: "+err.Error())
+			return
+		}
+		ex.cost = cost.Extension()
+		span.SetAttributes(attribute.Int64("graphql.operation.cost", cost.Cost))
+		if !cost.Allowed {
+			err := fmt.Errorf("query cost %d exceeds the budget of %s", cost.Cost, cost.Key)
+			ex.fail(metrics.ClassRateLimited, err)
+			logger.WarnContext(ctx, "cost limit exceeded",
+				"key", cost.Key,
+				"cost", cost.Cost,
+				"remaining", cost.Remaining,
+				"retry_after", cost.RetryAfter,
+			)
+			recordSpanError(span, err)
+			if cost.TooExpensive() {
+				p.writeError(w, ex, http.StatusBadRequest, codeQueryTooExpensive, fmt.Sprintf("Query cost %d exceeds the maximum of %d", cost.Cost, cost.Limit))
+				return
+			}
+			cost.SetHeaders(w.Header())
+			p.writeError(w, ex, http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("Query cost %d exceeds the remaining budget of %d, retry after %d seconds", cost.Cost, cost.Remaining, cost.RetryAfterSeconds()))
+			return
+		}
+	}
+
+	if p.usage != nil {
+		client := r.Header.Get(p.usageHeader)
+		if client == "" {
+			client = "unknown"
+		}
+		if err := p.usage.Record(client, doc, operation, hash); err != nil {
+			logger.DebugContext(ctx, "operation does not match usage schema", "error", err)
+		}
+	}
 
 	// Marshal the request body
 	body, err := json.Marshal(req)
 	if err != nil {
+		ex.fail(metrics.ClassInternal, err)
 		logger.ErrorContext(ctx, "failed to marshal request body", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		recordSpanError(span, err)
+		p.writeError(w, ex, http.StatusInternalServerError, codeInternalServerError, "Internal Server Error")
 		return
 	}
 
-	// Create upstream request (always POST)
-	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, bytes.NewReader(body))
+	route := loadbalancer.Route{Capability: config.Capability(op), OperationName: name}
+	if p.hashKey != nil {
+		route.Key = p.hashKey.Key(r, req.Variables)
+	}
+	server, err := p.selectServer(ctx, route, true)
 	if err != nil {
-		logger.ErrorContext(ctx, "failed to create upstream request", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		ex.fail(metrics.ClassNoUpstream, err)
+		logger.ErrorContext(ctx, "no server available", "error", err)
+		recordSpanError(span, err)
+		code := codeServiceUnavailable
+		switch {
+		case errors.Is(err, loadbalancer.ErrSaturated):
+			code = codeUpstreamSaturated
+		case errors.Is(err, loadbalancer.ErrCircuitOpen):
+			code = codeCircuitOpen
+		}
+		p.writeError(w, ex, http.StatusServiceUnavailable, code, fmt.Sprintf("No server available for operation: %v", err))
 		return
 	}
+	// Requests that end before the upstream answered, for whatever reason, are reported
+	// as canceled. Upstreams a request is retried away from are reported as they fail.
+	outcome := loadbalancer.Canceled
+	defer func() { p.lb.Report(server, outcome, ex.upstreamLatency) }()
 
-	// Set headers
-	upstreamReq.Header.Set("Content-Type", "application/json")
-	upstreamReq.Header.Set("X-Forwarded-Host", r.Host)
-	upstreamReq.Header.Set("X-Forwarded-Proto", r.URL.Scheme)
-	upstreamReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
-	upstreamReq.Header.Set("X-Request-ID", requestID)
+	retryable := p.retry.retryable(route.Capability)
+	if retryable {
+		p.retry.budget.deposit()
+	}
 
-	// Copy original headers (except those we explicitly set)
-	for k, vv := range r.Header {
-		if k != "Content-Type" && !isForwardedHeader(k) {
-			for _, v := range vv {
-				upstreamReq.Header.Add(k, v)
-			}
+	var a *attempt
+	if delay, ok := p.hedge.delay(ex.operation, p.metrics); ok {
+		a = p.sendHedged(ctx, r, ex, &route, server, body, delay, logger)
+		server = a.server
+	} else {
+		a = p.sendUpstream(ctx, r, requestID, 0, server, body)
+	}
+	for n := 1; retryable; n++ {
+		class := a.failure()
+		if class == "" || !p.retry.retries(n, class) {
+			break
+		}
+
+		route.Exclude = append(route.Exclude, server)
+		next, selectErr := p.selectServer(ctx, route, false)
+		if selectErr != nil {
+			logger.DebugContext(ctx, "no upstream to retry on", "upstream", server.URL, "error", selectErr)
+			break
+		}
+		if !p.retry.budget.withdraw() {
+			p.lb.Report(next, loadbalancer.Canceled, 0)
+			p.metrics.RecordRetryBudgetExhausted()
+			logger.WarnContext(ctx, "retry budget exhausted", "upstream", server.URL, "error_class", class)
+			break
+		}
+		if !wait(r.Context(), p.retry.backoff(n)) {
+			p.lb.Report(next, loadbalancer.Canceled, 0)
+			break
+		}
+
+		failure := slog.Any("error", a.err)
+		if a.err == nil {
+			failure = slog.Int("status_code", a.resp.StatusCode)
 		}
+		logger.WarnContext(ctx, "retrying request on another upstream",
+			"upstream", server.URL,
+			"next_upstream", next.URL,
+			"error_class", class,
+			failure,
+			"attempt", n+1,
+		)
+		p.metrics.RecordUpstreamRequest(server.URL, a.latency, false)
+		p.metrics.RecordRetry(class, ex.operation, server.URL)
+		p.lb.Report(server, loadbalancer.Failure, a.latency)
+		a.discard()
+
+		server, outcome = next, loadbalancer.Canceled
+		ex.retries++
+		a = p.sendUpstream(ctx, r, requestID, ex.retries, server, body)
 	}
-	upstreamStart := time.Now()
+	defer a.cancel()
 
-	// Send request
-	resp, err := p.client.Do(upstreamReq)
-	if err != nil {
-		logger.ErrorContext(ctx, "failed to send request to upstream", "error", err)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	ex.upstream, ex.upstreamLatency = server.URL, a.latency
+	logger = logger.With("upstream", server.URL)
+	if ex.retries > 0 {
+		logger = logger.With("retries", ex.retries)
+		span.SetAttributes(attribute.Int("graphql.retries", ex.retries))
+	}
+	if a.err != nil {
+		ex.fail(a.class, a.err)
+		recordSpanError(span, a.err)
+		if ex.class == metrics.ClassInternal {
+			logger.ErrorContext(ctx, "failed to create upstream request", "error", a.err)
+			p.writeError(w, ex, http.StatusInternalServerError, codeInternalServerError, "Internal Server Error")
+			return
+		}
+		logger.ErrorContext(ctx, "failed to send request to upstream", "error", a.err, "error_class", ex.class)
+		p.metrics.RecordUpstreamRequest(server.URL, a.latency, false)
+		outcome = a.outcome()
+		switch ex.class {
+		case metrics.ClassClientDisconnect:
+			w.WriteHeader(statusClientClosedRequest)
+		case metrics.ClassTimeout:
+			p.writeError(w, ex, http.StatusGatewayTimeout, codeGatewayTimeout, "Gateway Timeout")
+		default:
+			p.writeError(w, ex, http.StatusBadGateway, codeBadGateway, "Bad Gateway")
+		}
 		return
 	}
+	resp := a.resp
 	defer resp.Body.Close()
-	defer func() {
-		latency := time.Since(upstreamStart)
-		success := err == nil && resp.StatusCode < 500
-		p.metrics.RecordUpstreamRequest(server.URL, latency, success)
-	}()
 
-	// Copy response headers
-	for k, vv := range resp.Header {
-		for _, v := range vv {
-			w.Header().Add(k, v)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	// Copy response
+	_, copySpan := p.tracer.Start(ctx, "graphql.response")
+	defer copySpan.End()
+	_, summary, err := p.copyResponse(w, resp, ex)
+	ex.errors = summary
+	// Responses with errors and no data count as failed requests, for the request as for
+	// the upstream; partial responses, which have data too, count as successful.
+	p.metrics.RecordUpstreamRequest(server.URL, ex.upstreamLatency, resp.StatusCode < 500 && !summary.Failed())
+	if err != nil {
+		ex.fail(transportErrorClass(r, err), err)
+		if ex.class != metrics.ClassClientDisconnect {
+			outcome = loadbalancer.Failure
 		}
+		logger.ErrorContext(ctx, "error copying response", "error", err, "error_class", ex.class)
+		recordSpanError(copySpan, err)
+		recordSpanError(span, err)
+		return
+	}
+	// GraphQL errors are mostly caused by the query, such as invalid or unauthorized ones,
+	// and only count against the upstream when their code is configured to.
+	outcome = loadbalancer.Success
+	switch {
+	case resp.StatusCode >= 500:
+		outcome = loadbalancer.Failure
+	case p.upstreamErrors(summary):
+		outcome = loadbalancer.Errored
 	}
 
-	// Copy status code
-	w.WriteHeader(resp.StatusCode)
+	switch {
+	case resp.StatusCode >= 500:
+		ex.class = metrics.ClassUpstream5xx
+	case resp.StatusCode >= 400:
+		ex.class = metrics.ClassUpstream4xx
+	case summary.Failed():
+		ex.class = metrics.ClassGraphQLErrors
+	}
 
-	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		logger.ErrorContext(ctx, "error copying response", "error", err)
+	if summary.Count() > 0 {
+		span.SetAttributes(
+			attribute.Int("graphql.errors", summary.Count()),
+			attribute.StringSlice("graphql.error_codes", summary.Codes),
+		)
+		level := slog.LevelInfo
+		if summary.Failed() {
+			span.SetStatus(codes.Error, "GraphQL errors without data")
+			level = slog.LevelWarn
+		}
+		logger.Log(ctx, level, "upstream returned GraphQL errors",
+			"status_code", resp.StatusCode,
+			"errors", summary.Count(),
+			"partial", summary.Partial(),
+			"error_codes", summary.Codes,
+		)
 		return
 	}
 
-	logger.InfoContext(ctx, "proxied operation",
+	// Every request is described by an access log entry, or by this line when the access
+	// log is disabled.
+	level := slog.LevelInfo
+	if p.accessLog != nil {
+		level = slog.LevelDebug
+	}
+	logger.Log(ctx, level, "proxied operation",
 		"status_code", resp.StatusCode,
 		"content_length", resp.ContentLength,
 	)
 }
 
+// allowed sets the rate limit headers of a decision and reports whether it allows the
+// request, which is rejected otherwise.
+func (p *Proxy) allowed(ctx context.Context, w http.ResponseWriter, ex *exchange, span trace.Span, logger *slog.Logger, limit ratelimit.Decision) bool {
+	if limit.Rule == "" {
+		return true
+	}
+	limit.SetHeaders(w.Header())
+	if limit.Allowed {
+		return true
+	}
+	err := fmt.Errorf("rate limit %s exceeded by %s", limit.Rule, limit.Key)
+	ex.fail(metrics.ClassRateLimited, err)
+	logger.WarnContext(ctx, "rate limit exceeded",
+		"rule", limit.Rule,
+		"key", limit.Key,
+		"retry_after", limit.RetryAfter,
+	)
+	recordSpanError(span, err)
+	p.writeError(w, ex, http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("Rate limit exceeded, retry after %d seconds", limit.RetryAfterSeconds()))
+	return false
+}
+
+// upstreamErrors reports whether a response has errors with a code that counts as a
+// failure of the upstream.
+func (p *Proxy) upstreamErrors(summary graphql.ErrorSummary) bool {
+	for _, code := range summary.Codes {
+		if p.errorCodes[code] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) Metrics() *metrics.Metrics {
+	return p.metrics
+}
+
+// LoadBalancer returns the load balancer selecting the upstreams.
+func (p *Proxy) LoadBalancer() *loadbalancer.LoadBalancer {
+	return p.lb
+}
+
 func (p *Proxy) MetricsHandler(w http.ResponseWriter, r *http.Request) {
 	stats := p.metrics.GetStats()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
+func (p *Proxy) PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.metrics.WritePrometheus(w)
+}
+
+// AdminHandler guards an admin endpoint. Requests need the admin token as a bearer token
+// or, when there is none, to come from the loopback interface.
+func (p *Proxy) AdminHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.adminAllowed(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (p *Proxy) adminAllowed(r *http.Request) bool {
+	if p.adminToken == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) == 1
+}
+
+func (p *Proxy) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if p.usage == nil {
+		http.Error(w, "Usage tracking is not enabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	fields := p.usage.Usage(usage.ParseFilter(q.Get("field"), q.Get("client"), q.Get("operation")))
+
+	var total int64
+	for _, f := range fields {
+		total += f.Count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  total,
+		"fields": fields,
+	})
+}
+
+// UpstreamsHandler reports the state of every upstream.
+func (p *Proxy) UpstreamsHandler(w http.ResponseWriter, r *http.Request) {
+	upstreams := make([]map[string]interface{}, 0)
+	for _, u := range p.lb.Upstreams() {
+		status := map[string]interface{}{
+			"url":          u.URL,
+			"capabilities": u.Capabilities,
+			"weight":       u.Weight,
+			"healthy":      u.Healthy(),
+			"ejected":      u.Ejected(),
+			"circuit":      u.Circuit(),
+			"outstanding":  u.Outstanding(),
+		}
+		if limit := u.ConcurrencyLimit(); limit > 0 {
+			status["concurrency_limit"] = limit
+			status["queued"] = u.Queued()
+		}
+		if weight := u.EffectiveWeight(); weight < float64(u.Weight) {
+			status["effective_weight"] = weight
+		}
+		if latency := u.Latency(); latency > 0 {
+			status["latency_ms"] = float64(latency) / float64(time.Millisecond)
+		}
+		ejections, until := u.Ejection()
+		status["ejections"] = ejections
+		if !until.IsZero() {
+			status["ejected_until"] = until
+		}
+		if check := u.LastCheck(); check != nil {
+			status["last_check"] = check.Time
+			if check.Error != "" {
+				status["last_check_error"] = check.Error
+			}
+		}
+		upstreams = append(upstreams, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upstreams": upstreams,
+	})
+}
+
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
 func isForwardedHeader(header string) bool {
 	switch header {
-	case "X-Forwarded-Host", "X-Forwarded-Proto", "X-Forwarded-For", "X-Request-ID":
+	case "X-Forwarded-Host", "X-Forwarded-Proto", "X-Forwarded-For":
 		return true
 	default:
 		return false
@@ -0,0 +1,507 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+// newTestProxy returns a proxy for the YAML configuration cfg.
+func newTestProxy(t *testing.T, cfg string) *Proxy {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProxy(c, slog.New(slog.DiscardHandler), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// upstream returns a GraphQL server that answers every request with body.
+func upstream(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// query sends a GraphQL query to the proxy with the headers given as name, value pairs.
+func query(p *Proxy, q string, headers ...string) *httptest.ResponseRecorder {
+	return post(p, `{"query":`+quote(q)+`}`, headers...)
+}
+
+// post sends a request body to the proxy with the headers given as name, value pairs.
+func post(p *Proxy, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	p.Handler(w, r)
+	return w
+}
+
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func TestClientErrorsDoNotEjectUpstreams(t *testing.T) {
+	invalid := upstream(t, http.StatusOK, `{"errors":[{"message":"field not found","extensions":{"code":"validation-failed"}}]}`)
+	broken := upstream(t, http.StatusOK, `{"errors":[{"message":"boom","extensions":{"code":"INTERNAL_SERVER_ERROR"}}]}`)
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+invalid.URL+`/a
+    weight: 1
+    capabilities: [query]
+    operation_names: [Invalid]
+  - url: `+invalid.URL+`/b
+    weight: 1
+    capabilities: [query]
+    operation_names: [Invalid]
+  - url: `+broken.URL+`/a
+    weight: 1
+    capabilities: [query]
+    operation_names: [Broken]
+  - url: `+broken.URL+`/b
+    weight: 1
+    capabilities: [query]
+    operation_names: [Broken]
+outlier_detection:
+  enabled: true
+  consecutive_failures: 1
+  error_codes: [INTERNAL_SERVER_ERROR]
+`)
+	upstreams := p.LoadBalancer().Upstreams()
+
+	for i := 0; i < 5; i++ {
+		query(p, "query Invalid { nope }")
+	}
+	if upstreams[0].Ejected() || upstreams[1].Ejected() {
+		t.Error("upstream ejected for errors caused by the client")
+	}
+
+	query(p, "query Broken { boom }")
+	if !upstreams[2].Ejected() && !upstreams[3].Ejected() {
+		t.Error("upstream not ejected for errors with a configured code")
+	}
+}
+
+func TestRateLimitBeforeParsing(t *testing.T) {
+	u := upstream(t, http.StatusOK, `{"data":{"hello":"world"}}`)
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+u.URL+`
+    weight: 1
+    capabilities: [query]
+rate_limit:
+  enabled: true
+  rules:
+    - key: ip
+      limit: 2
+`)
+
+	if w := post(p, "{not json"); w.Code != http.StatusBadRequest {
+		t.Fatalf("malformed request: status %d", w.Code)
+	}
+	if w := post(p, `{"query":"{"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid query: status %d", w.Code)
+	}
+	if w := post(p, "{not json"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("malformed request over the limit: status %d, want 429", w.Code)
+	}
+}
+
+func TestCopyResponse(t *testing.T) {
+	p := newTestProxy(t, `
+upstreams:
+  - url: http://localhost
+    weight: 1
+    capabilities: [query]
+`)
+	big := strings.Repeat("x", 1<<20)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}, "Content-Length": {"1"}},
+		Body:       io.NopCloser(strings.NewReader(`{"data":{"big":"` + big + `"},"errors":[{"message":"x","extensions":{"code":"FORBIDDEN"}}]}`)),
+	}
+	w := httptest.NewRecorder()
+	_, summary, err := p.copyResponse(w, resp, &exchange{requestID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Partial() || summary.Codes[0] != "FORBIDDEN" {
+		t.Errorf("summary = %+v", summary)
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("Content-Length = %q for a rewritten response", w.Header().Get("Content-Length"))
+	}
+	var body struct {
+		Data       struct{ Big string }
+		Extensions map[string]interface{}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.Big != big || body.Extensions["request_id"] != "r1" {
+		t.Errorf("extensions = %v", body.Extensions)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var forwarded string
+	u := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Request-ID")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"hello":"world"}}`))
+	}))
+	t.Cleanup(u.Close)
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+u.URL+`
+    weight: 1
+    capabilities: [query]
+request_id:
+  trust_incoming: true
+`)
+
+	for _, incoming := range []string{"client-id-1", ""} {
+		w := query(p, "{ hello }", "X-Request-ID", incoming)
+		id := w.Header().Get("X-Request-ID")
+		if incoming != "" && id != incoming || id == "" {
+			t.Errorf("response ID %q for incoming ID %q", id, incoming)
+		}
+		if forwarded != id {
+			t.Errorf("forwarded ID %q, want %q", forwarded, id)
+		}
+		var body struct{ Extensions map[string]interface{} }
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Extensions["request_id"] != id {
+			t.Errorf("extensions = %v, want request_id %q", body.Extensions, id)
+		}
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	u := upstream(t, http.StatusOK, `{"data":{"hello":"world"}}`)
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+u.URL+`
+    weight: 1
+    capabilities: [query]
+rate_limit:
+  enabled: true
+  rules:
+    - name: per-key
+      key: header:X-API-Key
+      limit: 1
+      period: 1m
+`)
+
+	w := query(p, "{ hello }", "X-API-Key", "a")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("first query: status %d, headers %v", w.Code, w.Header())
+	}
+
+	w = query(p, "{ hello }", "X-API-Key", "a")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second query: status %d", w.Code)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":        "application/json",
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Policy":    "1;w=60",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Reset") == "" {
+		t.Errorf("missing Retry-After or RateLimit-Reset: %v", w.Header())
+	}
+	var body struct {
+		Data   interface{}
+		Errors []struct {
+			Message    string
+			Extensions map[string]interface{}
+		}
+		Extensions map[string]interface{}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data != nil || len(body.Errors) != 1 || body.Errors[0].Extensions["code"] != codeRateLimited || body.Extensions["request_id"] == nil {
+		t.Errorf("body = %s", w.Body.String())
+	}
+
+	if w := query(p, "{ hello }", "X-API-Key", "b"); w.Code != http.StatusOK {
+		t.Errorf("other key: status %d", w.Code)
+	}
+}
+
+func TestCostExtensions(t *testing.T) {
+	u := upstream(t, http.StatusOK, `{"data":{"users":[]},"extensions":{"tracing":{}}}`)
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+u.URL+`
+    weight: 1
+    capabilities: [query]
+cost_limit:
+  enabled: true
+  budget: 100
+  period: 1m
+`)
+
+	cost := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		t.Helper()
+		var body struct{ Extensions map[string]interface{} }
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		c, _ := body.Extensions["cost"].(map[string]interface{})
+		return c
+	}
+
+	w := query(p, "{ users(first: 30) { org { id } } }")
+	c := cost(w)
+	if w.Code != http.StatusOK || c["requested"] != float64(31) || c["limit"] != float64(100) || c["remaining"] != float64(69) {
+		t.Fatalf("status %d, cost %v", w.Code, c)
+	}
+	if !strings.Contains(w.Body.String(), `"tracing":{}`) {
+		t.Errorf("upstream extensions lost: %s", w.Body.String())
+	}
+
+	w = query(p, "{ users(first: 200) { org { id } } }")
+	if c := cost(w); w.Code != http.StatusBadRequest || c["requested"] != float64(201) || !strings.Contains(w.Body.String(), codeQueryTooExpensive) {
+		t.Fatalf("query over the budget: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	query(p, "{ users(first: 60) { org { id } } }")
+	w = query(p, "{ users(first: 30) { org { id } } }")
+	if c := cost(w); w.Code != http.StatusTooManyRequests || c["remaining"] != float64(8) || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("query over the remaining budget: status %d, headers %v, body %s", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	cfg := `
+upstreams:
+  - url: http://localhost
+    weight: 1
+    capabilities: [query]
+`
+	testCases := []struct {
+		desc       string
+		token      string
+		remoteAddr string
+		auth       string
+		status     int
+	}{
+		{desc: "Loopback clients without a token", remoteAddr: "127.0.0.1:1234", status: http.StatusOK},
+		{desc: "IPv6 loopback clients without a token", remoteAddr: "[::1]:1234", status: http.StatusOK},
+		{desc: "Other clients without a token", remoteAddr: "192.0.2.1:1234", status: http.StatusUnauthorized},
+		{desc: "Valid token", token: "secret", remoteAddr: "192.0.2.1:1234", auth: "Bearer secret", status: http.StatusOK},
+		{desc: "Wrong token", token: "secret", remoteAddr: "127.0.0.1:1234", auth: "Bearer wrong", status: http.StatusUnauthorized},
+		{desc: "Missing token", token: "secret", remoteAddr: "127.0.0.1:1234", status: http.StatusUnauthorized},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c := cfg
+			if tC.token != "" {
+				c += "admin:\n  token: " + tC.token + "\n"
+			}
+			p := newTestProxy(t, c)
+			r := httptest.NewRequest(http.MethodGet, "/admin/upstreams", nil)
+			r.RemoteAddr = tC.remoteAddr
+			if tC.auth != "" {
+				r.Header.Set("Authorization", tC.auth)
+			}
+			w := httptest.NewRecorder()
+			p.AdminHandler(p.UpstreamsHandler)(w, r)
+			if w.Code != tC.status {
+				t.Errorf("status %d, want %d", w.Code, tC.status)
+			}
+		})
+	}
+}
+
+func TestAccessLogBytesIn(t *testing.T) {
+	u := upstream(t, http.StatusOK, `{"data":{"hello":"world"}}`)
+	output := filepath.Join(t.TempDir(), "access.log")
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+u.URL+`
+    weight: 1
+    capabilities: [query]
+access_log:
+  enabled: true
+  output: `+output+`
+  fields: [bytes_in]
+`)
+
+	body := `{"query":"{ hello }"}`
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	// Chunked bodies have no length in advance.
+	r.ContentLength = -1
+	p.Handler(httptest.NewRecorder(), r)
+
+	out, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`"bytes_in":%d`, len(body)); !strings.Contains(string(out), want) {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTransportErrorClass(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		desc string
+		ctx  context.Context
+		err  error
+		want metrics.ErrorClass
+	}{
+		{"Client went away", canceled, io.ErrUnexpectedEOF, metrics.ClassClientDisconnect},
+		{"Deadline exceeded", context.Background(), fmt.Errorf("sending: %w", context.DeadlineExceeded), metrics.ClassTimeout},
+		{"Network timeout", context.Background(), &net.OpError{Op: "read", Err: timeoutError{}}, metrics.ClassTimeout},
+		{"Connection refused", context.Background(), &net.OpError{Op: "dial", Err: errors.New("connection refused")}, metrics.ClassUpstreamConnect},
+		{"Connection reset", context.Background(), io.ErrUnexpectedEOF, metrics.ClassUpstreamConnect},
+	}
+	for _, tC := range testCases {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", nil).WithContext(tC.ctx)
+		if got := transportErrorClass(r, tC.err); got != tC.want {
+			t.Errorf("%s: got %s, want %s", tC.desc, got, tC.want)
+		}
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	failing := upstream(t, http.StatusInternalServerError, `{"errors":[{"message":"boom"}]}`)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+failing.URL+`
+    weight: 1
+    capabilities: [query]
+    operation_names: [Failing]
+  - url: `+slow.URL+`
+    weight: 1
+    capabilities: [query]
+    operation_names: [Slow]
+  - url: `+closed.URL+`
+    weight: 1
+    capabilities: [query]
+    operation_names: [Closed]
+server:
+  response_timeout: 50ms
+`)
+
+	post(p, "{not json")
+	query(p, "{")
+	query(p, "mutation M { x }")
+	query(p, "query Failing { x }")
+	query(p, "query Slow { x }")
+	query(p, "query Closed { x }")
+
+	got := make(map[metrics.ErrorClass]string)
+	for _, e := range p.Metrics().Snapshot().Errors {
+		got[e.Class] = e.OperationName
+	}
+	want := map[metrics.ErrorClass]string{
+		metrics.ClassParseError:       "",
+		metrics.ClassInvalidOperation: "",
+		metrics.ClassNoUpstream:       "M",
+		metrics.ClassUpstream5xx:      "Failing",
+		metrics.ClassTimeout:          "Slow",
+		metrics.ClassUpstreamConnect:  "Closed",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGraphQLErrorMetrics(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		body    string
+		success bool
+	}{
+		{"Partial response", `{"data":{"x":1},"errors":[{"message":"boom"}]}`, true},
+		{"Failed response", `{"data":null,"errors":[{"message":"boom"}]}`, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			s := upstream(t, http.StatusOK, tC.body)
+			p := newTestProxy(t, `
+upstreams:
+  - url: `+s.URL+`
+    weight: 1
+    capabilities: [query]
+`)
+			if w := query(p, "query Q { x }"); w.Code != http.StatusOK {
+				t.Fatalf("got status %d", w.Code)
+			}
+
+			snapshot := p.Metrics().Snapshot()
+			op, up := snapshot.Operations[0], snapshot.Upstreams[0]
+			if (op.Success == 1) != tC.success || (up.Success == 1) != tC.success {
+				t.Errorf("request succeeded %d times, upstream request %d times, want success %v", op.Success, up.Success, tC.success)
+			}
+			if failed := len(snapshot.Errors) == 1 && snapshot.Errors[0].Class == metrics.ClassGraphQLErrors; failed == tC.success {
+				t.Errorf("errors %+v", snapshot.Errors)
+			}
+			if op.PartialResponses+op.FailedResponses != 1 {
+				t.Errorf("%d partial and %d failed responses", op.PartialResponses, op.FailedResponses)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/graphql"
+)
+
+// Error codes reported in the extensions of errors generated by the proxy.
+const (
+	codeBadRequest          = "BAD_REQUEST"
+	codeParseFailed         = "GRAPHQL_PARSE_FAILED"
+	codeServiceUnavailable  = "SERVICE_UNAVAILABLE"
+	codeInternalServerError = "INTERNAL_SERVER_ERROR"
+	codeBadGateway          = "BAD_GATEWAY"
+	codeGatewayTimeout      = "GATEWAY_TIMEOUT"
+	codeCircuitOpen         = "CIRCUIT_OPEN"
+	codeUpstreamSaturated   = "UPSTREAM_SATURATED"
+	codeRateLimited         = "RATE_LIMITED"
+	codeQueryTooExpensive   = "QUERY_TOO_EXPENSIVE"
+)
+
+// statusClientClosedRequest is recorded for clients that went away before the response was
+// written.
+const statusClientClosedRequest = 499
+
+// writeError writes a GraphQL response with a single error generated by the proxy.
+func (p *Proxy) writeError(w http.ResponseWriter, ex *exchange, status int, code, message string) {
+	resp := graphql.Response{
+		Errors: []interface{}{graphql.Error{
+			Message:    message,
+			Extensions: map[string]interface{}{"code": code},
+		}},
+		Extensions: p.responseExtensions(ex),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// responseExtensions returns the extensions the proxy adds to every GraphQL response.
+func (p *Proxy) responseExtensions(ex *exchange) map[string]interface{} {
+	extensions := map[string]interface{}{"request_id": ex.requestID}
+	if ex.cost != nil {
+		extensions["cost"] = ex.cost
+	}
+	return extensions
+}
+
+// copyResponse streams the upstream response to the client. The proxy extensions are
+// merged into JSON responses and their errors summarized as they are copied, which changes
+// their length, other responses are copied unchanged.
+func (p *Proxy) copyResponse(w http.ResponseWriter, resp *http.Response, ex *exchange) (int64, graphql.ErrorSummary, error) {
+	for k, vv := range resp.Header {
+		if k == p.requestID.Header() || k == "Content-Length" {
+			continue
+		}
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set(p.requestID.Header(), ex.requestID)
+
+	if !isJSON(resp.Header.Get("Content-Type")) {
+		if resp.ContentLength >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+		}
+		w.WriteHeader(resp.StatusCode)
+		n, err := io.Copy(w, resp.Body)
+		return n, graphql.ErrorSummary{}, err
+	}
+
+	var dst io.Writer = w
+	if ew, err := graphql.NewExtensionWriter(w, p.responseExtensions(ex)); err == nil {
+		dst = ew
+	}
+
+	pr, pw := io.Pipe()
+	scanned := make(chan graphql.ErrorSummary, 1)
+	go func() {
+		// Bodies that are not GraphQL responses have no errors to report.
+		summary, _ := graphql.ScanErrors(pr)
+		// The rest of the body is drained so that copying it never blocks.
+		io.Copy(io.Discard, pr)
+		scanned <- summary
+	}()
+
+	w.WriteHeader(resp.StatusCode)
+	n, err := io.Copy(dst, io.TeeReader(resp.Body, pw))
+	pw.CloseWithError(err)
+	return n, <-scanned, err
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "application/graphql-response+json"
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+// retryPolicy decides whether a request that failed on an upstream is sent again to
+// another one. A nil retryPolicy never retries.
+type retryPolicy struct {
+	cfg    config.UpstreamRetryConfig
+	on     map[metrics.ErrorClass]bool
+	budget *budget
+}
+
+func newRetryPolicy(cfg config.UpstreamRetryConfig) *retryPolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	on := make(map[metrics.ErrorClass]bool, len(cfg.RetryOn))
+	for _, class := range cfg.RetryOn {
+		on[metrics.ErrorClass(class)] = true
+	}
+	return &retryPolicy{
+		cfg:    cfg,
+		on:     on,
+		budget: newBudget(cfg.BudgetRatio, float64(cfg.MinRetriesPerSecond)),
+	}
+}
+
+// retryable reports whether operations of a type may be retried at all. Queries are,
+// mutations only when enabled and subscriptions never.
+func (rp *retryPolicy) retryable(op config.Capability) bool {
+	if rp == nil {
+		return false
+	}
+	switch op {
+	case config.CapabilityQuery:
+		return true
+	case config.CapabilityMutation:
+		return rp.cfg.Mutations
+	default:
+		return false
+	}
+}
+
+// retries reports whether a request whose attempt failed with class may be sent again.
+// Attempts are counted from 1.
+func (rp *retryPolicy) retries(attempt int, class metrics.ErrorClass) bool {
+	return attempt < rp.cfg.MaxAttempts && rp.on[class]
+}
+
+// backoff returns how long to wait before the retry-th retry: the base backoff doubled
+// for every retry, up to the max backoff, of which a random half is waited.
+func (rp *retryPolicy) backoff(retry int) time.Duration {
+	d := rp.cfg.BaseBackoff << (retry - 1)
+	if d > rp.cfg.MaxBackoff || d <= 0 {
+		d = rp.cfg.MaxBackoff
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// wait waits for d and reports whether ctx ended first.
+func wait(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	rp := newRetryPolicy(config.UpstreamRetryConfig{
+		Enabled:     true,
+		MaxAttempts: 10,
+		BaseBackoff: 10 * time.Millisecond,
+		MaxBackoff:  40 * time.Millisecond,
+	})
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 5 * time.Millisecond, 10 * time.Millisecond},
+		{2, 10 * time.Millisecond, 20 * time.Millisecond},
+		{3, 20 * time.Millisecond, 40 * time.Millisecond},
+		{8, 20 * time.Millisecond, 40 * time.Millisecond},
+		{100, 20 * time.Millisecond, 40 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := rp.backoff(tt.retry); d < tt.min || d > tt.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryFailover(t *testing.T) {
+	var resets atomic.Int32
+	reset := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resets.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	t.Cleanup(reset.Close)
+	ok := upstream(t, http.StatusOK, `{"data":{"hello":"world"}}`)
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+reset.URL+`
+    weight: 1
+    capabilities: [query]
+  - url: `+ok.URL+`
+    weight: 1
+    capabilities: [query]
+retry:
+  enabled: true
+  max_attempts: 2
+  base_backoff: 1ms
+`)
+
+	for i := 0; i < 10; i++ {
+		w := query(p, "{ hello }")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"hello":"world"`) {
+			t.Fatalf("query %d: status %d, body %s", i, w.Code, w.Body.String())
+		}
+	}
+	if resets.Load() == 0 {
+		t.Fatal("no query was sent to the upstream resetting connections")
+	}
+}
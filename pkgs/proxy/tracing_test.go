@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans makes p record its spans and returns the recorder.
+func recordSpans(p *Proxy) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	p.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	return recorder
+}
+
+// spans returns the ended spans by name.
+func spans(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		byName[s.Name()] = s
+	}
+	return byName
+}
+
+func TestTracing(t *testing.T) {
+	propagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagator) })
+
+	var traceparent string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"x":1}}`))
+	}))
+	t.Cleanup(s.Close)
+
+	p := newTestProxy(t, `
+upstreams:
+  - url: `+s.URL+`
+    weight: 1
+    capabilities: [query]
+`)
+	recorder := recordSpans(p)
+
+	if w := query(p, "query Q { x }"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	byName := spans(recorder)
+	for _, name := range []string{"query Q", "graphql.parse", "graphql.route", "graphql.upstream", "graphql.response"} {
+		if byName[name] == nil {
+			t.Fatalf("no %q span in %v", name, byName)
+		}
+	}
+	request, upstream := byName["query Q"], byName["graphql.upstream"]
+	if request.Status().Code == codes.Error || upstream.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("request span %+v, upstream span parent %v", request.Status(), upstream.Parent())
+	}
+	if want := "00-" + upstream.SpanContext().TraceID().String() + "-" + upstream.SpanContext().SpanID().String() + "-01"; traceparent != want {
+		t.Errorf("upstream got traceparent %q, want %q", traceparent, want)
+	}
+}
+
+func TestTracingErrors(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		upstream string
+		query    string
+		status   int
+		failed   []string
+	}{
+		{
+			desc:     "Invalid operation",
+			upstream: "http://localhost:1",
+			query:    "{",
+			status:   http.StatusBadRequest,
+			failed:   []string{"graphql.request", "graphql.parse"},
+		},
+		{
+			desc:     "Upstream request not created",
+			upstream: "http://[::1",
+			query:    "query Q { x }",
+			status:   http.StatusInternalServerError,
+			failed:   []string{"query Q", "graphql.upstream"},
+		},
+		{
+			desc:     "Upstream unreachable",
+			upstream: "http://127.0.0.1:1",
+			query:    "query Q { x }",
+			status:   http.StatusBadGateway,
+			failed:   []string{"query Q", "graphql.upstream"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			p := newTestProxy(t, `
+upstreams:
+  - url: `+tC.upstream+`
+    weight: 1
+    capabilities: [query]
+`)
+			recorder := recordSpans(p)
+			if w := query(p, tC.query); w.Code != tC.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tC.status, w.Body)
+			}
+			byName := spans(recorder)
+			for _, name := range tC.failed {
+				if s := byName[name]; s == nil || s.Status().Code != codes.Error || len(s.Events()) == 0 {
+					t.Errorf("%q span not marked as failed: %v", name, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/graphql"
+	"github.com/abdullah2993/graphql-proxy/pkgs/identity"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// CostRule is the rule name the requests the cost limiter counts are recorded under.
+const CostRule = "cost_limit"
+
+// CostLimiter takes the estimated cost of every query from the budget of its key, a token
+// bucket that refills over time. A nil CostLimiter allows every request.
+type CostLimiter struct {
+	cfg     config.CostLimitConfig
+	source  *identity.Source
+	model   graphql.CostModel
+	store   *store
+	metrics *metrics.Metrics
+}
+
+// NewCostLimiter returns a cost limiter that records the requests it counts in m, or nil
+// when cost limiting is disabled.
+func NewCostLimiter(cfg config.CostLimitConfig, m *metrics.Metrics) (*CostLimiter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	source, err := parseSource(cfg.Key, cfg.JWTSecret)
+	if err != nil {
+		return nil, fmt.Errorf("cost limit: %w", err)
+	}
+	model := graphql.CostModel{
+		ObjectCost:      cfg.ObjectCost,
+		ScalarCost:      cfg.ScalarCost,
+		MutationCost:    cfg.MutationCost,
+		ListArguments:   cfg.ListArguments,
+		DefaultListSize: cfg.DefaultListSize,
+		MaxDepth:        cfg.MaxDepth,
+		MaxSelections:   cfg.MaxSelections,
+	}
+	if cfg.Schema != "" {
+		if model.Schema, err = graphql.LoadSchema(cfg.Schema); err != nil {
+			return nil, fmt.Errorf("cost limit: %w", err)
+		}
+	}
+	return &CostLimiter{
+		cfg:     cfg,
+		source:  source,
+		model:   model,
+		store:   newStore(config.RateLimitTokenBucket, cfg.Budget, cfg.Period, cfg.Burst),
+		metrics: m,
+	}, nil
+}
+
+// CostDecision is the outcome of checking the cost of a query against the budget of its
+// key. Limit is the most the budget holds and Remaining what is left of it. Key is hashed
+// unless PlainKeys is set.
+type CostDecision struct {
+	Result
+	Key  string
+	Cost int64
+}
+
+// Check estimates the cost of op and takes it from the budget of the key of the request.
+// Queries that cost more than is left are not allowed and take nothing. An error is
+// returned for queries too large or deeply nested to be estimated, which are not allowed
+// either.
+func (l *CostLimiter) Check(r *http.Request, doc *ast.QueryDocument, op *ast.OperationDefinition, variables map[string]interface{}) (CostDecision, error) {
+	d := CostDecision{Result: Result{Allowed: true}}
+	if l == nil {
+		return d, nil
+	}
+	key := l.source.Key(r, variables)
+	d.Key = reportedKey(l.source, key, l.cfg.PlainKeys)
+	cost, err := l.model.Cost(doc, op, variables)
+	if err != nil {
+		l.metrics.RecordRateLimit(CostRule, d.Key, "", true)
+		return CostDecision{Key: d.Key}, err
+	}
+	d.Cost = cost
+	d.Result = l.store.take(key, float64(d.Cost), time.Now())
+	l.metrics.RecordRateLimit(CostRule, d.Key, "", !d.Allowed)
+	return d, nil
+}
+
+// TooExpensive reports whether the query costs more than the budget can ever hold.
+func (d CostDecision) TooExpensive() bool {
+	return d.Cost > d.Limit
+}
+
+// SetHeaders sets Retry-After when the query was not allowed but will be once the budget
+// refilled.
+func (d CostDecision) SetHeaders(h http.Header) {
+	if !d.Allowed && !d.TooExpensive() {
+		h.Set("Retry-After", strconv.FormatInt(d.RetryAfterSeconds(), 10))
+	}
+}
+
+// RetryAfterSeconds returns RetryAfter in whole seconds, rounded up.
+func (d CostDecision) RetryAfterSeconds() int64 {
+	return ceilSeconds(d.RetryAfter)
+}
+
+// Extension returns the cost of the query and the state of the budget for the extensions
+// of the response.
+func (d CostDecision) Extension() map[string]interface{} {
+	return map[string]interface{}{
+		"requested":     d.Cost,
+		"limit":         d.Limit,
+		"remaining":     d.Remaining,
+		"reset_seconds": ceilSeconds(d.Reset),
+	}
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+	"github.com/abdullah2993/graphql-proxy/pkgs/identity"
+	"github.com/abdullah2993/graphql-proxy/pkgs/metrics"
+)
+
+// Limiter checks requests against the configured rules. A nil Limiter allows every
+// request.
+type Limiter struct {
+	rules   []*rule
+	metrics *metrics.Metrics
+}
+
+type rule struct {
+	cfg        config.RateLimitRule
+	source     *identity.Source
+	operations map[string]bool
+	store      *store
+}
+
+// New returns a limiter for the rules of cfg that records the requests every rule counts
+// in m, or nil when rate limiting is disabled.
+func New(cfg config.RateLimitConfig, m *metrics.Metrics) (*Limiter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	l := &Limiter{metrics: m}
+	for _, rc := range cfg.Rules {
+		source, err := parseSource(rc.Key, cfg.JWTSecret)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit rule %s: %w", rc.Name, err)
+		}
+		r := &rule{
+			cfg:    rc,
+			source: source,
+			store:  newStore(rc.Algorithm, rc.Limit, rc.Period, rc.Burst),
+		}
+		if len(rc.Operations) > 0 {
+			r.operations = make(map[string]bool, len(rc.Operations))
+			for _, name := range rc.Operations {
+				r.operations[name] = true
+			}
+		}
+		l.rules = append(l.rules, r)
+	}
+	return l, nil
+}
+
+// parseSource parses the source of keys, which only takes claims from tokens signed with
+// secret when one is given.
+func parseSource(spec, secret string) (*identity.Source, error) {
+	source, err := identity.ParseSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		source = source.Verified([]byte(secret))
+	}
+	return source, nil
+}
+
+// reportedKey returns key as it is reported in metrics and logs: hashed, unless it is an
+// address or plain keys were asked for.
+func reportedKey(source *identity.Source, key string, plain bool) string {
+	if plain || source.Kind() == identity.KindIP {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// parsed reports whether the rule needs the parsed GraphQL request, for its operation
+// name or variables.
+func (r *rule) parsed() bool {
+	return r.operations != nil || r.cfg.PerOperation || r.source.Kind() == identity.KindVariable
+}
+
+// Decision is the outcome of checking a request against the rules.
+type Decision struct {
+	Result
+	// Rule is the rule that limited the request or, when it was allowed, the one with
+	// the least quota left. Key and Operation are what the rule counted the request
+	// under, Key is hashed unless the rule has PlainKeys. Rule is empty when no rule
+	// applies to the request.
+	Rule      string
+	Key       string
+	Operation string
+	Period    time.Duration
+}
+
+// CheckRequest counts a request against the rules that only need the HTTP request, so
+// that requests are limited before they are parsed. The request is allowed when every
+// such rule allows it; the rules after the first that limits it do not count it, so that
+// a client over one limit does not spend the quota of its other rules. Requests without a
+// key share the quota of the empty key.
+func (l *Limiter) CheckRequest(r *http.Request) Decision {
+	return l.check(r, nil, "", false)
+}
+
+// CheckOperation counts a parsed request for an operation against the rules that need
+// its operation name or variables, and that apply to the operation.
+func (l *Limiter) CheckOperation(r *http.Request, variables map[string]interface{}, operationName string) Decision {
+	return l.check(r, variables, operationName, true)
+}
+
+func (l *Limiter) check(r *http.Request, variables map[string]interface{}, operationName string, parsed bool) Decision {
+	d := Decision{Result: Result{Allowed: true}}
+	if l == nil {
+		return d
+	}
+	now := time.Now()
+	for _, rule := range l.rules {
+		if rule.parsed() != parsed || rule.operations != nil && !rule.operations[operationName] {
+			continue
+		}
+		key, operation := rule.source.Key(r, variables), ""
+		storeKey := key
+		if rule.cfg.PerOperation {
+			operation = operationName
+			storeKey = key + "\x00" + operationName
+		}
+		res := rule.store.take(storeKey, 1, now)
+		key = reportedKey(rule.source, key, rule.cfg.PlainKeys)
+		l.metrics.RecordRateLimit(rule.cfg.Name, key, operation, !res.Allowed)
+		d = d.Merge(Decision{Result: res, Rule: rule.cfg.Name, Key: key, Operation: operation, Period: rule.cfg.Period})
+		if !d.Allowed {
+			break
+		}
+	}
+	return d
+}
+
+// Merge returns the decision of d and o that limited the request or, when both allowed
+// it, the one with the least quota left.
+func (d Decision) Merge(o Decision) Decision {
+	if o.Rule == "" {
+		return d
+	}
+	if d.Rule == "" || !o.Allowed && d.Allowed || o.Allowed == d.Allowed && o.Remaining < d.Remaining {
+		return o
+	}
+	return d
+}
+
+// SetHeaders sets the RateLimit headers of the IETF draft for the decision, and
+// Retry-After when the request was not allowed.
+func (d Decision) SetHeaders(h http.Header) {
+	if d.Rule == "" {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.FormatInt(d.Limit, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(d.Remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(d.Reset), 10))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", d.Limit, ceilSeconds(d.Period)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(d.RetryAfterSeconds(), 10))
+	}
+}
+
+// RetryAfterSeconds returns RetryAfter in whole seconds, rounded up.
+func (d Decision) RetryAfterSeconds() int64 {
+	return ceilSeconds(d.RetryAfter)
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
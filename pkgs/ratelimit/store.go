@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+// Result is the state of the quota of a key after a request was counted against it.
+type Result struct {
+	Allowed bool
+	// Limit is the quota of the key and Remaining what is left of it.
+	Limit     int64
+	Remaining int64
+	// Reset is the time until the whole quota is available again.
+	Reset time.Duration
+	// RetryAfter is the time until a request that was not allowed would be.
+	RetryAfter time.Duration
+}
+
+// store keeps the quota of every key of a rule.
+type store struct {
+	algorithm string
+	limit     float64
+	period    time.Duration
+	burst     float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+func newStore(algorithm string, limit int, period time.Duration, burst int) *store {
+	s := &store{
+		algorithm: algorithm,
+		limit:     float64(limit),
+		period:    period,
+		burst:     float64(burst),
+	}
+	if algorithm == config.RateLimitSlidingWindow {
+		s.windows = make(map[string]*window)
+	} else {
+		s.buckets = make(map[string]*bucket)
+	}
+	return s
+}
+
+// take counts a request of cost against the quota of key at now, unless that would
+// exceed it.
+func (s *store) take(key string, cost float64, now time.Time) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= s.period {
+		s.sweep(now)
+	}
+	if s.windows != nil {
+		w, ok := s.windows[key]
+		if !ok {
+			w = &window{start: now.Truncate(s.period)}
+			s.windows[key] = w
+		}
+		return w.take(s, cost, now)
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: s.burst, last: now}
+		s.buckets[key] = b
+	}
+	return b.take(s, cost, now)
+}
+
+// sweep forgets the keys whose quota is whole again, which is what an unknown key starts
+// with.
+func (s *store) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= 2*s.period {
+			delete(s.windows, key)
+		}
+	}
+	for key, b := range s.buckets {
+		if b.refill(s, now); b.tokens >= s.burst {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// bucket is a token bucket that holds up to burst tokens and gains limit tokens every
+// period. Every request takes as many tokens as it costs.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(s *store, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(s.burst, b.tokens+s.rate()*elapsed.Seconds())
+		b.last = now
+	}
+}
+
+func (b *bucket) take(s *store, cost float64, now time.Time) Result {
+	b.refill(s, now)
+	res := Result{Limit: int64(s.burst)}
+	if b.tokens >= cost {
+		b.tokens -= cost
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((cost - b.tokens) / s.rate())
+	}
+	res.Remaining = int64(b.tokens)
+	res.Reset = seconds((s.burst - b.tokens) / s.rate())
+	return res
+}
+
+// rate returns the tokens gained per second.
+func (s *store) rate() float64 {
+	return s.limit / s.period.Seconds()
+}
+
+// window counts the requests of the current and the previous period. Requests are
+// allowed while the ones of the current period, plus those of the previous period
+// weighted by how much of it still lies within one period from now, stay within limit.
+type window struct {
+	start    time.Time
+	current  float64
+	previous float64
+}
+
+func (w *window) take(s *store, cost float64, now time.Time) Result {
+	switch start := now.Truncate(s.period); {
+	case start.Sub(w.start) >= 2*s.period:
+		w.start, w.current, w.previous = start, 0, 0
+	case start.After(w.start):
+		w.start, w.current, w.previous = start, 0, w.current
+	}
+
+	elapsed := now.Sub(w.start)
+	overlap := 1 - elapsed.Seconds()/s.period.Seconds()
+	used := w.previous*overlap + w.current
+	res := Result{Limit: int64(s.limit), Reset: w.start.Add(s.period).Sub(now)}
+	if used+cost <= s.limit {
+		w.current += cost
+		used += cost
+		res.Allowed = true
+	} else {
+		res.RetryAfter = res.Reset
+		// Waiting for the previous period to slide out may be enough.
+		if free := s.limit - w.current - cost; free >= 0 && w.previous > 0 {
+			res.RetryAfter = seconds((1-free/w.previous)*s.period.Seconds()) - elapsed
+		}
+	}
+	res.Remaining = int64(math.Max(s.limit-used, 0))
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
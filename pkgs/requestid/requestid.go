@@ -0,0 +1,102 @@
+package requestid
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// maxLength is the longest incoming request ID that is accepted.
+const maxLength = 128
+
+// Resolver decides the ID of a request. An incoming ID is only used when it was sent by a
+// trusted client and is well formed, otherwise a new ID is generated.
+type Resolver struct {
+	header  string
+	trust   bool
+	trusted []*net.IPNet
+}
+
+// NewResolver returns a resolver reading IDs from header. When trust is false incoming IDs are
+// ignored. When trust is true and trustedCIDRs is empty every client is trusted.
+func NewResolver(header string, trust bool, trustedCIDRs []string) (*Resolver, error) {
+	r := &Resolver{
+		header: http.CanonicalHeaderKey(header),
+		trust:  trust,
+	}
+
+	for _, cidr := range trustedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted network %q: %w", cidr, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+
+	return r, nil
+}
+
+// Header returns the canonical name of the header carrying the request ID.
+func (r *Resolver) Header() string {
+	return r.header
+}
+
+// Resolve returns the ID of the request.
+func (r *Resolver) Resolve(req *http.Request) (string, error) {
+	if id := req.Header.Get(r.header); id != "" && r.trust && valid(id) && r.trustedAddr(req.RemoteAddr) {
+		return id, nil
+	}
+	return New()
+}
+
+func (r *Resolver) trustedAddr(remoteAddr string) bool {
+	if len(r.trusted) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// valid reports whether id is short and only made of characters that are safe to log and
+// forward in a header.
+func valid(id string) bool {
+	if len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// New returns a new UUIDv7. IDs generated in the same millisecond are ordered by a
+// counter, so that they sort in generation order.
+func New() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", fmt.Errorf("generating request ID: %w", err)
+	}
+	return id.String(), nil
+}
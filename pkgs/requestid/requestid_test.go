@@ -0,0 +1,110 @@
+package requestid
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNew(t *testing.T) {
+	seen := make(map[string]bool)
+	prev := ""
+	for i := 0; i < 10000; i++ {
+		id, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := uuid.Parse(id)
+		if err != nil {
+			t.Fatalf("%q is not a UUID: %v", id, err)
+		}
+		if u.Version() != 7 || u.Variant() != uuid.RFC4122 {
+			t.Fatalf("%q has version %d and variant %v", id, u.Version(), u.Variant())
+		}
+		if seen[id] {
+			t.Fatalf("%q generated twice", id)
+		}
+		seen[id] = true
+		if id <= prev {
+			t.Fatalf("%q generated after %q", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestValid(t *testing.T) {
+	testCases := []struct {
+		id    string
+		valid bool
+	}{
+		{"0192b0a4-7c1e-7d2a-9a53-3c1f0e9d4b21", true},
+		{"abc_DEF.123:/+=", true},
+		{strings.Repeat("a", maxLength), true},
+		{strings.Repeat("a", maxLength+1), false},
+		{"id with spaces", false},
+		{"id\r\nX-Injected: 1", false},
+		{"ünicode", false},
+	}
+	for _, tC := range testCases {
+		if got := valid(tC.id); got != tC.valid {
+			t.Errorf("valid(%q) = %v, want %v", tC.id, got, tC.valid)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		trust      bool
+		networks   []string
+		remoteAddr string
+		id         string
+		kept       bool
+	}{
+		{desc: "Incoming IDs are ignored by default", remoteAddr: "10.0.0.1:1234", id: "abc"},
+		{desc: "Every client is trusted without networks", trust: true, remoteAddr: "192.0.2.1:1234", id: "abc", kept: true},
+		{desc: "Clients in a trusted network", trust: true, networks: []string{"10.0.0.0/8", "fd00::/8"}, remoteAddr: "10.1.2.3:1234", id: "abc", kept: true},
+		{desc: "IPv6 clients in a trusted network", trust: true, networks: []string{"10.0.0.0/8", "fd00::/8"}, remoteAddr: "[fd00::1]:1234", id: "abc", kept: true},
+		{desc: "Clients outside the trusted networks", trust: true, networks: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.1:1234", id: "abc"},
+		{desc: "Addresses without a port", trust: true, networks: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.1", id: "abc", kept: true},
+		{desc: "Unparseable addresses", trust: true, networks: []string{"10.0.0.0/8"}, remoteAddr: "@", id: "abc"},
+		{desc: "Malformed IDs", trust: true, remoteAddr: "10.0.0.1:1234", id: "a b"},
+		{desc: "Missing IDs", trust: true, remoteAddr: "10.0.0.1:1234"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			r, err := NewResolver("x-request-id", tC.trust, tC.networks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Header() != "X-Request-Id" {
+				t.Errorf("Header() = %q", r.Header())
+			}
+			req := httptest.NewRequest("POST", "/graphql", nil)
+			req.RemoteAddr = tC.remoteAddr
+			if tC.id != "" {
+				req.Header.Set("X-Request-ID", tC.id)
+			}
+			id, err := r.Resolve(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := id == tC.id; kept != tC.kept {
+				t.Errorf("got ID %q for incoming %q, kept %v, want %v", id, tC.id, kept, tC.kept)
+			}
+			if !tC.kept {
+				if _, err := uuid.Parse(id); err != nil {
+					t.Errorf("generated ID %q is not a UUID", id)
+				}
+			}
+		})
+	}
+}
+
+func TestNewResolverInvalidNetwork(t *testing.T) {
+	if _, err := NewResolver("X-Request-ID", true, []string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for an invalid network")
+	}
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/config"
+)
+
+func TestSetup(t *testing.T) {
+	var exported int
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			exported++
+		}
+	}))
+	defer collector.Close()
+	endpoint := strings.TrimPrefix(collector.URL, "http://")
+
+	zero, one := 0.0, 1.0
+	testCases := []struct {
+		desc    string
+		cfg     config.TracingConfig
+		sampled bool
+	}{
+		{"Disabled", config.TracingConfig{}, false},
+		{"Sampling every trace", config.TracingConfig{Enabled: true, Endpoint: endpoint, Insecure: true, ServiceName: "test", SampleRatio: &one}, true},
+		{"Sampling turned off", config.TracingConfig{Enabled: true, Endpoint: endpoint, Insecure: true, ServiceName: "test", SampleRatio: &zero}, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), tC.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fields := otel.GetTextMapPropagator().Fields(); len(fields) != 3 {
+				t.Errorf("propagated fields %v, want traceparent, tracestate and baggage", fields)
+			}
+			_, span := otel.Tracer("test").Start(context.Background(), "span")
+			span.End()
+			if sampled := span.SpanContext().IsSampled(); sampled != tC.sampled {
+				t.Errorf("sampled = %v, want %v", sampled, tC.sampled)
+			}
+
+			exported = 0
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if tC.sampled != (exported == 1) {
+				t.Errorf("exported %d batches", exported)
+			}
+		})
+	}
+}
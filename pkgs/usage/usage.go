@@ -0,0 +1,280 @@
+package usage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/validator"
+	_ "github.com/vektah/gqlparser/v2/validator/rules"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/graphql"
+)
+
+// maxCachedOperations bounds the number of operations whose field references are cached.
+// The oldest operation is evicted to make room for a new one.
+const maxCachedOperations = 10000
+
+// FieldUsage is the number of requests that referenced a field of a type for a client and
+// operation. Anonymous operations are counted under their operation hash.
+type FieldUsage struct {
+	Type      string `json:"type"`
+	Field     string `json:"field"`
+	Client    string `json:"client"`
+	Operation string `json:"operation"`
+	Count     int64  `json:"count"`
+}
+
+// Filter selects the usage returned by Tracker.Usage. Empty fields match everything.
+type Filter struct {
+	Type      string
+	Field     string
+	Client    string
+	Operation string
+}
+
+type key struct {
+	typ       string
+	field     string
+	client    string
+	operation string
+}
+
+type coordinate struct {
+	typ   string
+	field string
+}
+
+// operation is the cached outcome of validating an operation: the fields it references, or
+// why it does not match the schema.
+type operation struct {
+	fields []coordinate
+	err    error
+}
+
+type bucket struct {
+	start  int64
+	counts map[key]int64
+}
+
+// Tracker records field usage of operations validated against a schema and retains
+// the counts in a rolling window made of fixed size buckets.
+type Tracker struct {
+	schema     *ast.Schema
+	resolution time.Duration
+
+	mu         sync.Mutex
+	buckets    []bucket
+	operations map[string]operation
+	// cached holds the keys of operations in the order they were cached, as a ring whose
+	// next slot is the oldest key once it is full.
+	cached []string
+	next   int
+}
+
+func New(schema *ast.Schema, window, resolution time.Duration) *Tracker {
+	n := int(window / resolution)
+	if n < 1 {
+		n = 1
+	}
+
+	return &Tracker{
+		schema:     schema,
+		resolution: resolution,
+		buckets:    make([]bucket, n),
+		operations: make(map[string]operation),
+	}
+}
+
+// Record validates the operation against the schema and counts every field it references
+// once. The hash is used to cache the referenced fields of an operation and names
+// anonymous operations. Only the operation and the fragments it spreads are validated,
+// so that what else the document holds does not decide whether the operation counts.
+func (t *Tracker) Record(client string, doc *ast.QueryDocument, op *ast.OperationDefinition, hash string) error {
+	// The signature hash leaves out literals, which can make an operation invalid, so
+	// invalid operations are cached under the hash of their query instead.
+	invalid := invalidKey(op)
+
+	t.mu.Lock()
+	cached, ok := t.operations[hash]
+	if !ok && invalid != "" {
+		cached, ok = t.operations[invalid]
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		cacheKey := hash
+		if errs := validator.Validate(t.schema, graphql.OperationDocument(doc, op)); len(errs) > 0 {
+			cached.err, cacheKey = errs, invalid
+		} else {
+			cached.fields = referencedFields(doc, op)
+		}
+		if cacheKey != "" {
+			t.mu.Lock()
+			t.cache(cacheKey, cached)
+			t.mu.Unlock()
+		}
+	}
+	if cached.err != nil {
+		return cached.err
+	}
+
+	name := op.Name
+	if name == "" {
+		name = hash
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.current(time.Now())
+	for _, f := range cached.fields {
+		b.counts[key{typ: f.typ, field: f.field, client: client, operation: name}]++
+	}
+
+	return nil
+}
+
+// cache stores an operation under key, evicting the oldest one when the cache is full.
+func (t *Tracker) cache(k string, op operation) {
+	if _, ok := t.operations[k]; ok {
+		t.operations[k] = op
+		return
+	}
+	if len(t.cached) < maxCachedOperations {
+		t.cached = append(t.cached, k)
+	} else {
+		delete(t.operations, t.cached[t.next])
+		t.cached[t.next] = k
+		t.next = (t.next + 1) % len(t.cached)
+	}
+	t.operations[k] = op
+}
+
+// invalidKey returns the key an invalid operation is cached under, or "" when its query is
+// unknown.
+func invalidKey(op *ast.OperationDefinition) string {
+	if op.Position == nil || op.Position.Src == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(op.Name + "\x00" + op.Position.Src.Input))
+	return "invalid:" + hex.EncodeToString(sum[:])
+}
+
+// current returns the bucket for now, resetting it when it holds counts from outside the window.
+func (t *Tracker) current(now time.Time) *bucket {
+	start := now.UnixNano() / int64(t.resolution)
+	b := &t.buckets[start%int64(len(t.buckets))]
+	if b.start != start || b.counts == nil {
+		b.start = start
+		b.counts = make(map[key]int64)
+	}
+	return b
+}
+
+// Usage returns the usage within the window that matches the filter, sorted by type,
+// field, client and operation.
+func (t *Tracker) Usage(filter Filter) []FieldUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldest := time.Now().UnixNano()/int64(t.resolution) - int64(len(t.buckets)) + 1
+	totals := make(map[key]int64)
+	for _, b := range t.buckets {
+		if b.counts == nil || b.start < oldest {
+			continue
+		}
+		for k, count := range b.counts {
+			if filter.matches(k) {
+				totals[k] += count
+			}
+		}
+	}
+
+	usage := make([]FieldUsage, 0, len(totals))
+	for k, count := range totals {
+		usage = append(usage, FieldUsage{
+			Type:      k.typ,
+			Field:     k.field,
+			Client:    k.client,
+			Operation: k.operation,
+			Count:     count,
+		})
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		a, b := usage[i], usage[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		if a.Client != b.Client {
+			return a.Client < b.Client
+		}
+		return a.Operation < b.Operation
+	})
+
+	return usage
+}
+
+// ParseFilter builds a filter from a schema coordinate such as "User.legacyId" or "User".
+func ParseFilter(coordinate, client, operation string) Filter {
+	f := Filter{Client: client, Operation: operation}
+	if i := strings.IndexByte(coordinate, '.'); i >= 0 {
+		f.Type, f.Field = coordinate[:i], coordinate[i+1:]
+	} else {
+		f.Type = coordinate
+	}
+	return f
+}
+
+func (f Filter) matches(k key) bool {
+	return (f.Type == "" || f.Type == k.typ) &&
+		(f.Field == "" || f.Field == k.field) &&
+		(f.Client == "" || f.Client == k.client) &&
+		(f.Operation == "" || f.Operation == k.operation)
+}
+
+// referencedFields returns the distinct fields referenced by a validated operation,
+// including the fields of the fragments it spreads.
+func referencedFields(doc *ast.QueryDocument, op *ast.OperationDefinition) []coordinate {
+	seen := make(map[coordinate]bool)
+	visited := make(map[string]bool)
+	var fields []coordinate
+
+	var walk func(ss ast.SelectionSet)
+	walk = func(ss ast.SelectionSet) {
+		for _, sel := range ss {
+			switch s := sel.(type) {
+			case *ast.Field:
+				if s.ObjectDefinition != nil && !strings.HasPrefix(s.Name, "__") {
+					c := coordinate{typ: s.ObjectDefinition.Name, field: s.Name}
+					if !seen[c] {
+						seen[c] = true
+						fields = append(fields, c)
+					}
+				}
+				walk(s.SelectionSet)
+			case *ast.InlineFragment:
+				walk(s.SelectionSet)
+			case *ast.FragmentSpread:
+				if visited[s.Name] {
+					continue
+				}
+				visited[s.Name] = true
+				if f := doc.Fragments.ForName(s.Name); f != nil {
+					walk(f.SelectionSet)
+				}
+			}
+		}
+	}
+	walk(op.SelectionSet)
+
+	return fields
+}
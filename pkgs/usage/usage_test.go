@@ -0,0 +1,198 @@
+package usage
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+
+	"github.com/abdullah2993/graphql-proxy/pkgs/graphql"
+)
+
+func newTestTracker(t *testing.T) *Tracker {
+	t.Helper()
+	schema, err := gqlparser.LoadSchema(&ast.Source{Input: `
+type Query { user(id: ID!): User users: [User!]! }
+type User { id: ID! name: String legacyId: Int friends: [User!]! }
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(schema, time.Hour, time.Minute)
+}
+
+// record records query for client and returns the hash it was recorded under.
+func record(t *testing.T, tr *Tracker, client, query string) (string, error) {
+	t.Helper()
+	doc, op, err := (&graphql.Request{Query: query}).ParseOperation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := graphql.SignatureHash(graphql.Signature(doc, op))
+	return hash, tr.Record(client, doc, op, hash)
+}
+
+func TestRecord(t *testing.T) {
+	tr := newTestTracker(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := record(t, tr, "web", "query Profile { user(id: 1) { id name ...F friends { ...F } } } fragment F on User { name }"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := record(t, tr, "ios", "query Legacy { users { legacyId } }"); err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := record(t, tr, "ios", "{ users { id } }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := record(t, tr, "ios", "{ user(id: 1) { id } }")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FieldUsage{
+		{Type: "Query", Field: "user", Client: "ios", Operation: other, Count: 1},
+		{Type: "Query", Field: "user", Client: "web", Operation: "Profile", Count: 2},
+		{Type: "Query", Field: "users", Client: "ios", Operation: "Legacy", Count: 1},
+		{Type: "Query", Field: "users", Client: "ios", Operation: anonymous, Count: 1},
+	}
+	if got := tr.Usage(ParseFilter("Query", "", "")); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query usage:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	want = []FieldUsage{{Type: "User", Field: "name", Client: "web", Operation: "Profile", Count: 2}}
+	if got := tr.Usage(ParseFilter("User.name", "", "")); !reflect.DeepEqual(got, want) {
+		t.Errorf("User.name usage:\ngot  %+v\nwant %+v", got, want)
+	}
+	if got := tr.Usage(ParseFilter("User", "ios", "Legacy")); len(got) != 1 || got[0].Field != "legacyId" {
+		t.Errorf("filtered usage: %+v", got)
+	}
+}
+
+func TestRecordInvalidOperation(t *testing.T) {
+	tr := newTestTracker(t)
+
+	for i := 0; i < 2; i++ {
+		hash, err := record(t, tr, "web", "{ user(id: 1) { nope } }")
+		if err == nil {
+			t.Fatal("expected an error for a field missing from the schema")
+		}
+		if _, ok := tr.operations[hash]; ok || len(tr.operations) != 1 {
+			t.Fatalf("invalid operation cached under its signature hash: %v", tr.operations)
+		}
+	}
+	if got := tr.Usage(Filter{}); len(got) != 0 {
+		t.Errorf("usage recorded for an invalid operation: %+v", got)
+	}
+}
+
+func TestRecordValidatesOnlyTheOperation(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		invalid string
+		valid   string
+	}{
+		{
+			desc:    "Unused fragment",
+			invalid: "query Q { users { id } } fragment Unused on User { name }",
+			valid:   "query Q { users { id } }",
+		},
+		{
+			desc:    "Invalid sibling operation",
+			invalid: "query Q { users { id } } query Other { nope }",
+			valid:   "query Q { users { id } }",
+		},
+		{
+			desc:    "Invalid literal",
+			invalid: `query Q { user(id: 1.5) { id } }`,
+			valid:   `query Q { user(id: 1) { id } }`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			tr := newTestTracker(t)
+			doc, op, err := (&graphql.Request{Query: tC.invalid, OperationName: "Q"}).ParseOperation()
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := graphql.SignatureHash(graphql.Signature(doc, op))
+			first := tr.Record("web", doc, op, hash)
+
+			valid, err := record(t, tr, "ios", tC.valid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != hash {
+				t.Fatalf("signature hashes differ: %s and %s", hash, valid)
+			}
+			if got := tr.Usage(Filter{Client: "ios"}); len(got) == 0 {
+				t.Errorf("valid operation not counted after %v", first)
+			}
+		})
+	}
+}
+
+func TestCacheEvictsOldest(t *testing.T) {
+	tr := newTestTracker(t)
+	for i := 0; i < maxCachedOperations+10; i++ {
+		tr.cache(strconv.Itoa(i), operation{})
+	}
+	if len(tr.operations) != maxCachedOperations {
+		t.Fatalf("cached %d operations, want %d", len(tr.operations), maxCachedOperations)
+	}
+	for _, k := range []string{"0", "9"} {
+		if _, ok := tr.operations[k]; ok {
+			t.Errorf("operation %s not evicted", k)
+		}
+	}
+	for _, k := range []string{"10", strconv.Itoa(maxCachedOperations + 9)} {
+		if _, ok := tr.operations[k]; !ok {
+			t.Errorf("operation %s evicted", k)
+		}
+	}
+}
+
+func TestUsageWindow(t *testing.T) {
+	tr := newTestTracker(t)
+	if _, err := record(t, tr, "web", "query Q { users { id } }"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the counts back to just inside and then just outside the window.
+	now := time.Now().UnixNano() / int64(tr.resolution)
+	for i := range tr.buckets {
+		if tr.buckets[i].start == now {
+			tr.buckets[i].start = now - int64(len(tr.buckets)) + 1
+		}
+	}
+	if got := tr.Usage(Filter{}); len(got) != 2 {
+		t.Fatalf("usage within the window: %+v", got)
+	}
+	for i := range tr.buckets {
+		tr.buckets[i].start--
+	}
+	if got := tr.Usage(Filter{}); len(got) != 0 {
+		t.Errorf("usage outside the window: %+v", got)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	testCases := []struct {
+		coordinate string
+		want       Filter
+	}{
+		{"", Filter{Client: "c", Operation: "o"}},
+		{"User", Filter{Type: "User", Client: "c", Operation: "o"}},
+		{"User.legacyId", Filter{Type: "User", Field: "legacyId", Client: "c", Operation: "o"}},
+	}
+	for _, tC := range testCases {
+		if got := ParseFilter(tC.coordinate, "c", "o"); got != tC.want {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", tC.coordinate, got, tC.want)
+		}
+	}
+}